@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Simplici0/o.works/internal/pricing"
+)
+
+const (
+	apiPrefix = "/api/"
+	// maxAPIBodyBytes bounds JSON request bodies; catalog entries and pricing
+	// inputs are tiny.
+	maxAPIBodyBytes = 1 << 20
+)
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// calculateRequest is the body of POST /api/v1/quotes/calculate. The inputs
+// are used as sent; the stored catalog and rates are not consulted.
+type calculateRequest struct {
+	Item   pricing.ItemInput   `json:"item"`
+	Global pricing.GlobalInput `json:"global"`
+}
+
+// apiRoutes mounts the JSON API under /api/v1. Reads are open to every
+// signed-in user; writes follow the same roles as the admin pages and need a
+// read_write token. Calculating a price changes nothing, so it is not a write
+// even though it is a POST.
+func (s *server) apiRoutes(r chi.Router) {
+	r.Get("/openapi.json", s.handleAPIOpenAPI)
+	r.Get("/materials", s.handleAPIMaterialsList)
+	r.Get("/shipping-rates", s.handleAPIShippingRatesList)
+	r.Get("/packaging-rates", s.handleAPIPackagingRatesList)
+	r.Get("/rate-config", s.handleAPIRateConfigGet)
+	r.Post("/quotes/calculate", s.handleAPIQuoteCalculate)
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(roleAdmin, roleOperator), requireWriteScope)
+		r.Post("/materials", s.handleAPIMaterialsCreate)
+		r.Put("/materials/{id}", s.handleAPIMaterialsUpdate)
+		r.Post("/shipping-rates", s.handleAPIShippingRatesCreate)
+		r.Put("/shipping-rates/{id}", s.handleAPIShippingRatesUpdate)
+		r.Post("/packaging-rates", s.handleAPIPackagingRatesCreate)
+		r.Put("/packaging-rates/{id}", s.handleAPIPackagingRatesUpdate)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(roleAdmin), requireWriteScope)
+		r.Put("/rate-config", s.handleAPIRateConfigUpdate)
+	})
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "not found")
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+}
+
+func (s *server) handleAPIMaterialsList(w http.ResponseWriter, r *http.Request) {
+	materials, err := s.store().listMaterials()
+	if err != nil {
+		apiServerError(w, r, "failed to load materials", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, materials)
+}
+
+func (s *server) handleAPIMaterialsCreate(w http.ResponseWriter, r *http.Request) {
+	m := material{Active: true}
+	if !decodeAPIBody(w, r, &m) {
+		return
+	}
+	m = normalizeMaterial(m)
+	if err := validateMaterial(m); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	id, err := s.createMaterial(m)
+	if err != nil {
+		apiServerError(w, r, "failed to create material", err)
+		return
+	}
+
+	m.ID = id
+	writeJSON(w, http.StatusCreated, m)
+}
+
+func (s *server) handleAPIMaterialsUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var m material
+	if !decodeAPIBody(w, r, &m) {
+		return
+	}
+	m = normalizeMaterial(m)
+	if err := validateMaterial(m); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	found, err := s.updateMaterial(id, m)
+	if err != nil {
+		apiServerError(w, r, "failed to update material", err)
+		return
+	}
+	if !found {
+		writeAPIError(w, http.StatusNotFound, "material not found")
+		return
+	}
+
+	m.ID = id
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (s *server) handleAPIShippingRatesList(w http.ResponseWriter, r *http.Request) {
+	rates, err := s.store().listShippingRates()
+	if err != nil {
+		apiServerError(w, r, "failed to load shipping rates", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rates)
+}
+
+func (s *server) handleAPIShippingRatesCreate(w http.ResponseWriter, r *http.Request) {
+	rate := shippingRate{Active: true}
+	if !decodeAPIBody(w, r, &rate) {
+		return
+	}
+	rate = normalizeShippingRate(rate)
+	if err := validateShippingRate(rate); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	id, err := s.store().createShippingRate(rate)
+	if err != nil {
+		apiServerError(w, r, "failed to create shipping rate", err)
+		return
+	}
+
+	rate.ID = id
+	writeJSON(w, http.StatusCreated, rate)
+}
+
+func (s *server) handleAPIShippingRatesUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var rate shippingRate
+	if !decodeAPIBody(w, r, &rate) {
+		return
+	}
+	rate = normalizeShippingRate(rate)
+	if err := validateShippingRate(rate); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	found, err := s.store().updateShippingRate(id, rate)
+	if err != nil {
+		apiServerError(w, r, "failed to update shipping rate", err)
+		return
+	}
+	if !found {
+		writeAPIError(w, http.StatusNotFound, "shipping rate not found")
+		return
+	}
+
+	rate.ID = id
+	writeJSON(w, http.StatusOK, rate)
+}
+
+func (s *server) handleAPIPackagingRatesList(w http.ResponseWriter, r *http.Request) {
+	rates, err := s.store().listPackagingRates()
+	if err != nil {
+		apiServerError(w, r, "failed to load packaging rates", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rates)
+}
+
+func (s *server) handleAPIPackagingRatesCreate(w http.ResponseWriter, r *http.Request) {
+	rate := packagingRate{Active: true}
+	if !decodeAPIBody(w, r, &rate) {
+		return
+	}
+	rate = normalizePackagingRate(rate)
+	if err := validatePackagingRate(rate); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	id, err := s.store().createPackagingRate(rate)
+	if err != nil {
+		apiServerError(w, r, "failed to create packaging rate", err)
+		return
+	}
+
+	rate.ID = id
+	writeJSON(w, http.StatusCreated, rate)
+}
+
+func (s *server) handleAPIPackagingRatesUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var rate packagingRate
+	if !decodeAPIBody(w, r, &rate) {
+		return
+	}
+	rate = normalizePackagingRate(rate)
+	if err := validatePackagingRate(rate); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	found, err := s.store().updatePackagingRate(id, rate)
+	if err != nil {
+		apiServerError(w, r, "failed to update packaging rate", err)
+		return
+	}
+	if !found {
+		writeAPIError(w, http.StatusNotFound, "packaging rate not found")
+		return
+	}
+
+	rate.ID = id
+	writeJSON(w, http.StatusOK, rate)
+}
+
+func (s *server) handleAPIRateConfigGet(w http.ResponseWriter, r *http.Request) {
+	rates, err := s.store().getRateConfig()
+	if err != nil {
+		apiServerError(w, r, "failed to load rate config", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rates)
+}
+
+func (s *server) handleAPIRateConfigUpdate(w http.ResponseWriter, r *http.Request) {
+	var rates rateConfig
+	if !decodeAPIBody(w, r, &rates) {
+		return
+	}
+	rates.Currency = strings.ToUpper(strings.TrimSpace(rates.Currency))
+	if rates.Currency == "" {
+		current, err := s.store().getRateConfig()
+		if err != nil {
+			apiServerError(w, r, "failed to load rate config", err)
+			return
+		}
+		rates.Currency = current.Currency
+	}
+	rates.QuoteTerms = strings.TrimSpace(rates.QuoteTerms)
+	if err := validateRateConfig(rates); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	err := s.store().updateRateConfig(rates)
+	if errors.Is(err, errBaseCurrencyInUse) {
+		writeAPIError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err != nil {
+		apiServerError(w, r, "failed to save rate config", err)
+		return
+	}
+
+	s.handleAPIRateConfigGet(w, r)
+}
+
+func (s *server) handleAPIQuoteCalculate(w http.ResponseWriter, r *http.Request) {
+	var req calculateRequest
+	if !decodeAPIBody(w, r, &req) {
+		return
+	}
+	if err := validateCalculateRequest(req); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	s.metrics.quoteCalculated()
+	writeJSON(w, http.StatusOK, pricing.Calculate(req.Item, req.Global))
+}
+
+// validateCalculateRequest applies the limits of the quote form to raw pricing
+// inputs. Field names in messages are the JSON paths.
+func validateCalculateRequest(req calculateRequest) error {
+	item, global := req.Item, req.Global
+
+	checks := []error{
+		validatePositive(item.Grams, "item.grams"),
+		validateNonNegative(item.PrintMinutes, "item.print_minutes"),
+		validateNonNegative(item.LaborMinutes, "item.labor_minutes"),
+		validatePositive(item.Quantity, "item.quantity"),
+		validatePositive(item.CostPerKg, "item.cost_per_kg"),
+		validateNonNegative(global.MachineHourlyRate, "global.machine_hourly_rate"),
+		validateNonNegative(global.LaborPerMinute, "global.labor_per_minute"),
+		validateNonNegative(global.OverheadFixed, "global.overhead_fixed"),
+		validatePercent(global.OverheadPercent, "global.overhead_percent"),
+		validatePercent(global.FailureRatePercent, "global.failure_rate_percent"),
+		validatePercent(global.WastePercent, "global.waste_percent"),
+		validatePercent(global.MarginPercent, "global.margin_percent"),
+		validatePercent(global.TaxPercent, "global.tax_percent"),
+		validateNonNegative(global.PackagingCost, "global.packaging_cost"),
+		validateNonNegative(global.ShippingCost, "global.shipping_cost"),
+	}
+	for _, err := range checks {
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, rule := range global.TaxRules {
+		field := fmt.Sprintf("global.tax_rules[%d]", i)
+		if strings.TrimSpace(rule.Name) == "" {
+			return fmt.Errorf("%s.name es requerido", field)
+		}
+		if err := validatePercent(rule.Percent, field+".percent"); err != nil {
+			return err
+		}
+		if rule.Base != pricing.TaxBaseNet && rule.Base != pricing.TaxBaseTax {
+			return fmt.Errorf("%s.base debe ser net o tax", field)
+		}
+		if !rule.Withholding && rule.Base == pricing.TaxBaseTax {
+			return fmt.Errorf("%s: base tax solo aplica a retenciones", field)
+		}
+	}
+
+	return nil
+}
+
+func normalizeMaterial(m material) material {
+	m.Name = strings.TrimSpace(m.Name)
+	m.Notes = strings.TrimSpace(m.Notes)
+	return m
+}
+
+func normalizeShippingRate(rate shippingRate) shippingRate {
+	rate.Scope = strings.TrimSpace(rate.Scope)
+	rate.Country = strings.TrimSpace(rate.Country)
+	rate.City = strings.TrimSpace(rate.City)
+	rate.Notes = strings.TrimSpace(rate.Notes)
+	return rate
+}
+
+func normalizePackagingRate(rate packagingRate) packagingRate {
+	rate.Name = strings.TrimSpace(rate.Name)
+	rate.Notes = strings.TrimSpace(rate.Notes)
+	return rate
+}
+
+// decodeAPIBody decodes a single JSON object into v, rejecting unknown fields.
+// On failure it writes a 400 response and returns false.
+func decodeAPIBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodyBytes))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	if err := decoder.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body: unexpected data after object")
+		return false
+	}
+	return true
+}
+
+func apiIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid id")
+		return 0, false
+	}
+	return id, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, apiPrefix)
+}
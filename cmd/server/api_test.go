@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Simplici0/o.works/internal/pricing"
+)
+
+// newAPITestRouter mounts the API with a fixed session user, standing in for
+// authMiddleware.
+func newAPITestRouter(t *testing.T, role string) (*server, http.Handler) {
+	t.Helper()
+
+	srv := &server{db: newMigratedTestDB(t)}
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := sessionUser{UserID: 1, Email: "api@x.com", Role: role}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionUserContextKey{}, user)))
+		})
+	})
+	r.Route("/api/v1", srv.apiRoutes)
+
+	return srv, r
+}
+
+func serveAPI(t *testing.T, handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIQuoteCalculate(t *testing.T) {
+	_, handler := newAPITestRouter(t, roleViewer)
+
+	body := `{
+		"item": {"grams": 100, "print_minutes": 120, "labor_minutes": 10, "quantity": 2, "cost_per_kg": 80000},
+		"global": {"machine_hourly_rate": 3000, "labor_per_minute": 200, "margin_percent": 30, "tax_enabled": true, "tax_percent": 19}
+	}`
+	rec := serveAPI(t, handler, http.MethodPost, "/api/v1/quotes/calculate", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	var got pricing.Result
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	want := pricing.Calculate(
+		pricing.ItemInput{Grams: 100, PrintMinutes: 120, LaborMinutes: 10, Quantity: 2, CostPerKg: 80000},
+		pricing.GlobalInput{MachineHourlyRate: 3000, LaborPerMinute: 200, MarginPercent: 30, TaxEnabled: true, TaxPercent: 19},
+	)
+	if got.Totals != want.Totals {
+		t.Fatalf("totals = %+v, want %+v", got.Totals, want.Totals)
+	}
+
+	rec = serveAPI(t, handler, http.MethodPost, "/api/v1/quotes/calculate", `{"item": {"grams": 0, "quantity": 1, "cost_per_kg": 1}}`)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "item.grams") {
+		t.Fatalf("expected validation error, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = serveAPI(t, handler, http.MethodPost, "/api/v1/quotes/calculate", `{"item": {"printMinutes": 10}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown fields to be rejected, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPIMaterials(t *testing.T) {
+	_, handler := newAPITestRouter(t, roleOperator)
+
+	rec := serveAPI(t, handler, http.MethodPost, "/api/v1/materials", `{"name": " PLA ", "cost_per_kg": 85000}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	var created material
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode material: %v", err)
+	}
+	if created.ID == 0 || created.Name != "PLA" || !created.Active {
+		t.Fatalf("unexpected material: %+v", created)
+	}
+
+	rec = serveAPI(t, handler, http.MethodPost, "/api/v1/materials", `{"name": "PETG", "cost_per_kg": 0}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected validation error, got %d", rec.Code)
+	}
+
+	rec = serveAPI(t, handler, http.MethodPut, "/api/v1/materials/999", `{"name": "PLA", "cost_per_kg": 1}`)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown material, got %d", rec.Code)
+	}
+
+	rec = serveAPI(t, handler, http.MethodGet, "/api/v1/materials", "")
+	var materials []material
+	if err := json.Unmarshal(rec.Body.Bytes(), &materials); err != nil {
+		t.Fatalf("decode materials: %v", err)
+	}
+	if len(materials) != 1 || materials[0].ID != created.ID {
+		t.Fatalf("unexpected materials: %+v", materials)
+	}
+}
+
+func TestAPIRateConfigBaseCurrency(t *testing.T) {
+	_, handler := newAPITestRouter(t, roleAdmin)
+
+	rec := serveAPI(t, handler, http.MethodPut, "/api/v1/rate-config", `{"machine_hourly_rate": 3000, "currency": "pesos"}`)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "currency") {
+		t.Fatalf("expected currency validation error, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = serveAPI(t, handler, http.MethodPut, "/api/v1/rate-config", `{"machine_hourly_rate": 3000, "currency": " usd "}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	var rates rateConfig
+	if err := json.Unmarshal(rec.Body.Bytes(), &rates); err != nil {
+		t.Fatalf("decode rate config: %v", err)
+	}
+	if rates.Currency != "USD" || rates.MachineHourlyRate != 3000 {
+		t.Fatalf("unexpected rate config: %+v", rates)
+	}
+
+	// Clients written before currency existed keep the stored base.
+	rec = serveAPI(t, handler, http.MethodPut, "/api/v1/rate-config", `{"machine_hourly_rate": 3500}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"currency":"USD"`) {
+		t.Fatalf("expected the stored currency to be kept, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := serveAPI(t, handler, http.MethodPost, "/api/v1/materials", `{"name": "PLA", "cost_per_kg": 20}`); rec.Code != http.StatusCreated {
+		t.Fatalf("create material status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	rec = serveAPI(t, handler, http.MethodPut, "/api/v1/rate-config", `{"machine_hourly_rate": 3500, "currency": "EUR"}`)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "materiales") {
+		t.Fatalf("expected the base currency to be locked once materials exist, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = serveAPI(t, handler, http.MethodPut, "/api/v1/rate-config", `{"machine_hourly_rate": 3500, "currency": "usd"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected an unchanged currency to be accepted, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPIWritesRequireRole(t *testing.T) {
+	_, handler := newAPITestRouter(t, roleViewer)
+
+	rec := serveAPI(t, handler, http.MethodGet, "/api/v1/rate-config", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected viewers to read rate config, got %d", rec.Code)
+	}
+
+	rec = serveAPI(t, handler, http.MethodPost, "/api/v1/packaging-rates", `{"name": "Caja", "flat_cost": 1000}`)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), `"error"`) {
+		t.Fatalf("expected JSON 403, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestValidateCalculateRequestTaxRules(t *testing.T) {
+	req := calculateRequest{
+		Item: pricing.ItemInput{Grams: 1, Quantity: 1, CostPerKg: 1},
+		Global: pricing.GlobalInput{TaxRules: []pricing.TaxRule{
+			{Name: "IVA", Percent: 19, Base: pricing.TaxBaseNet},
+			{Name: "ReteIVA", Percent: 15, Base: pricing.TaxBaseTax},
+		}},
+	}
+
+	err := validateCalculateRequest(req)
+	if err == nil || !strings.Contains(err.Error(), "global.tax_rules[1]") {
+		t.Fatalf("expected error on the second rule, got %v", err)
+	}
+
+	req.Global.TaxRules[1].Withholding = true
+	if err := validateCalculateRequest(req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
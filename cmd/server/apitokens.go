@@ -0,0 +1,324 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	apiScopeRead      = "read"
+	apiScopeReadWrite = "read_write"
+
+	// apiTokenPrefix marks o.works tokens so they are easy to spot in logs
+	// and secret scanners.
+	apiTokenPrefix = "ow_"
+	// apiTokenDisplayLength is how much of a token the admin page shows to
+	// tell tokens apart.
+	apiTokenDisplayLength = 10
+)
+
+var apiScopes = []string{apiScopeRead, apiScopeReadWrite}
+
+type apiToken struct {
+	ID         int64
+	Name       string
+	UserEmail  string
+	Scope      string
+	Prefix     string
+	CreatedAt  string
+	ExpiresAt  string
+	LastUsedAt string
+	Expired    bool
+}
+
+type apiTokensViewData struct {
+	baseViewData
+	Tokens []apiToken
+	Users  []user
+	Scopes []string
+	// NewToken is shown once right after it is created.
+	NewToken     string
+	NewTokenName string
+}
+
+func (s *server) handleAdminAPITokensForm(w http.ResponseWriter, r *http.Request) {
+	s.renderAPITokensPage(w, r, apiTokensViewData{
+		baseViewData: baseViewData{
+			ErrorMessage:   r.URL.Query().Get("error"),
+			SuccessMessage: r.URL.Query().Get("success"),
+		},
+	})
+}
+
+func (s *server) handleAdminAPITokensCreate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Redirect(w, r, "/admin/api-tokens?error="+url.QueryEscape("name es requerido"), http.StatusSeeOther)
+		return
+	}
+	userID, err := parseRequiredID(r.FormValue("user_id"), "user_id")
+	if err != nil {
+		http.Redirect(w, r, "/admin/api-tokens?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+	scope := strings.TrimSpace(r.FormValue("scope"))
+	if scope != apiScopeRead && scope != apiScopeReadWrite {
+		http.Redirect(w, r, "/admin/api-tokens?error="+url.QueryEscape("scope debe ser read o read_write"), http.StatusSeeOther)
+		return
+	}
+	expiresInDays, err := strconv.Atoi(strings.TrimSpace(r.FormValue("expires_in_days")))
+	if err != nil || expiresInDays < 0 {
+		http.Redirect(w, r, "/admin/api-tokens?error="+url.QueryEscape("expires_in_days debe ser un entero mayor o igual a 0"), http.StatusSeeOther)
+		return
+	}
+
+	active, err := s.store().getUserActive(userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !active) {
+		http.Redirect(w, r, "/admin/api-tokens?error="+url.QueryEscape("El usuario no existe o está desactivado"), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "failed to create api token", err)
+		return
+	}
+
+	var expiresAt time.Time
+	if expiresInDays > 0 {
+		expiresAt = time.Now().AddDate(0, 0, expiresInDays)
+	}
+	current, _ := currentUser(r)
+
+	token, err := s.auth.createAPIToken(name, userID, scope, expiresAt, current.UserID)
+	if err != nil {
+		serverError(w, r, "failed to create api token", err)
+		return
+	}
+
+	s.renderAPITokensPage(w, r, apiTokensViewData{
+		baseViewData: baseViewData{SuccessMessage: "Token creado correctamente"},
+		NewToken:     token,
+		NewTokenName: name,
+	})
+}
+
+func (s *server) handleAdminAPITokensRevoke(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid api token id", http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.db.Exec(`DELETE FROM api_tokens WHERE id = ?`, id)
+	if err != nil {
+		serverError(w, r, "failed to revoke api token", err)
+		return
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		serverError(w, r, "failed to revoke api token", err)
+		return
+	}
+	if affected == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/api-tokens?success=Token+revocado+correctamente", http.StatusSeeOther)
+}
+
+func (s *server) renderAPITokensPage(w http.ResponseWriter, r *http.Request, data apiTokensViewData) {
+	tokens, err := s.listAPITokens()
+	if err != nil {
+		serverError(w, r, "failed to load api tokens", err)
+		return
+	}
+	users, err := s.store().listUsers()
+	if err != nil {
+		serverError(w, r, "failed to load users", err)
+		return
+	}
+
+	data.Tokens = tokens
+	data.Users = make([]user, 0, len(users))
+	for _, u := range users {
+		if u.Active {
+			data.Users = append(data.Users, u)
+		}
+	}
+	data.Scopes = apiScopes
+	s.renderTemplate(w, r, "admin_api_tokens.html", data)
+}
+
+// createAPIToken stores a new token acting as userID and returns it. Only its
+// hash is kept; a zero expiresAt means the token does not expire.
+func (a *authService) createAPIToken(name string, userID int64, scope string, expiresAt time.Time, createdBy int64) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("read api token: %w", err)
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+
+	var expires any
+	if !expiresAt.IsZero() {
+		expires = sqliteTime(expiresAt)
+	}
+
+	_, err := a.db.Exec(`
+		INSERT INTO api_tokens (name, user_id, token_hash, token_prefix, scope, created_by, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, name, userID, hashSessionToken(token), token[:apiTokenDisplayLength], scope, nullableID(createdBy), expires)
+	if err != nil {
+		return "", fmt.Errorf("insert api token: %w", err)
+	}
+
+	return token, nil
+}
+
+// lookupAPIToken resolves a bearer token to the user it acts as. The token's
+// scope is carried in TokenScope so requireWriteScope can refuse writes.
+func (a *authService) lookupAPIToken(token string) (sessionUser, bool, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return sessionUser{}, false, nil
+	}
+
+	var (
+		tokenID    int64
+		user       sessionUser
+		expiresAt  sql.NullString
+		lastUsedAt sql.NullString
+	)
+	err := a.db.QueryRow(`
+		SELECT t.id, t.scope, t.expires_at, t.last_used_at, u.id, u.email, u.role, u.totp_enabled
+		FROM api_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ? AND u.active = TRUE
+	`, hashSessionToken(token)).Scan(&tokenID, &user.TokenScope, &expiresAt, &lastUsedAt, &user.UserID, &user.Email, &user.Role, &user.TOTPEnabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sessionUser{}, false, nil
+	}
+	if err != nil {
+		return sessionUser{}, false, fmt.Errorf("query api token: %w", err)
+	}
+
+	now := time.Now()
+	if expiresAt.Valid {
+		expires, err := parseSQLiteTime(expiresAt.String)
+		if err != nil {
+			return sessionUser{}, false, fmt.Errorf("parse api token expires_at: %w", err)
+		}
+		if !now.Before(expires) {
+			return sessionUser{}, false, nil
+		}
+	}
+
+	touch := !lastUsedAt.Valid
+	if lastUsedAt.Valid {
+		lastUsed, err := parseSQLiteTime(lastUsedAt.String)
+		if err != nil {
+			return sessionUser{}, false, fmt.Errorf("parse api token last_used_at: %w", err)
+		}
+		touch = now.Sub(lastUsed) >= sessionTouchInterval
+	}
+	if touch {
+		if _, err := a.db.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, sqliteTime(now), tokenID); err != nil {
+			return sessionUser{}, false, fmt.Errorf("touch api token: %w", err)
+		}
+	}
+
+	return user, true, nil
+}
+
+func (s *server) listAPITokens() ([]apiToken, error) {
+	rows, err := s.db.Query(`
+		SELECT t.id, t.name, u.email, t.scope, t.token_prefix, t.created_at, t.expires_at, t.last_used_at
+		FROM api_tokens t
+		JOIN users u ON u.id = t.user_id
+		ORDER BY t.id DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query api tokens: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	tokens := make([]apiToken, 0)
+	for rows.Next() {
+		var (
+			t                     apiToken
+			createdAt             string
+			expiresAt, lastUsedAt sql.NullString
+		)
+		if err := rows.Scan(&t.ID, &t.Name, &t.UserEmail, &t.Scope, &t.Prefix, &createdAt, &expiresAt, &lastUsedAt); err != nil {
+			return nil, fmt.Errorf("scan api token: %w", err)
+		}
+
+		if t.CreatedAt, err = formatSQLiteTime(createdAt); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			expires, err := parseSQLiteTime(expiresAt.String)
+			if err != nil {
+				return nil, fmt.Errorf("parse api token expires_at: %w", err)
+			}
+			t.ExpiresAt = expires.Format(time.DateTime)
+			t.Expired = !now.Before(expires)
+		}
+		if lastUsedAt.Valid {
+			if t.LastUsedAt, err = formatSQLiteTime(lastUsedAt.String); err != nil {
+				return nil, err
+			}
+		}
+
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate api tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// requireWriteScope rejects requests made with a read-only API token. Browser
+// sessions are not affected.
+func requireWriteScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if current, _ := currentUser(r); current.TokenScope == apiScopeRead {
+			writeAPIError(w, http.StatusForbidden, "token is read-only")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func formatSQLiteTime(value string) (string, error) {
+	t, err := parseSQLiteTime(value)
+	if err != nil {
+		return "", fmt.Errorf("parse time %q: %w", value, err)
+	}
+	return t.Format(time.DateTime), nil
+}
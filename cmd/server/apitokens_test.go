@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestLookupAPIToken(t *testing.T) {
+	database := newMigratedTestDB(t)
+	auth, userID := newSessionTestAuth(t, database)
+
+	token, err := auth.createAPIToken("shop", userID, apiScopeRead, time.Time{}, userID)
+	if err != nil {
+		t.Fatalf("createAPIToken returned error: %v", err)
+	}
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		t.Fatalf("unexpected token format %q", token)
+	}
+
+	user, ok, err := auth.lookupAPIToken(token)
+	if err != nil || !ok {
+		t.Fatalf("expected token to be valid, got %v, %v", ok, err)
+	}
+	if user.UserID != userID || user.Role != roleAdmin || user.TokenScope != apiScopeRead {
+		t.Fatalf("unexpected user: %+v", user)
+	}
+
+	var lastUsed *string
+	if err := database.QueryRow(`SELECT last_used_at FROM api_tokens`).Scan(&lastUsed); err != nil {
+		t.Fatalf("query last_used_at: %v", err)
+	}
+	if lastUsed == nil {
+		t.Fatal("expected last_used_at to be recorded")
+	}
+
+	if _, ok, err := auth.lookupAPIToken(token + "x"); err != nil || ok {
+		t.Fatalf("expected wrong token to be rejected, got %v, %v", ok, err)
+	}
+
+	expired, err := auth.createAPIToken("old", userID, apiScopeReadWrite, time.Now().Add(-time.Minute), userID)
+	if err != nil {
+		t.Fatalf("createAPIToken returned error: %v", err)
+	}
+	if _, ok, err := auth.lookupAPIToken(expired); err != nil || ok {
+		t.Fatalf("expected expired token to be rejected, got %v, %v", ok, err)
+	}
+
+	if _, err := database.Exec(`UPDATE users SET active = FALSE WHERE id = ?`, userID); err != nil {
+		t.Fatalf("disable user: %v", err)
+	}
+	if _, ok, err := auth.lookupAPIToken(token); err != nil || ok {
+		t.Fatalf("expected token of a disabled user to be rejected, got %v, %v", ok, err)
+	}
+}
+
+func TestAPITokenMiddleware(t *testing.T) {
+	database := newMigratedTestDB(t)
+	auth, userID := newSessionTestAuth(t, database)
+	srv := &server{auth: auth, db: database}
+
+	r := chi.NewRouter()
+	r.Use(srv.csrfMiddleware)
+	r.Use(srv.authMiddleware)
+	r.Route("/api/v1", srv.apiRoutes)
+	r.Get("/quotes", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	readToken, err := auth.createAPIToken("reports", userID, apiScopeRead, time.Time{}, userID)
+	if err != nil {
+		t.Fatalf("createAPIToken returned error: %v", err)
+	}
+	writeToken, err := auth.createAPIToken("shop", userID, apiScopeReadWrite, time.Time{}, userID)
+	if err != nil {
+		t.Fatalf("createAPIToken returned error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		want   int
+	}{
+		{name: "read token can read", method: http.MethodGet, path: "/api/v1/materials", token: readToken, want: http.StatusOK},
+		{name: "read token cannot write", method: http.MethodPost, path: "/api/v1/materials", token: readToken, body: `{"name": "PLA", "cost_per_kg": 1}`, want: http.StatusForbidden},
+		{name: "read token can calculate", method: http.MethodPost, path: "/api/v1/quotes/calculate", token: readToken, body: `{"item": {"grams": 1, "quantity": 1, "cost_per_kg": 1}}`, want: http.StatusOK},
+		{name: "write token skips csrf", method: http.MethodPost, path: "/api/v1/materials", token: writeToken, body: `{"name": "PLA", "cost_per_kg": 1}`, want: http.StatusCreated},
+		{name: "unknown token", method: http.MethodGet, path: "/api/v1/materials", token: "ow_nope", want: http.StatusUnauthorized},
+		{name: "no credentials", method: http.MethodGet, path: "/api/v1/materials", want: http.StatusUnauthorized},
+		{name: "tokens only work on the api", method: http.MethodGet, path: "/quotes", token: writeToken, want: http.StatusSeeOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d, body %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAPITokenRequiresAdminTOTP(t *testing.T) {
+	database := newMigratedTestDB(t)
+	auth, userID := newSessionTestAuth(t, database)
+	srv := &server{auth: auth, db: database, requireAdminTOTP: true}
+
+	r := chi.NewRouter()
+	r.Use(srv.authMiddleware)
+	r.Route("/api/v1", srv.apiRoutes)
+
+	token, err := auth.createAPIToken("reports", userID, apiScopeRead, time.Time{}, userID)
+	if err != nil {
+		t.Fatalf("createAPIToken returned error: %v", err)
+	}
+
+	serve := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/materials", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := serve(); code != http.StatusForbidden {
+		t.Fatalf("expected an admin token without TOTP to be refused, got %d", code)
+	}
+
+	srv.requireAdminTOTP = false
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("expected the token to work when 2FA is not required, got %d", code)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+		ok     bool
+	}{
+		{header: "Bearer ow_abc", want: "ow_abc", ok: true},
+		{header: "bearer  ow_abc ", want: "ow_abc", ok: true},
+		{header: "Basic dXNlcjpwYXNz"},
+		{header: "Bearer "},
+		{header: ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/materials", nil)
+		req.Header.Set("Authorization", tt.header)
+		got, ok := bearerToken(req)
+		if got != tt.want || ok != tt.ok {
+			t.Fatalf("bearerToken(%q) = %q, %v", tt.header, got, ok)
+		}
+	}
+}
@@ -2,8 +2,8 @@ package main
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
-	"crypto/subtle"
 	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
@@ -11,40 +11,102 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 )
 
-const sessionCookieName = "oworks_session"
+const (
+	sessionCookieName      = "oworks_session"
+	pendingLoginCookieName = "oworks_2fa"
+
+	// pendingLoginTTL is how long a user has to enter the second factor after
+	// a correct password.
+	pendingLoginTTL = 5 * time.Minute
+)
+
+// sessionTouchInterval limits how often last_seen_at is written for an active
+// session.
+const sessionTouchInterval = time.Minute
 
 type authService struct {
 	db            *sql.DB
 	sessionSecret []byte
+	sessions      sessionPolicy
+}
+
+// sessionPolicy controls how long server-side sessions live and how the
+// session cookie is issued.
+type sessionPolicy struct {
+	IdleTimeout   time.Duration
+	MaxAge        time.Duration
+	SecureCookies bool
+}
+
+var defaultSessionPolicy = sessionPolicy{
+	IdleTimeout: 2 * time.Hour,
+	MaxAge:      7 * 24 * time.Hour,
+}
+
+// sessionUser is the authenticated user behind a request.
+type sessionUser struct {
+	SessionID int64
+	UserID    int64
+	Email     string
+	Role      string
+	// TOTPEnabled reports whether the user has enrolled a second factor.
+	TOTPEnabled bool
+	// TokenScope is set when the request authenticated with an API token
+	// instead of a browser session.
+	TokenScope string
 }
 
-func newAuthService(db *sql.DB, sessionSecret string) *authService {
-	return &authService{db: db, sessionSecret: []byte(sessionSecret)}
+func newAuthService(db *sql.DB, sessionSecret string, sessions sessionPolicy) *authService {
+	return &authService{db: db, sessionSecret: []byte(sessionSecret), sessions: sessions}
 }
 
-func (a *authService) validateCredentials(email, password string) (bool, error) {
-	var passwordHash string
-	err := a.db.QueryRow(`SELECT password_hash FROM users WHERE email = ?`, email).Scan(&passwordHash)
+// users returns the store user records are read and written through.
+func (a *authService) users() userStore {
+	return newSQLStore(a.db)
+}
+
+// validateCredentials checks the password and, when it matches a legacy or
+// outdated hash, stores a fresh argon2id hash in its place.
+// It returns the user id when the credentials are valid.
+func (a *authService) validateCredentials(email, password string) (int64, bool, error) {
+	userID, passwordHash, err := a.users().getActiveUserCredentials(email)
 	if errors.Is(err, sql.ErrNoRows) {
-		return false, nil
+		// Spend the same time as a real check so response times don't reveal
+		// which emails exist.
+		_, _, _ = verifyPassword(dummyPasswordHash(), password)
+		return 0, false, nil
 	}
 	if err != nil {
-		return false, fmt.Errorf("query user credentials: %w", err)
+		return 0, false, fmt.Errorf("query user credentials: %w", err)
 	}
 
-	providedHash := hashPassword(password)
-	if subtle.ConstantTimeCompare([]byte(passwordHash), []byte(providedHash)) == 1 {
-		return true, nil
+	ok, needsRehash, err := verifyPassword(passwordHash, password)
+	if err != nil {
+		return 0, false, fmt.Errorf("verify password for user %d: %w", userID, err)
+	}
+	if !ok {
+		return 0, false, nil
+	}
+
+	if needsRehash {
+		if err := a.setPassword(userID, password); err != nil {
+			return 0, false, err
+		}
 	}
 
-	// Compatibilidad: aceptar contraseñas almacenadas en texto plano.
-	if subtle.ConstantTimeCompare([]byte(passwordHash), []byte(password)) == 1 {
-		return true, nil
+	return userID, true, nil
+}
+
+func (a *authService) setPassword(userID int64, password string) error {
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return err
 	}
 
-	return false, nil
+	return a.users().updateUserPasswordHash(userID, passwordHash)
 }
 
 func (a *authService) ensureAdminUser(email, password string) error {
@@ -52,73 +114,231 @@ func (a *authService) ensureAdminUser(email, password string) error {
 		return nil
 	}
 
-	var exists bool
-	err := a.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE email = ?)`, email).Scan(&exists)
+	exists, err := a.users().userExists(email)
 	if err != nil {
-		return fmt.Errorf("check admin user existence: %w", err)
+		return err
 	}
 	if exists {
 		return nil
 	}
 
-	if _, err := a.db.Exec(`INSERT INTO users (email, password_hash) VALUES (?, ?)`, email, hashPassword(password)); err != nil {
-		return fmt.Errorf("insert admin user: %w", err)
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.users().createUser(email, passwordHash, roleAdmin)
+	return err
+}
+
+// createSession stores a new session for the user and returns the cookie
+// value. Only a hash of the random token is kept in the database.
+func (a *authService) createSession(userID int64, r *http.Request) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("read session token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	now := time.Now()
+	if _, err := a.db.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, sqliteTime(now)); err != nil {
+		return "", fmt.Errorf("delete expired sessions: %w", err)
+	}
+
+	_, err := a.db.Exec(`
+		INSERT INTO sessions (user_id, token_hash, ip, user_agent, created_at, last_seen_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, userID, hashSessionToken(token), clientIP(r), r.UserAgent(), sqliteTime(now), sqliteTime(now), sqliteTime(now.Add(a.sessions.MaxAge)))
+	if err != nil {
+		return "", fmt.Errorf("insert session: %w", err)
+	}
+
+	return token + "." + a.signSessionToken(token), nil
+}
+
+// lookupSession resolves a cookie value to its user. Sessions past their idle
+// or absolute timeout are deleted and reported as missing.
+func (a *authService) lookupSession(value string) (sessionUser, bool, error) {
+	token, ok := a.verifySessionValue(value)
+	if !ok {
+		return sessionUser{}, false, nil
+	}
+
+	var (
+		user       sessionUser
+		lastSeenAt string
+		expiresAt  string
+	)
+	err := a.db.QueryRow(`
+		SELECT s.id, s.user_id, u.email, u.role, u.totp_enabled, s.last_seen_at, s.expires_at
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND u.active = TRUE
+	`, hashSessionToken(token)).Scan(&user.SessionID, &user.UserID, &user.Email, &user.Role, &user.TOTPEnabled, &lastSeenAt, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sessionUser{}, false, nil
+	}
+	if err != nil {
+		return sessionUser{}, false, fmt.Errorf("query session: %w", err)
+	}
+
+	now := time.Now()
+	lastSeen, err := parseSQLiteTime(lastSeenAt)
+	if err != nil {
+		return sessionUser{}, false, fmt.Errorf("parse session last_seen_at: %w", err)
+	}
+	expires, err := parseSQLiteTime(expiresAt)
+	if err != nil {
+		return sessionUser{}, false, fmt.Errorf("parse session expires_at: %w", err)
+	}
+
+	if !now.Before(expires) || now.Sub(lastSeen) >= a.sessions.IdleTimeout {
+		if _, err := a.db.Exec(`DELETE FROM sessions WHERE id = ?`, user.SessionID); err != nil {
+			return sessionUser{}, false, fmt.Errorf("delete expired session: %w", err)
+		}
+		return sessionUser{}, false, nil
+	}
+
+	if now.Sub(lastSeen) >= sessionTouchInterval {
+		if _, err := a.db.Exec(`UPDATE sessions SET last_seen_at = ? WHERE id = ?`, sqliteTime(now), user.SessionID); err != nil {
+			return sessionUser{}, false, fmt.Errorf("touch session: %w", err)
+		}
 	}
 
+	return user, true, nil
+}
+
+func (a *authService) deleteSession(sessionID int64) error {
+	if _, err := a.db.Exec(`DELETE FROM sessions WHERE id = ?`, sessionID); err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
 	return nil
 }
 
-func hashPassword(password string) string {
-	sum := sha256.Sum256([]byte(password))
-	return hex.EncodeToString(sum[:])
+func (a *authService) deleteUserSessions(userID int64) error {
+	if _, err := a.db.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("delete user sessions: %w", err)
+	}
+	return nil
 }
 
-func (a *authService) createSessionValue(email string) string {
-	payload := base64.RawURLEncoding.EncodeToString([]byte(email))
+func (a *authService) signSessionToken(token string) string {
 	mac := hmac.New(sha256.New, a.sessionSecret)
-	_, _ = mac.Write([]byte(payload))
-	signature := hex.EncodeToString(mac.Sum(nil))
-	return payload + "." + signature
+	_, _ = mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
+// verifySessionValue checks the cookie signature so forged values are rejected
+// without a database lookup, and returns the raw session token.
 func (a *authService) verifySessionValue(value string) (string, bool) {
-	parts := strings.Split(value, ".")
-	if len(parts) != 2 {
+	token, signature, ok := strings.Cut(value, ".")
+	if !ok || token == "" {
 		return "", false
 	}
 
-	payload := parts[0]
-	signature := parts[1]
-
-	mac := hmac.New(sha256.New, a.sessionSecret)
-	_, _ = mac.Write([]byte(payload))
-	expected := mac.Sum(nil)
-
 	provided, err := hex.DecodeString(signature)
 	if err != nil {
 		return "", false
 	}
+	expected, err := hex.DecodeString(a.signSessionToken(token))
+	if err != nil {
+		return "", false
+	}
 	if !hmac.Equal(provided, expected) {
 		return "", false
 	}
 
-	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	return token, true
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sqliteTime formats t like CURRENT_TIMESTAMP so stored values compare as text.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(time.DateTime)
+}
+
+func parseSQLiteTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.DateTime, time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// setPendingLogin remembers, for a few minutes, that userID passed the
+// password check and still has to enter the second factor. The cookie is
+// signed and carries its own expiry; no session exists until the code is
+// verified.
+func (a *authService) setPendingLogin(w http.ResponseWriter, userID int64) {
+	payload := fmt.Sprintf("%d.%d", userID, time.Now().Add(pendingLoginTTL).Unix())
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingLoginCookieName,
+		Value:    payload + "." + a.signPendingLogin(payload),
+		Path:     "/login",
+		MaxAge:   int(pendingLoginTTL / time.Second),
+		HttpOnly: true,
+		Secure:   a.sessions.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (a *authService) pendingLogin(r *http.Request) (int64, bool) {
+	cookie, err := r.Cookie(pendingLoginCookieName)
 	if err != nil {
-		return "", false
+		return 0, false
 	}
-	if len(decoded) == 0 {
-		return "", false
+
+	idx := strings.LastIndex(cookie.Value, ".")
+	if idx < 0 {
+		return 0, false
+	}
+	payload, signature := cookie.Value[:idx], cookie.Value[idx+1:]
+	if !hmac.Equal([]byte(signature), []byte(a.signPendingLogin(payload))) {
+		return 0, false
+	}
+
+	var userID, expiresAt int64
+	if _, err := fmt.Sscanf(payload, "%d.%d", &userID, &expiresAt); err != nil || userID <= 0 {
+		return 0, false
+	}
+	if time.Now().Unix() >= expiresAt {
+		return 0, false
 	}
 
-	return string(decoded), true
+	return userID, true
+}
+
+func (a *authService) clearPendingLogin(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingLoginCookieName,
+		Value:    "",
+		Path:     "/login",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   a.sessions.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (a *authService) signPendingLogin(payload string) string {
+	mac := hmac.New(sha256.New, a.sessionSecret)
+	_, _ = fmt.Fprintf(mac, "2fa:%s", payload)
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
-func (a *authService) setSessionCookie(w http.ResponseWriter, email string) {
+func (a *authService) setSessionCookie(w http.ResponseWriter, value string) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     sessionCookieName,
-		Value:    a.createSessionValue(email),
+		Value:    value,
 		Path:     "/",
+		MaxAge:   int(a.sessions.MaxAge / time.Second),
 		HttpOnly: true,
+		Secure:   a.sessions.SecureCookies,
 		SameSite: http.SameSiteLaxMode,
 	})
 }
@@ -130,6 +350,7 @@ func (a *authService) clearSessionCookie(w http.ResponseWriter) {
 		Path:     "/",
 		MaxAge:   -1,
 		HttpOnly: true,
+		Secure:   a.sessions.SecureCookies,
 		SameSite: http.SameSiteLaxMode,
 	})
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionLifecycle(t *testing.T) {
+	database := newMigratedTestDB(t)
+	auth, userID := newSessionTestAuth(t, database)
+
+	value, err := auth.createSession(userID, httptest.NewRequest("POST", "/login", nil))
+	if err != nil {
+		t.Fatalf("createSession returned error: %v", err)
+	}
+
+	user, ok, err := auth.lookupSession(value)
+	if err != nil || !ok {
+		t.Fatalf("expected session to be valid, got %v, %v", ok, err)
+	}
+	if user.UserID != userID || user.Email != "admin@x.com" {
+		t.Fatalf("unexpected session user: %+v", user)
+	}
+
+	var tokenHash string
+	if err := database.QueryRow(`SELECT token_hash FROM sessions WHERE id = ?`, user.SessionID).Scan(&tokenHash); err != nil {
+		t.Fatalf("failed to read session: %v", err)
+	}
+	if tokenHash == value || len(tokenHash) != 64 {
+		t.Fatalf("expected only a token hash to be stored, got %q", tokenHash)
+	}
+
+	if _, ok, _ := auth.lookupSession(value + "0"); ok {
+		t.Fatal("expected tampered cookie to be rejected")
+	}
+
+	if err := auth.deleteSession(user.SessionID); err != nil {
+		t.Fatalf("deleteSession returned error: %v", err)
+	}
+	if _, ok, _ := auth.lookupSession(value); ok {
+		t.Fatal("expected deleted session to be rejected")
+	}
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	tests := []struct {
+		name   string
+		update string
+	}{
+		{name: "idle", update: `UPDATE sessions SET last_seen_at = ?`},
+		{name: "absolute", update: `UPDATE sessions SET expires_at = ?`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := newMigratedTestDB(t)
+			auth, userID := newSessionTestAuth(t, database)
+
+			value, err := auth.createSession(userID, httptest.NewRequest("POST", "/login", nil))
+			if err != nil {
+				t.Fatalf("createSession returned error: %v", err)
+			}
+			if _, err := database.Exec(tt.update, sqliteTime(time.Now().Add(-3*time.Hour))); err != nil {
+				t.Fatalf("failed to age session: %v", err)
+			}
+
+			if _, ok, err := auth.lookupSession(value); err != nil || ok {
+				t.Fatalf("expected expired session to be rejected, got %v, %v", ok, err)
+			}
+
+			var count int
+			if err := database.QueryRow(`SELECT COUNT(*) FROM sessions`).Scan(&count); err != nil {
+				t.Fatalf("failed to count sessions: %v", err)
+			}
+			if count != 0 {
+				t.Fatalf("expected expired session to be deleted, got %d rows", count)
+			}
+		})
+	}
+}
+
+func TestDeleteUserSessionsLogsOutEverywhere(t *testing.T) {
+	database := newMigratedTestDB(t)
+	auth, userID := newSessionTestAuth(t, database)
+
+	first, err := auth.createSession(userID, httptest.NewRequest("POST", "/login", nil))
+	if err != nil {
+		t.Fatalf("createSession returned error: %v", err)
+	}
+	second, err := auth.createSession(userID, httptest.NewRequest("POST", "/login", nil))
+	if err != nil {
+		t.Fatalf("createSession returned error: %v", err)
+	}
+
+	if err := auth.deleteUserSessions(userID); err != nil {
+		t.Fatalf("deleteUserSessions returned error: %v", err)
+	}
+
+	for _, value := range []string{first, second} {
+		if _, ok, _ := auth.lookupSession(value); ok {
+			t.Fatal("expected every session to be revoked")
+		}
+	}
+}
+
+func newSessionTestAuth(t *testing.T, database *sql.DB) (*authService, int64) {
+	t.Helper()
+
+	auth := newAuthService(database, "secret", sessionPolicy{IdleTimeout: time.Hour, MaxAge: 24 * time.Hour})
+	if err := auth.ensureAdminUser("admin@x.com", "secret"); err != nil {
+		t.Fatalf("ensureAdminUser returned error: %v", err)
+	}
+
+	var userID int64
+	if err := database.QueryRow(`SELECT id FROM users WHERE email = 'admin@x.com'`).Scan(&userID); err != nil {
+		t.Fatalf("failed to read user id: %v", err)
+	}
+
+	return auth, userID
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Simplici0/o.works/internal/backup"
+)
+
+type backupsViewData struct {
+	baseViewData
+	Backups  []backup.File
+	Dir      string
+	Interval time.Duration
+	Keep     int
+}
+
+func (s *server) handleAdminBackupsForm(w http.ResponseWriter, r *http.Request) {
+	var files []backup.File
+	if s.backupDir != "" {
+		var err error
+		files, err = backup.List(s.backupDir)
+		if err != nil {
+			serverError(w, r, "failed to load backups", err)
+			return
+		}
+	}
+
+	s.renderTemplate(w, r, "admin_backups.html", backupsViewData{
+		baseViewData: baseViewData{
+			ErrorMessage:   r.URL.Query().Get("error"),
+			SuccessMessage: r.URL.Query().Get("success"),
+		},
+		Backups:  files,
+		Dir:      s.backupDir,
+		Interval: s.backupInterval,
+		Keep:     s.backupKeep,
+	})
+}
+
+func (s *server) handleAdminBackupsCreate(w http.ResponseWriter, r *http.Request) {
+	if s.backupDir == "" {
+		http.Redirect(w, r, "/admin/backups?error="+url.QueryEscape("Los respaldos integrados solo funcionan con SQLite."), http.StatusSeeOther)
+		return
+	}
+
+	path, err := s.createBackup(r.Context(), time.Now())
+	if err != nil {
+		requestLogger(r).Error("create backup", "error", err)
+		http.Redirect(w, r, "/admin/backups?error="+url.QueryEscape("No se pudo crear el respaldo."), http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/backups?success="+url.QueryEscape("Respaldo creado: "+filepath.Base(path)), http.StatusSeeOther)
+}
+
+func (s *server) handleAdminBackupDownload(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	path, err := backup.Path(s.backupDir, name)
+	if err != nil || s.backupDir == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "failed to open backup", err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		serverError(w, r, "failed to open backup", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// createBackup writes a new backup and then drops the ones past the
+// retention limit.
+func (s *server) createBackup(ctx context.Context, now time.Time) (string, error) {
+	path, err := backup.Create(ctx, s.db, s.backupDir, now)
+	if err != nil {
+		return "", err
+	}
+	if err := backup.Prune(s.backupDir, s.backupKeep); err != nil {
+		return path, fmt.Errorf("prune backups: %w", err)
+	}
+	return path, nil
+}
+
+// runBackupScheduler takes a backup every backupInterval. The first one is
+// timed from the newest backup on disk, so restarting the server does not
+// trigger an extra backup. An empty backupDir disables backups.
+func (s *server) runBackupScheduler(ctx context.Context) {
+	if s.backupInterval <= 0 || s.backupDir == "" {
+		return
+	}
+
+	wait := time.Duration(0)
+	if files, err := backup.List(s.backupDir); err != nil {
+		slog.Error("list backups", "dir", s.backupDir, "error", err)
+	} else if len(files) > 0 {
+		wait = max(s.backupInterval-time.Since(files[0].CreatedAt), 0)
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if path, err := s.createBackup(ctx, time.Now()); err != nil {
+			slog.Error("scheduled backup", "error", err)
+		} else {
+			slog.Info("scheduled backup written", "path", path)
+		}
+		timer.Reset(s.backupInterval)
+	}
+}
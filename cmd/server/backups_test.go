@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Simplici0/o.works/internal/backup"
+)
+
+func TestAdminBackupsCreateAndDownload(t *testing.T) {
+	srv := &server{
+		db:         newMigratedTestDB(t),
+		backupDir:  filepath.Join(t.TempDir(), "backups"),
+		backupKeep: 2,
+	}
+	for i := range 3 {
+		if _, err := srv.createBackup(t.Context(), time.Now().Add(time.Duration(i-3)*time.Hour)); err != nil {
+			t.Fatalf("createBackup returned error: %v", err)
+		}
+	}
+
+	r := chi.NewRouter()
+	r.Post("/admin/backups", srv.handleAdminBackupsCreate)
+	r.Get("/admin/backups/{name}", srv.handleAdminBackupDownload)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/backups", nil))
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	if rec.Code != http.StatusSeeOther || !strings.Contains(location.Query().Get("success"), "Respaldo creado") {
+		t.Fatalf("unexpected create response %d %s", rec.Code, location)
+	}
+
+	files, err := backup.List(srv.backupDir)
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected retention to keep 2 backups, got %+v", files)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/backups/"+files[0].Name, nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "SQLite format 3") {
+		t.Fatalf("unexpected download response %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Disposition"); !strings.Contains(got, files[0].Name) {
+		t.Fatalf("unexpected Content-Disposition %q", got)
+	}
+
+	for _, name := range []string{"oworks-20000101-000000.db", "..%2Fquotes.db", "test.db"} {
+		rec = httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/backups/"+name, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 for %s, got %d", name, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,624 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxCSVImportSize = 1 << 20
+	maxCSVImportRows = 5000
+
+	csvActionCreate    = "crear"
+	csvActionUpdate    = "actualizar"
+	csvActionUnchanged = "sin cambios"
+)
+
+// catalogCSV describes how one catalog table is exported and imported. Rows
+// are matched to existing entries by a natural key instead of by id, so a
+// file exported from one installation can be imported into another.
+type catalogCSV struct {
+	Title    string
+	Path     string
+	Filename string
+	Columns  []string
+	records  func(s *server) ([][]string, error)
+	plan     func(s *server, rows []csvRow) ([]csvImportRow, error)
+}
+
+var (
+	materialCSVColumns  = []string{"name", "cost_per_kg", "notes", "active"}
+	shippingCSVColumns  = []string{"scope", "country", "city", "flat_cost", "notes", "active"}
+	packagingCSVColumns = []string{"name", "flat_cost", "notes", "active"}
+
+	materialsCSV = catalogCSV{
+		Title:    "materiales",
+		Path:     "/admin/materials",
+		Filename: "materials.csv",
+		Columns:  materialCSVColumns,
+		records:  (*server).materialCSVRecords,
+		plan:     (*server).planMaterialsImport,
+	}
+	shippingCSV = catalogCSV{
+		Title:    "tarifas de envío",
+		Path:     "/admin/shipping",
+		Filename: "shipping_rates.csv",
+		Columns:  shippingCSVColumns,
+		records:  (*server).shippingCSVRecords,
+		plan:     (*server).planShippingImport,
+	}
+	packagingCSV = catalogCSV{
+		Title:    "tarifas de empaque",
+		Path:     "/admin/packaging",
+		Filename: "packaging_rates.csv",
+		Columns:  packagingCSVColumns,
+		records:  (*server).packagingCSVRecords,
+		plan:     (*server).planPackagingImport,
+	}
+)
+
+// csvRow is one data line of an uploaded file, keyed by header.
+type csvRow struct {
+	Line   int
+	values map[string]string
+}
+
+func (row csvRow) value(column string) string {
+	return row.values[column]
+}
+
+// csvImportRow is the planned outcome for one line of an import.
+type csvImportRow struct {
+	Line   int
+	Values []string
+	Action string
+	Error  string
+	// apply writes the row through st, the import's transaction. The
+	// returned function, if any, announces the change once the import
+	// has committed.
+	apply func(st store) (func(), error)
+}
+
+type csvImportViewData struct {
+	baseViewData
+	Title     string
+	Path      string
+	Columns   []string
+	Rows      []csvImportRow
+	CSV       string
+	DryRun    bool
+	Created   int
+	Updated   int
+	Unchanged int
+	Failed    int
+}
+
+func (s *server) handleAdminMaterialsExport(w http.ResponseWriter, r *http.Request) {
+	s.exportCatalogCSV(w, r, materialsCSV)
+}
+
+func (s *server) handleAdminMaterialsImport(w http.ResponseWriter, r *http.Request) {
+	s.importCatalogCSV(w, r, materialsCSV)
+}
+
+func (s *server) handleAdminShippingExport(w http.ResponseWriter, r *http.Request) {
+	s.exportCatalogCSV(w, r, shippingCSV)
+}
+
+func (s *server) handleAdminShippingImport(w http.ResponseWriter, r *http.Request) {
+	s.importCatalogCSV(w, r, shippingCSV)
+}
+
+func (s *server) handleAdminPackagingExport(w http.ResponseWriter, r *http.Request) {
+	s.exportCatalogCSV(w, r, packagingCSV)
+}
+
+func (s *server) handleAdminPackagingImport(w http.ResponseWriter, r *http.Request) {
+	s.importCatalogCSV(w, r, packagingCSV)
+}
+
+func (s *server) exportCatalogCSV(w http.ResponseWriter, r *http.Request, catalog catalogCSV) {
+	records, err := catalog.records(s)
+	if err != nil {
+		serverError(w, r, "failed to export "+catalog.Filename, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+catalog.Filename+`"`)
+
+	writer := csv.NewWriter(w)
+	err = writer.Write(catalog.Columns)
+	if err == nil {
+		err = writer.WriteAll(records)
+	}
+	if err != nil {
+		// The response has started, so the error can only be logged.
+		requestLogger(r).Error("failed to write "+catalog.Filename, "method", r.Method, "path", r.URL.Path, "error", err)
+	}
+}
+
+// importCatalogCSV validates every row before touching the database. With
+// dry_run=1, or when any row is invalid, it only renders the preview; the
+// preview carries the file contents so it can be confirmed without uploading
+// it again. The rows are written in one transaction, so a failure leaves the
+// catalog as it was.
+func (s *server) importCatalogCSV(w http.ResponseWriter, r *http.Request, catalog catalogCSV) {
+	data, err := readCSVUpload(r)
+	if err != nil {
+		http.Redirect(w, r, catalog.Path+"?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	rows, err := parseCatalogCSV(data, catalog.Columns)
+	if err != nil {
+		http.Redirect(w, r, catalog.Path+"?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	planned, err := catalog.plan(s, rows)
+	if err != nil {
+		serverError(w, r, "failed to import "+catalog.Filename, err)
+		return
+	}
+
+	view := csvImportViewData{
+		Title:   catalog.Title,
+		Path:    catalog.Path,
+		Columns: catalog.Columns,
+		Rows:    planned,
+		CSV:     string(data),
+		DryRun:  r.FormValue("dry_run") == "1",
+	}
+	for _, row := range planned {
+		switch {
+		case row.Error != "":
+			view.Failed++
+		case row.Action == csvActionCreate:
+			view.Created++
+		case row.Action == csvActionUpdate:
+			view.Updated++
+		default:
+			view.Unchanged++
+		}
+	}
+
+	if view.DryRun || view.Failed > 0 {
+		if !view.DryRun {
+			view.ErrorMessage = "No se importó nada: corrige las filas con error y vuelve a intentarlo."
+		}
+		s.renderTemplate(w, r, "admin_csv_import.html", view)
+		return
+	}
+
+	var announcements []func()
+	err = s.store().withTx(func(st store) error {
+		for _, row := range planned {
+			if row.apply == nil {
+				continue
+			}
+			announce, err := row.apply(st)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", row.Line, err)
+			}
+			if announce != nil {
+				announcements = append(announcements, announce)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		serverError(w, r, "failed to import "+catalog.Filename, err)
+		return
+	}
+	for _, announce := range announcements {
+		announce()
+	}
+
+	message := fmt.Sprintf("Importación completada: %d creados, %d actualizados, %d sin cambios", view.Created, view.Updated, view.Unchanged)
+	http.Redirect(w, r, catalog.Path+"?success="+url.QueryEscape(message), http.StatusSeeOther)
+}
+
+// readCSVUpload returns the uploaded file, or the csv field sent back by the
+// preview page.
+func readCSVUpload(r *http.Request) ([]byte, error) {
+	if err := r.ParseMultipartForm(maxCSVImportSize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return nil, fmt.Errorf("No se pudo leer el archivo")
+	}
+
+	file, header, err := r.FormFile("file")
+	if errors.Is(err, http.ErrMissingFile) || errors.Is(err, http.ErrNotMultipart) {
+		data := r.FormValue("csv")
+		if strings.TrimSpace(data) == "" {
+			return nil, fmt.Errorf("Selecciona un archivo CSV")
+		}
+		if len(data) > maxCSVImportSize {
+			return nil, fmt.Errorf("El archivo supera %d KB", maxCSVImportSize/1024)
+		}
+		return []byte(data), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("No se pudo leer el archivo")
+	}
+	defer file.Close()
+
+	if header.Size > maxCSVImportSize {
+		return nil, fmt.Errorf("El archivo supera %d KB", maxCSVImportSize/1024)
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxCSVImportSize))
+	if err != nil {
+		return nil, fmt.Errorf("No se pudo leer el archivo")
+	}
+	return data, nil
+}
+
+// parseCatalogCSV reads a file whose header must name exactly columns, in any
+// order. Semicolon-separated files, as saved by spreadsheets in Spanish
+// locales, are accepted too. Empty lines are skipped.
+func parseCatalogCSV(data []byte, columns []string) ([]csvRow, error) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+	if bytes.Contains(firstLine, []byte(";")) && !bytes.Contains(firstLine, []byte(",")) {
+		reader.Comma = ';'
+	}
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("El archivo está vacío")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("CSV inválido: %v", err)
+	}
+	for i, name := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(columns, header[i]) {
+			return nil, fmt.Errorf("columna desconocida: %q", name)
+		}
+		if slices.Contains(header[:i], header[i]) {
+			return nil, fmt.Errorf("columna repetida: %s", header[i])
+		}
+	}
+	for _, column := range columns {
+		if !slices.Contains(header, column) {
+			return nil, fmt.Errorf("falta la columna %s", column)
+		}
+	}
+
+	rows := make([]csvRow, 0)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("CSV inválido: %v", err)
+		}
+		if len(rows) == maxCSVImportRows {
+			return nil, fmt.Errorf("El archivo supera %d filas", maxCSVImportRows)
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := csvRow{Line: line, values: make(map[string]string, len(header))}
+		empty := true
+		for i, value := range record {
+			row.values[header[i]] = csvTextValue(strings.TrimSpace(value))
+			empty = empty && row.values[header[i]] == ""
+		}
+		if !empty {
+			rows = append(rows, row)
+		}
+	}
+
+	return rows, nil
+}
+
+// newCSVImportRow checks the columns the form parsers treat loosely. The
+// active column is normalized to the "1"/"0" values the forms send.
+func newCSVImportRow(row csvRow, columns []string) csvImportRow {
+	planned := csvImportRow{Line: row.Line, Values: make([]string, len(columns))}
+	for i, column := range columns {
+		planned.Values[i] = row.value(column)
+	}
+
+	switch strings.ToLower(row.value("active")) {
+	case "1", "true", "si", "sí":
+		row.values["active"] = "1"
+	case "0", "false", "no":
+		row.values["active"] = "0"
+	default:
+		planned.Error = "active debe ser 1 o 0"
+	}
+	return planned
+}
+
+// csvKeyIndex resolves natural keys to existing catalog entries and notices
+// keys repeated within the file.
+type csvKeyIndex struct {
+	existing map[string][]int
+	seen     map[string]int
+}
+
+func newCSVKeyIndex() *csvKeyIndex {
+	return &csvKeyIndex{existing: make(map[string][]int), seen: make(map[string]int)}
+}
+
+func (idx *csvKeyIndex) add(key string, position int) {
+	idx.existing[key] = append(idx.existing[key], position)
+}
+
+// lookup returns the position of the existing entry for key, or -1 when the
+// row creates a new one.
+func (idx *csvKeyIndex) lookup(key string, line int) (int, error) {
+	if previous, ok := idx.seen[key]; ok {
+		return 0, fmt.Errorf("repite la clave de la línea %d", previous)
+	}
+	idx.seen[key] = line
+
+	matches := idx.existing[key]
+	switch len(matches) {
+	case 0:
+		return -1, nil
+	case 1:
+		return matches[0], nil
+	default:
+		return 0, fmt.Errorf("hay %d registros con esta clave; edítalos manualmente", len(matches))
+	}
+}
+
+func csvKey(parts ...string) string {
+	return strings.ToLower(strings.Join(parts, "\x00"))
+}
+
+// csvFormulaPrefixes start cells that spreadsheets may evaluate as formulas;
+// tab and carriage return can hide one of the others.
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvText escapes a free-text cell for export. Values that a spreadsheet
+// would evaluate as a formula get a leading quote, which it shows as text.
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune(csvFormulaPrefixes, rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// csvTextValue undoes csvText, so exported files import unchanged.
+func csvTextValue(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune(csvFormulaPrefixes, rune(value[1])) {
+		return value[1:]
+	}
+	return value
+}
+
+func formatCSVFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func formatCSVBool(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+func (s *server) materialCSVRecords() ([][]string, error) {
+	materials, err := s.store().listMaterials()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([][]string, 0, len(materials))
+	for _, m := range materials {
+		records = append(records, []string{csvText(m.Name), formatCSVFloat(m.CostPerKg), csvText(m.Notes), formatCSVBool(m.Active)})
+	}
+	return records, nil
+}
+
+// planMaterialsImport matches materials by name, ignoring case.
+func (s *server) planMaterialsImport(rows []csvRow) ([]csvImportRow, error) {
+	existing, err := s.store().listMaterials()
+	if err != nil {
+		return nil, err
+	}
+	index := newCSVKeyIndex()
+	for i, m := range existing {
+		index.add(csvKey(m.Name), i)
+	}
+
+	planned := make([]csvImportRow, 0, len(rows))
+	for _, row := range rows {
+		p := newCSVImportRow(row, materialCSVColumns)
+		if p.Error != "" {
+			planned = append(planned, p)
+			continue
+		}
+
+		m, err := parseMaterialForm(row.value)
+		position := -1
+		if err == nil {
+			position, err = index.lookup(csvKey(m.Name), row.Line)
+		}
+		if err != nil {
+			p.Error = err.Error()
+			planned = append(planned, p)
+			continue
+		}
+		if position >= 0 {
+			m.ID = existing[position].ID
+		}
+
+		switch {
+		case position < 0:
+			p.Action = csvActionCreate
+			p.apply = func(st store) (func(), error) {
+				id, err := st.createMaterial(m)
+				if err != nil {
+					return nil, err
+				}
+				m.ID = id
+				return func() { s.announceMaterialCreated(m) }, nil
+			}
+		case existing[position] == m:
+			p.Action = csvActionUnchanged
+		default:
+			p.Action = csvActionUpdate
+			p.apply = func(st store) (func(), error) {
+				previousCost, _, err := st.updateMaterial(m.ID, m)
+				if err != nil {
+					return nil, err
+				}
+				return func() { s.announceMaterialUpdated(m, previousCost) }, nil
+			}
+		}
+		planned = append(planned, p)
+	}
+
+	return planned, nil
+}
+
+func (s *server) shippingCSVRecords() ([][]string, error) {
+	rates, err := s.store().listShippingRates()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([][]string, 0, len(rates))
+	for _, rate := range rates {
+		records = append(records, []string{rate.Scope, csvText(rate.Country), csvText(rate.City), formatCSVFloat(rate.FlatCost), csvText(rate.Notes), formatCSVBool(rate.Active)})
+	}
+	return records, nil
+}
+
+// planShippingImport matches shipping rates by scope, country and city,
+// ignoring case.
+func (s *server) planShippingImport(rows []csvRow) ([]csvImportRow, error) {
+	existing, err := s.store().listShippingRates()
+	if err != nil {
+		return nil, err
+	}
+	index := newCSVKeyIndex()
+	for i, rate := range existing {
+		index.add(csvKey(rate.Scope, rate.Country, rate.City), i)
+	}
+
+	planned := make([]csvImportRow, 0, len(rows))
+	for _, row := range rows {
+		p := newCSVImportRow(row, shippingCSVColumns)
+		if p.Error != "" {
+			planned = append(planned, p)
+			continue
+		}
+
+		rate, err := parseShippingRateForm(row.value)
+		position := -1
+		if err == nil {
+			position, err = index.lookup(csvKey(rate.Scope, rate.Country, rate.City), row.Line)
+		}
+		if err != nil {
+			p.Error = err.Error()
+			planned = append(planned, p)
+			continue
+		}
+		if position >= 0 {
+			rate.ID = existing[position].ID
+		}
+
+		switch {
+		case position < 0:
+			p.Action = csvActionCreate
+			p.apply = func(st store) (func(), error) {
+				_, err := st.createShippingRate(rate)
+				return nil, err
+			}
+		case existing[position] == rate:
+			p.Action = csvActionUnchanged
+		default:
+			p.Action = csvActionUpdate
+			p.apply = func(st store) (func(), error) {
+				_, err := st.updateShippingRate(rate.ID, rate)
+				return nil, err
+			}
+		}
+		planned = append(planned, p)
+	}
+
+	return planned, nil
+}
+
+func (s *server) packagingCSVRecords() ([][]string, error) {
+	rates, err := s.store().listPackagingRates()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([][]string, 0, len(rates))
+	for _, rate := range rates {
+		records = append(records, []string{csvText(rate.Name), formatCSVFloat(rate.FlatCost), csvText(rate.Notes), formatCSVBool(rate.Active)})
+	}
+	return records, nil
+}
+
+// planPackagingImport matches packaging rates by name, ignoring case.
+func (s *server) planPackagingImport(rows []csvRow) ([]csvImportRow, error) {
+	existing, err := s.store().listPackagingRates()
+	if err != nil {
+		return nil, err
+	}
+	index := newCSVKeyIndex()
+	for i, rate := range existing {
+		index.add(csvKey(rate.Name), i)
+	}
+
+	planned := make([]csvImportRow, 0, len(rows))
+	for _, row := range rows {
+		p := newCSVImportRow(row, packagingCSVColumns)
+		if p.Error != "" {
+			planned = append(planned, p)
+			continue
+		}
+
+		rate, err := parsePackagingRateForm(row.value)
+		position := -1
+		if err == nil {
+			position, err = index.lookup(csvKey(rate.Name), row.Line)
+		}
+		if err != nil {
+			p.Error = err.Error()
+			planned = append(planned, p)
+			continue
+		}
+		if position >= 0 {
+			rate.ID = existing[position].ID
+		}
+
+		switch {
+		case position < 0:
+			p.Action = csvActionCreate
+			p.apply = func(st store) (func(), error) {
+				_, err := st.createPackagingRate(rate)
+				return nil, err
+			}
+		case existing[position] == rate:
+			p.Action = csvActionUnchanged
+		default:
+			p.Action = csvActionUpdate
+			p.apply = func(st store) (func(), error) {
+				_, err := st.updatePackagingRate(rate.ID, rate)
+				return nil, err
+			}
+		}
+		planned = append(planned, p)
+	}
+
+	return planned, nil
+}
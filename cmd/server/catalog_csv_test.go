@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseCatalogCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    int
+		wantErr string
+	}{
+		{name: "comma", data: "name,flat_cost,notes,active\nCaja,1500,,1\n", want: 1},
+		{name: "semicolon with bom and reordered header", data: "\ufeffActive;Notes;Name;Flat_Cost\r\n1;;Caja;1500\r\n\r\n0;;Sobre;500\r\n", want: 2},
+		{name: "header only", data: "name,flat_cost,notes,active\n", want: 0},
+		{name: "empty", data: "", wantErr: "vacío"},
+		{name: "missing column", data: "name,flat_cost,notes\nCaja,1500,\n", wantErr: "falta la columna active"},
+		{name: "unknown column", data: "name,flat_cost,notes,active,id\nCaja,1500,,1,3\n", wantErr: "columna desconocida"},
+		{name: "wrong field count", data: "name,flat_cost,notes,active\nCaja,1500\n", wantErr: "CSV inválido"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := parseCatalogCSV([]byte(tt.data), packagingCSVColumns)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCatalogCSV returned error: %v", err)
+			}
+			if len(rows) != tt.want {
+				t.Fatalf("expected %d rows, got %d", tt.want, len(rows))
+			}
+		})
+	}
+
+	rows, err := parseCatalogCSV([]byte("name;flat_cost;notes;active\n\n Caja ;1500;;1\n"), packagingCSVColumns)
+	if err != nil {
+		t.Fatalf("parseCatalogCSV returned error: %v", err)
+	}
+	if rows[0].Line != 3 || rows[0].value("name") != "Caja" {
+		t.Fatalf("unexpected row: %+v", rows[0])
+	}
+}
+
+func TestPlanShippingImport(t *testing.T) {
+	srv := &server{db: newMigratedTestDB(t)}
+	for _, rate := range []shippingRate{
+		{Scope: "CO", Country: "Colombia", City: "Bogotá", FlatCost: 12000, Active: true},
+		{Scope: "CO", Country: "Colombia", City: "Cali", FlatCost: 15000, Active: true},
+		{Scope: "INTL", Country: "Chile", FlatCost: 60000, Active: true},
+		{Scope: "INTL", Country: "Chile", FlatCost: 65000, Active: true},
+	} {
+		if _, err := srv.store().createShippingRate(rate); err != nil {
+			t.Fatalf("createShippingRate returned error: %v", err)
+		}
+	}
+
+	rows, err := parseCatalogCSV([]byte(strings.Join([]string{
+		"scope,country,city,flat_cost,notes,active",
+		"CO,Colombia,Bogotá,12000,,1",
+		"CO,colombia,CALI,18000,,1",
+		"CO,Colombia,Medellín,14000,,yes",
+		"CO,Colombia,Medellín,14000,,1",
+		"CO,Colombia,Pasto,-1,,1",
+		"XX,Colombia,Pasto,1,,1",
+		"INTL,Chile,,70000,,1",
+		"INTL,Perú,,70000,,0",
+		"CO,Colombia,Medellín,14000,,1",
+	}, "\n")), shippingCSVColumns)
+	if err != nil {
+		t.Fatalf("parseCatalogCSV returned error: %v", err)
+	}
+
+	planned, err := srv.planShippingImport(rows)
+	if err != nil {
+		t.Fatalf("planShippingImport returned error: %v", err)
+	}
+
+	want := []struct {
+		action string
+		err    string
+	}{
+		{action: csvActionUnchanged},
+		{action: csvActionUpdate},
+		{err: "active debe ser 1 o 0"},
+		{action: csvActionCreate},
+		{err: "flat_cost debe ser mayor o igual a 0"},
+		{err: "scope debe ser CO o INTL"},
+		{err: "hay 2 registros"},
+		{action: csvActionCreate},
+		{err: "repite la clave de la línea 5"},
+	}
+	if len(planned) != len(want) {
+		t.Fatalf("expected %d planned rows, got %d", len(want), len(planned))
+	}
+	for i, w := range want {
+		got := planned[i]
+		if w.err != "" {
+			if !strings.Contains(got.Error, w.err) || got.apply != nil {
+				t.Fatalf("row %d: expected error %q, got %+v", i, w.err, got)
+			}
+			continue
+		}
+		if got.Error != "" || got.Action != w.action {
+			t.Fatalf("row %d: expected %s, got %+v", i, w.action, got)
+		}
+	}
+}
+
+func TestCatalogCSVImportAndExport(t *testing.T) {
+	srv := &server{db: newMigratedTestDB(t)}
+	if _, err := srv.createMaterial(material{Name: "PLA", CostPerKg: 80000, Active: true}); err != nil {
+		t.Fatalf("createMaterial returned error: %v", err)
+	}
+
+	form := url.Values{"csv": {"name,cost_per_kg,notes,active\npla,85000,mate,1\nPETG,95000,,0\n"}}
+	req := httptest.NewRequest(http.MethodPost, "/admin/materials/import", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	srv.handleAdminMaterialsImport(rec, req)
+
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	if msg := location.Query().Get("success"); !strings.Contains(msg, "1 creados, 1 actualizados") {
+		t.Fatalf("unexpected redirect %s", location)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.handleAdminMaterialsExport(rec, httptest.NewRequest(http.MethodGet, "/admin/materials/export.csv", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("unexpected export response %d %v", rec.Code, rec.Header())
+	}
+	want := "name,cost_per_kg,notes,active\nPETG,95000,,0\npla,85000,mate,1\n"
+	if rec.Body.String() != want {
+		t.Fatalf("unexpected export:\n%s", rec.Body.String())
+	}
+}
+
+// failingMaterialStore fails to create the material named failName, also
+// inside transactions.
+type failingMaterialStore struct {
+	store
+	failName string
+}
+
+func (f failingMaterialStore) withTx(fn func(st store) error) error {
+	return f.store.withTx(func(st store) error {
+		return fn(failingMaterialStore{store: st, failName: f.failName})
+	})
+}
+
+func (f failingMaterialStore) createMaterial(m material) (int64, error) {
+	if m.Name == f.failName {
+		return 0, errors.New("disk I/O error")
+	}
+	return f.store.createMaterial(m)
+}
+
+func TestCatalogCSVImportRollsBackOnError(t *testing.T) {
+	database := newMigratedTestDB(t)
+	srv := &server{db: database, repo: failingMaterialStore{store: newSQLStore(database), failName: "ABS"}}
+	if _, err := srv.createMaterial(material{Name: "PLA", CostPerKg: 80000, Active: true}); err != nil {
+		t.Fatalf("createMaterial returned error: %v", err)
+	}
+	insertTestWebhook(t, srv, "http://127.0.0.1:1/hook", "secret", "material.created,material.price_changed", true)
+
+	form := url.Values{"csv": {"name,cost_per_kg,notes,active\nPLA,85000,,1\nPETG,95000,,1\nABS,70000,,1\n"}}
+	req := httptest.NewRequest(http.MethodPost, "/admin/materials/import", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	srv.handleAdminMaterialsImport(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	materials, err := srv.store().listMaterials()
+	if err != nil {
+		t.Fatalf("listMaterials returned error: %v", err)
+	}
+	if len(materials) != 1 || materials[0].CostPerKg != 80000 {
+		t.Fatalf("expected the import to be rolled back, got %+v", materials)
+	}
+	var deliveries int
+	if err := database.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries`).Scan(&deliveries); err != nil {
+		t.Fatalf("count deliveries: %v", err)
+	}
+	if deliveries != 0 {
+		t.Fatalf("expected no webhooks for a rolled back import, got %d", deliveries)
+	}
+}
+
+func TestCatalogCSVExportEscapesFormulas(t *testing.T) {
+	srv := &server{db: newMigratedTestDB(t)}
+	if _, err := srv.createMaterial(material{Name: `=HYPERLINK("http://x","PLA")`, CostPerKg: 80000, Notes: "-5% proveedor", Active: true}); err != nil {
+		t.Fatalf("createMaterial returned error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.handleAdminMaterialsExport(rec, httptest.NewRequest(http.MethodGet, "/admin/materials/export.csv", nil))
+	want := "name,cost_per_kg,notes,active\n\"'=HYPERLINK(\"\"http://x\"\",\"\"PLA\"\")\",80000,'-5% proveedor,1\n"
+	if rec.Body.String() != want {
+		t.Fatalf("unexpected export:\n%s", rec.Body.String())
+	}
+
+	rows, err := parseCatalogCSV(rec.Body.Bytes(), materialCSVColumns)
+	if err != nil {
+		t.Fatalf("parseCatalogCSV returned error: %v", err)
+	}
+	planned, err := srv.planMaterialsImport(rows)
+	if err != nil {
+		t.Fatalf("planMaterialsImport returned error: %v", err)
+	}
+	if len(planned) != 1 || planned[0].Action != csvActionUnchanged {
+		t.Fatalf("expected the export to import unchanged, got %+v", planned)
+	}
+}
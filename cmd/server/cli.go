@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Simplici0/o.works/internal/backup"
+	"github.com/Simplici0/o.works/internal/config"
+	"github.com/Simplici0/o.works/internal/db"
+	"github.com/Simplici0/o.works/internal/migrations"
+)
+
+// cli runs the operations subcommands of the server binary. Results go to
+// out; passwords are read from in so they never appear in the process list.
+type cli struct {
+	cfg config.Config
+	in  io.Reader
+	out io.Writer
+}
+
+type cliCommand struct {
+	Name    string
+	Args    string
+	Summary string
+	Run     func(c cli, args []string) error
+}
+
+// cliCommands is a function rather than a variable because the commands
+// print their own usage from it.
+func cliCommands() []cliCommand {
+	return []cliCommand{
+		{Name: "migrate", Args: "up|down|status", Summary: "apply, roll back one or list the database migrations", Run: cli.migrate},
+		{Name: "create-user", Args: "-email <email> -role <role>", Summary: "create a user; the password is read from standard input", Run: cli.createUser},
+		{Name: "reset-password", Args: "-email <email>", Summary: "set a user's password from standard input and end their sessions", Run: cli.resetPassword},
+		{Name: "export-quotes", Args: "[-q <search>] [-format csv|xlsx] [-o <file>]", Summary: "export the quote list, like /quotes/export.csv", Run: cli.exportQuotes},
+		{Name: "backup", Summary: "write a database backup to BACKUP_DIR and apply BACKUP_KEEP", Run: cli.backup},
+		{Name: "restore", Args: "<backup-file>", Summary: "validate a backup and replace the database with it; stop the server first", Run: cli.restore},
+		{Name: "recalc-quote", Args: "[-save] <id>", Summary: "price a saved quote again with the current rates; -save stores the result as a new quote", Run: cli.recalcQuote},
+	}
+}
+
+// runCommand runs the subcommand named by args[0].
+func runCommand(cfg config.Config, args []string, in io.Reader, out io.Writer) error {
+	c := cli{cfg: cfg, in: in, out: out}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		c.usage()
+		return nil
+	}
+	for _, cmd := range cliCommands() {
+		if cmd.Name == args[0] {
+			return cmd.Run(c, args[1:])
+		}
+	}
+	c.usage()
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func (c cli) usage() {
+	fmt.Fprintln(c.out, "usage: server [command]")
+	fmt.Fprintln(c.out, "Without a command the HTTP server starts. Commands:")
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	for _, cmd := range cliCommands() {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.Name, cmd.Args, cmd.Summary)
+	}
+	_ = tw.Flush()
+}
+
+func (c cli) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.out)
+	for _, cmd := range cliCommands() {
+		if cmd.Name == name {
+			fs.Usage = func() {
+				fmt.Fprintf(c.out, "usage: server %s %s\n%s\n", cmd.Name, cmd.Args, cmd.Summary)
+				fs.PrintDefaults()
+			}
+		}
+	}
+	return fs
+}
+
+// openServer opens the configured database and wraps it in a server so the
+// commands reuse the same queries as the HTTP handlers.
+func (c cli) openServer() (*server, error) {
+	database, err := openDatabase(c.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+
+	return &server{
+		auth: newAuthService(database, c.cfg.SessionSecret, sessionPolicy{
+			IdleTimeout: c.cfg.SessionIdleTimeout,
+			MaxAge:      c.cfg.SessionMaxAge,
+		}),
+		db:         database,
+		backupDir:  c.cfg.BackupDir,
+		backupKeep: c.cfg.BackupKeep,
+	}, nil
+}
+
+func (c cli) migrate(args []string) error {
+	fs := c.flagSet("migrate")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("migrate needs up, down or status")
+	}
+
+	database, err := openDatabase(c.cfg)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer database.Close()
+
+	switch fs.Arg(0) {
+	case "up":
+		if err := migrations.Up(database); err != nil {
+			return err
+		}
+		version, err := migrations.Version(database)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "database is at version %d\n", version)
+	case "down":
+		version, err := migrations.Down(database)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "rolled back version %d\n", version)
+	case "status":
+		statuses, err := migrations.List(database)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "version\tapplied at\tmigration")
+		for _, m := range statuses {
+			applied := "pending"
+			if m.Applied {
+				applied = m.AppliedAt.Format(time.DateTime)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", m.Version, applied, m.Name)
+		}
+		return tw.Flush()
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown migrate action %q", fs.Arg(0))
+	}
+	return nil
+}
+
+func (c cli) createUser(args []string) error {
+	fs := c.flagSet("create-user")
+	email := fs.String("email", "", "email used to sign in")
+	role := fs.String("role", "", "one of "+strings.Join(userRoles, ", "))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	created := user{Email: strings.ToLower(strings.TrimSpace(*email)), Role: strings.TrimSpace(*role)}
+	if created.Email == "" || !strings.Contains(created.Email, "@") {
+		return errors.New("invalid -email")
+	}
+	if !isValidRole(created.Role) {
+		return fmt.Errorf("-role must be one of %s", strings.Join(userRoles, ", "))
+	}
+	password, err := c.readPassword()
+	if err != nil {
+		return err
+	}
+
+	srv, err := c.openServer()
+	if err != nil {
+		return err
+	}
+	defer srv.db.Close()
+
+	exists, err := srv.store().userExists(created.Email)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("user %s already exists", created.Email)
+	}
+
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	created.ID, err = srv.store().createUser(created.Email, passwordHash, created.Role)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "created user %d %s (%s)\n", created.ID, created.Email, created.Role)
+	return nil
+}
+
+func (c cli) resetPassword(args []string) error {
+	fs := c.flagSet("reset-password")
+	email := fs.String("email", "", "email of the user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	password, err := c.readPassword()
+	if err != nil {
+		return err
+	}
+
+	srv, err := c.openServer()
+	if err != nil {
+		return err
+	}
+	defer srv.db.Close()
+
+	id, err := srv.store().getUserIDByEmail(strings.ToLower(strings.TrimSpace(*email)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("user %q not found", *email)
+	}
+	if err != nil {
+		return fmt.Errorf("query user: %w", err)
+	}
+
+	if err := srv.auth.setPassword(id, password); err != nil {
+		return err
+	}
+	if err := srv.auth.deleteUserSessions(id); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "password updated for user %d; their sessions were ended\n", id)
+	return nil
+}
+
+// readPassword reads the first line of c.in and applies the same rules as
+// the password forms.
+func (c cli) readPassword() (string, error) {
+	line, err := bufio.NewReader(c.in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if err := validateNewPassword(password, password); err != nil {
+		return "", fmt.Errorf("password from standard input: %w", err)
+	}
+	return password, nil
+}
+
+func (c cli) exportQuotes(args []string) error {
+	fs := c.flagSet("export-quotes")
+	query := fs.String("q", "", "only quotes whose title or notes contain this text")
+	format := fs.String("format", "csv", "csv or xlsx")
+	output := fs.String("o", "-", "output file; - writes to standard output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	write := writeQuoteExportCSV
+	switch *format {
+	case "csv":
+	case "xlsx":
+		write = writeQuoteExportXLSX
+	default:
+		return fmt.Errorf("unknown -format %q", *format)
+	}
+
+	srv, err := c.openServer()
+	if err != nil {
+		return err
+	}
+	defer srv.db.Close()
+
+	table, err := srv.quoteExport(strings.TrimSpace(*query))
+	if err != nil {
+		return err
+	}
+
+	if *output == "-" {
+		return write(c.out, table)
+	}
+	f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("create output file: %w", err)
+	}
+	if err := write(f, table); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close output file: %w", err)
+	}
+	fmt.Fprintf(c.out, "exported %d quotes to %s\n", len(table.Rows), *output)
+	return nil
+}
+
+func (c cli) backup(args []string) error {
+	fs := c.flagSet("backup")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if c.cfg.IsPostgres() {
+		return errPostgresBackup
+	}
+
+	srv, err := c.openServer()
+	if err != nil {
+		return err
+	}
+	defer srv.db.Close()
+
+	path, err := srv.createBackup(context.Background(), time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "backup written to %s\n", path)
+	return nil
+}
+
+// errPostgresBackup is returned by the backup commands, which only handle
+// SQLite files.
+var errPostgresBackup = errors.New("backups only cover SQLite; use pg_dump and pg_restore for PostgreSQL")
+
+// restore validates the backup against the migrations known to this build,
+// saves a copy of the current database in the backup directory and then
+// replaces it.
+func (c cli) restore(args []string) error {
+	fs := c.flagSet("restore")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("restore needs exactly one backup file")
+	}
+	src := fs.Arg(0)
+	if c.cfg.IsPostgres() {
+		return errPostgresBackup
+	}
+
+	latest, err := migrations.Latest()
+	if err != nil {
+		return err
+	}
+	version, err := backup.Validate(src, latest)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "backup %s is valid (schema version %d)\n", src, version)
+
+	if _, err := os.Stat(c.cfg.DBPath); err == nil {
+		current, err := db.Open(c.cfg.DBPath)
+		if err != nil {
+			return fmt.Errorf("open current database: %w", err)
+		}
+		path, err := backup.Create(context.Background(), current, c.cfg.BackupDir, time.Now())
+		current.Close()
+		if err != nil {
+			return fmt.Errorf("back up current database: %w", err)
+		}
+		fmt.Fprintf(c.out, "current database saved to %s\n", path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("stat current database: %w", err)
+	}
+
+	if err := backup.Restore(src, c.cfg.DBPath); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "database %s restored from %s\n", c.cfg.DBPath, src)
+	if version < latest {
+		fmt.Fprintf(c.out, "the backup is at schema version %d; run \"server migrate up\" to reach %d\n", version, latest)
+	}
+	return nil
+}
+
+// recalcQuote prices a saved quote's inputs with today's rates, materials and
+// taxes. Saved quotes are snapshots, so -save stores a new quote instead of
+// changing the original.
+func (c cli) recalcQuote(args []string) error {
+	fs := c.flagSet("recalc-quote")
+	save := fs.Bool("save", false, "store the recalculated quote as a new quote")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("recalc-quote needs a quote id")
+	}
+	id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil || id <= 0 {
+		return fmt.Errorf("invalid quote id %q", fs.Arg(0))
+	}
+
+	srv, err := c.openServer()
+	if err != nil {
+		return err
+	}
+	defer srv.db.Close()
+
+	stored, err := srv.store().getQuote(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("quote %d not found", id)
+	}
+	if err != nil {
+		return err
+	}
+	values, err := srv.store().getQuoteFormValues(id)
+	if err != nil {
+		return err
+	}
+	calc, err := srv.calculateQuote(values)
+	if err != nil {
+		return fmt.Errorf("recalculate quote %d: %w", id, err)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "\tquote %d (%s)\trecalculated (%s)\t\n", id, stored.Currency, calc.Currency)
+	for _, line := range []struct {
+		name          string
+		stored, fresh float64
+	}{
+		{"pre_tax", stored.Result.Totals.PreTax, calc.Result.Totals.PreTax},
+		{"total", stored.Result.Totals.Total, calc.Result.Totals.Total},
+		{"payable", stored.Result.Totals.Payable, calc.Result.Totals.Payable},
+	} {
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t\n", line.name, line.stored, line.fresh)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if *save {
+		newID, err := srv.store().saveQuote(calc)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "saved as quote %d\n", newID)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Simplici0/o.works/internal/backup"
+	"github.com/Simplici0/o.works/internal/config"
+	"github.com/Simplici0/o.works/internal/db"
+)
+
+func runTestCommand(t *testing.T, cfg config.Config, stdin string, args ...string) (string, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+	err := runCommand(cfg, args, strings.NewReader(stdin), &out)
+	return out.String(), err
+}
+
+func TestCLICommands(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Config{
+		DBPath:        filepath.Join(dir, "cli.db"),
+		SessionSecret: "secret",
+		BackupDir:     filepath.Join(dir, "backups"),
+		BackupKeep:    3,
+	}
+
+	if out, err := runTestCommand(t, cfg, "", "migrate", "up"); err != nil || !strings.Contains(out, "database is at version") {
+		t.Fatalf("migrate up = %q, %v", out, err)
+	}
+	if out, err := runTestCommand(t, cfg, "", "migrate", "status"); err != nil || strings.Contains(out, "pending") || !strings.Contains(out, "00001_create_schema.sql") {
+		t.Fatalf("migrate status = %q, %v", out, err)
+	}
+
+	if _, err := runTestCommand(t, cfg, "corta\n", "create-user", "-email", "ops@example.com", "-role", "operator"); err == nil {
+		t.Fatalf("expected a short password to be rejected")
+	}
+	if _, err := runTestCommand(t, cfg, "una clave larga\n", "create-user", "-email", "ops@example.com", "-role", "jefe"); err == nil {
+		t.Fatalf("expected an unknown role to be rejected")
+	}
+	if out, err := runTestCommand(t, cfg, "una clave larga\n", "create-user", "-email", "Ops@Example.com", "-role", "operator"); err != nil || !strings.Contains(out, "ops@example.com (operator)") {
+		t.Fatalf("create-user = %q, %v", out, err)
+	}
+	if _, err := runTestCommand(t, cfg, "una clave larga\n", "create-user", "-email", "ops@example.com", "-role", "viewer"); err == nil {
+		t.Fatalf("expected a duplicate user to be rejected")
+	}
+	if _, err := runTestCommand(t, cfg, "otra clave larga", "reset-password", "-email", "ops@example.com"); err != nil {
+		t.Fatalf("reset-password returned error: %v", err)
+	}
+
+	database, err := db.Open(cfg.DBPath)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+	auth := newAuthService(database, cfg.SessionSecret, defaultSessionPolicy)
+	if _, ok, err := auth.validateCredentials("ops@example.com", "otra clave larga"); err != nil || !ok {
+		t.Fatalf("expected the new password to work, got %v, %v", ok, err)
+	}
+
+	srv := &server{db: database}
+	if _, err := database.Exec(`INSERT INTO materials (name, cost_per_kg) VALUES ('PLA', 100000)`); err != nil {
+		t.Fatalf("seed material: %v", err)
+	}
+	calc, err := srv.calculateQuote(quoteFormValues{MaterialID: 1, Grams: 100, Quantity: 1, CustomerType: customerTypeNatural, Title: "Llaveros"})
+	if err != nil {
+		t.Fatalf("calculateQuote returned error: %v", err)
+	}
+	if _, err := srv.store().saveQuote(calc); err != nil {
+		t.Fatalf("saveQuote returned error: %v", err)
+	}
+	if _, err := database.Exec(`UPDATE materials SET cost_per_kg = 200000`); err != nil {
+		t.Fatalf("update material: %v", err)
+	}
+
+	out, err := runTestCommand(t, cfg, "", "recalc-quote", "-save", "1")
+	if err != nil || !strings.Contains(out, "10000.00") || !strings.Contains(out, "20000.00") || !strings.Contains(out, "saved as quote 2") {
+		t.Fatalf("recalc-quote = %q, %v", out, err)
+	}
+	original, err := srv.store().getQuote(1)
+	if err != nil || original.Result.Totals.Total != 10000 {
+		t.Fatalf("expected the original quote to keep its totals, got %+v, %v", original.Result.Totals, err)
+	}
+
+	out, err = runTestCommand(t, cfg, "", "export-quotes", "-q", "Llave")
+	if err != nil || strings.Count(out, "Llaveros") != 2 || !strings.HasPrefix(out, "id,created_at,title") {
+		t.Fatalf("export-quotes = %q, %v", out, err)
+	}
+
+	if out, err := runTestCommand(t, cfg, "", "backup"); err != nil || !strings.Contains(out, cfg.BackupDir) {
+		t.Fatalf("backup = %q, %v", out, err)
+	}
+	if files, err := backup.List(cfg.BackupDir); err != nil || len(files) != 1 {
+		t.Fatalf("expected one backup, got %+v, %v", files, err)
+	}
+
+	if _, err := runTestCommand(t, cfg, "", "serve-forever"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Fatalf("expected an unknown command error, got %v", err)
+	}
+}
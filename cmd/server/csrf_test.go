@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	srv := &server{auth: newAuthService(nil, "secret", defaultSessionPolicy)}
+	handler := srv.csrfMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(csrfTokenFromRequest(r)))
+	}))
+
+	// A first GET issues the cookie and exposes the matching token.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET status = %d", rec.Code)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookieName || !cookies[0].HttpOnly {
+		t.Fatalf("expected csrf cookie, got %+v", cookies)
+	}
+	cookie := cookies[0]
+	token := rec.Body.String()
+	if token == "" || token == cookie.Value {
+		t.Fatalf("expected a token derived from the cookie, got %q", token)
+	}
+
+	post := func(form url.Values, header string, withCookie bool) int {
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header != "" {
+			req.Header.Set(csrfHeader, header)
+		}
+		if withCookie {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name       string
+		form       url.Values
+		header     string
+		withCookie bool
+		want       int
+	}{
+		{name: "form field", form: url.Values{csrfFormField: {token}}, withCookie: true, want: http.StatusOK},
+		{name: "htmx header", header: token, withCookie: true, want: http.StatusOK},
+		{name: "missing token", withCookie: true, want: http.StatusForbidden},
+		{name: "wrong token", form: url.Values{csrfFormField: {"nope"}}, withCookie: true, want: http.StatusForbidden},
+		{name: "missing cookie", form: url.Values{csrfFormField: {token}}, want: http.StatusForbidden},
+		{name: "raw cookie value", form: url.Values{csrfFormField: {cookie.Value}}, withCookie: true, want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := post(tt.form, tt.header, tt.withCookie); got != tt.want {
+				t.Fatalf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type exchangeRate struct {
+	ID       int64
+	Currency string
+	Rate     float64
+	Source   string
+	Active   bool
+}
+
+type exchangeRatesViewData struct {
+	baseViewData
+	BaseCurrency  string
+	ExchangeRates []exchangeRate
+}
+
+func (s *server) handleAdminExchangeRatesForm(w http.ResponseWriter, r *http.Request) {
+	rates, err := s.store().getRateConfig()
+	if err != nil {
+		serverError(w, r, "failed to load rate config", err)
+		return
+	}
+
+	exchangeRates, err := s.listExchangeRates()
+	if err != nil {
+		serverError(w, r, "failed to load exchange rates", err)
+		return
+	}
+
+	s.renderTemplate(w, r, "admin_exchange_rates.html", exchangeRatesViewData{
+		baseViewData: baseViewData{
+			ErrorMessage:   r.URL.Query().Get("error"),
+			SuccessMessage: r.URL.Query().Get("success"),
+		},
+		BaseCurrency:  rates.Currency,
+		ExchangeRates: exchangeRates,
+	})
+}
+
+func (s *server) handleAdminExchangeRatesCreate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	rate, err := parseExchangeRateForm(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/exchange-rates?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	conflict, err := s.exchangeRateCurrencyConflict(rate.Currency, 0)
+	if err != nil {
+		serverError(w, r, "failed to create exchange rate", err)
+		return
+	}
+	if conflict != "" {
+		http.Redirect(w, r, "/admin/exchange-rates?error="+url.QueryEscape(conflict), http.StatusSeeOther)
+		return
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO exchange_rates (currency, rate, source, active)
+		VALUES (?, ?, 'manual', ?)
+	`, rate.Currency, rate.Rate, rate.Active)
+	if err != nil {
+		serverError(w, r, "failed to create exchange rate", err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/exchange-rates?success=Tasa+de+cambio+creada+correctamente", http.StatusSeeOther)
+}
+
+func (s *server) handleAdminExchangeRatesUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid exchange rate id", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	rate, err := parseExchangeRateForm(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/exchange-rates?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	conflict, err := s.exchangeRateCurrencyConflict(rate.Currency, id)
+	if err != nil {
+		serverError(w, r, "failed to update exchange rate", err)
+		return
+	}
+	if conflict != "" {
+		http.Redirect(w, r, "/admin/exchange-rates?error="+url.QueryEscape(conflict), http.StatusSeeOther)
+		return
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE exchange_rates
+		SET
+			currency = ?,
+			rate = ?,
+			source = 'manual',
+			active = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, rate.Currency, rate.Rate, rate.Active, id)
+	if err != nil {
+		serverError(w, r, "failed to update exchange rate", err)
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		serverError(w, r, "failed to update exchange rate", err)
+		return
+	}
+	if affected == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/exchange-rates?success=Tasa+de+cambio+actualizada+correctamente", http.StatusSeeOther)
+}
+
+func (s *server) handleAdminExchangeRatesImport(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	rates, err := parseExchangeRateImport(r.FormValue("rates"))
+	if err != nil {
+		http.Redirect(w, r, "/admin/exchange-rates?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	config, err := s.store().getRateConfig()
+	if err != nil {
+		serverError(w, r, "failed to load rate config", err)
+		return
+	}
+	for _, rate := range rates {
+		if rate.Currency == config.Currency {
+			http.Redirect(w, r, "/admin/exchange-rates?error="+url.QueryEscape(rate.Currency+" es la moneda base"), http.StatusSeeOther)
+			return
+		}
+	}
+
+	if err := s.importExchangeRates(rates); err != nil {
+		serverError(w, r, "failed to import exchange rates", err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/exchange-rates?success="+url.QueryEscape(fmt.Sprintf("%d tasas importadas", len(rates))), http.StatusSeeOther)
+}
+
+// exchangeRateCurrencyConflict returns the message to show when currency is the
+// base currency or already used by an exchange rate other than id.
+func (s *server) exchangeRateCurrencyConflict(currency string, id int64) (string, error) {
+	rates, err := s.store().getRateConfig()
+	if err != nil {
+		return "", err
+	}
+	if currency == rates.Currency {
+		return "currency no puede ser la moneda base", nil
+	}
+
+	var exists bool
+	err = s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM exchange_rates WHERE currency = ? AND id <> ?)`, currency, id).Scan(&exists)
+	if err != nil {
+		return "", fmt.Errorf("query exchange rate currency: %w", err)
+	}
+	if exists {
+		return "currency ya existe", nil
+	}
+	return "", nil
+}
+
+func parseExchangeRateForm(r *http.Request) (exchangeRate, error) {
+	rate := exchangeRate{
+		Currency: strings.ToUpper(strings.TrimSpace(r.FormValue("currency"))),
+		Active:   r.FormValue("active") == "1",
+	}
+
+	if !isCurrencyCode(rate.Currency) {
+		return rate, fmt.Errorf("currency debe ser un código ISO de 3 letras")
+	}
+
+	var err error
+	rate.Rate, err = parsePositiveFloat(r.FormValue("rate"), "rate")
+	if err != nil {
+		return rate, err
+	}
+
+	return rate, nil
+}
+
+// parseExchangeRateImport reads one "CUR,rate" pair per line. Semicolons are
+// accepted as separators too, and blank lines or lines starting with # are ignored.
+func parseExchangeRateImport(text string) ([]exchangeRate, error) {
+	rates := make([]exchangeRate, 0)
+	sc := bufio.NewScanner(strings.NewReader(text))
+	lineNumber := 0
+	for sc.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		currency, rawRate, ok := strings.Cut(strings.ReplaceAll(line, ";", ","), ",")
+		if !ok {
+			return nil, fmt.Errorf("línea %d: formato esperado MONEDA,tasa", lineNumber)
+		}
+
+		rate := exchangeRate{
+			Currency: strings.ToUpper(strings.TrimSpace(currency)),
+			Source:   "import",
+			Active:   true,
+		}
+		if !isCurrencyCode(rate.Currency) {
+			return nil, fmt.Errorf("línea %d: moneda inválida", lineNumber)
+		}
+
+		var err error
+		if rate.Rate, err = parsePositiveFloat(strings.TrimSpace(rawRate), "rate"); err != nil {
+			return nil, fmt.Errorf("línea %d: %w", lineNumber, err)
+		}
+
+		rates = append(rates, rate)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("no hay tasas para importar")
+	}
+
+	return rates, nil
+}
+
+func isCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *server) importExchangeRates(rates []exchangeRate) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin exchange rates import: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, rate := range rates {
+		_, err := tx.Exec(`
+			INSERT INTO exchange_rates (currency, rate, source, active)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT(currency) DO UPDATE SET
+				rate = excluded.rate,
+				source = excluded.source,
+				active = excluded.active,
+				updated_at = CURRENT_TIMESTAMP
+		`, rate.Currency, rate.Rate, rate.Source, rate.Active)
+		if err != nil {
+			return fmt.Errorf("upsert exchange rate %s: %w", rate.Currency, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit exchange rates import: %w", err)
+	}
+
+	return nil
+}
+
+func (s *server) listExchangeRates() ([]exchangeRate, error) {
+	rows, err := s.db.Query(`
+		SELECT id, currency, rate, source, active
+		FROM exchange_rates
+		ORDER BY currency ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query exchange rates: %w", err)
+	}
+	defer rows.Close()
+
+	exchangeRates := make([]exchangeRate, 0)
+	for rows.Next() {
+		var rate exchangeRate
+		if err := rows.Scan(&rate.ID, &rate.Currency, &rate.Rate, &rate.Source, &rate.Active); err != nil {
+			return nil, fmt.Errorf("scan exchange rate: %w", err)
+		}
+		exchangeRates = append(exchangeRates, rate)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate exchange rates: %w", err)
+	}
+
+	return exchangeRates, nil
+}
+
+// listQuoteCurrencies returns the base currency followed by every active
+// exchange rate currency.
+func (s *server) listQuoteCurrencies(baseCurrency string) ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT currency
+		FROM exchange_rates
+		WHERE active = TRUE AND currency <> ?
+		ORDER BY currency ASC
+	`, baseCurrency)
+	if err != nil {
+		return nil, fmt.Errorf("query active currencies: %w", err)
+	}
+	defer rows.Close()
+
+	currencies := []string{baseCurrency}
+	for rows.Next() {
+		var currency string
+		if err := rows.Scan(&currency); err != nil {
+			return nil, fmt.Errorf("scan active currency: %w", err)
+		}
+		currencies = append(currencies, currency)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate active currencies: %w", err)
+	}
+
+	return currencies, nil
+}
+
+// getExchangeRate returns how many units of the base currency one unit of
+// currency is worth.
+func (s *server) getExchangeRate(currency, baseCurrency string) (float64, error) {
+	if currency == "" || currency == baseCurrency {
+		return 1, nil
+	}
+
+	var rate float64
+	err := s.db.QueryRow(`SELECT rate FROM exchange_rates WHERE currency = ? AND active = TRUE`, currency).Scan(&rate)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("moneda no encontrada o inactiva")
+		}
+		return 0, fmt.Errorf("query exchange rate: %w", err)
+	}
+
+	return rate, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestParseExchangeRateImport_Success(t *testing.T) {
+	rates, err := parseExchangeRateImport("# tasas del día\nusd,4000\n\nEUR; 4350.5\n")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(rates) != 2 {
+		t.Fatalf("expected 2 rates, got %+v", rates)
+	}
+	if rates[0].Currency != "USD" || rates[0].Rate != 4000 || rates[0].Source != "import" || !rates[0].Active {
+		t.Fatalf("unexpected first rate: %+v", rates[0])
+	}
+	if rates[1].Currency != "EUR" || rates[1].Rate != 4350.5 {
+		t.Fatalf("unexpected second rate: %+v", rates[1])
+	}
+}
+
+func TestParseExchangeRateImport_ReportsLine(t *testing.T) {
+	_, err := parseExchangeRateImport("USD,4000\nEURO,4350\n")
+	if err == nil || err.Error() != "línea 2: moneda inválida" {
+		t.Fatalf("expected line 2 error, got %v", err)
+	}
+
+	if _, err := parseExchangeRateImport("USD,0\n"); err == nil {
+		t.Fatalf("expected rate validation error")
+	}
+	if _, err := parseExchangeRateImport("\n# vacío\n"); err == nil {
+		t.Fatalf("expected empty import error")
+	}
+}
+
+func TestExchangeRateStorage(t *testing.T) {
+	srv := &server{db: newMigratedTestDB(t)}
+	r := chi.NewRouter()
+	r.Post("/admin/exchange-rates", srv.handleAdminExchangeRatesCreate)
+	r.Post("/admin/exchange-rates/import", srv.handleAdminExchangeRatesImport)
+	r.Post("/admin/exchange-rates/{id}", srv.handleAdminExchangeRatesUpdate)
+
+	if rec := postForm(r, "/admin/exchange-rates", url.Values{"currency": {"usd"}, "rate": {"4000"}, "active": {"1"}}); rec.Code != http.StatusSeeOther {
+		t.Fatalf("create returned %d", rec.Code)
+	}
+	rec := postForm(r, "/admin/exchange-rates", url.Values{"currency": {"USD"}, "rate": {"4100"}, "active": {"1"}})
+	if !strings.Contains(rec.Header().Get("Location"), "error") {
+		t.Fatalf("expected a duplicate currency to be rejected, got %q", rec.Header().Get("Location"))
+	}
+
+	// The import updates USD in place and adds EUR.
+	if rec := postForm(r, "/admin/exchange-rates/import", url.Values{"rates": {"USD,4200.5\nEUR,4500"}}); rec.Code != http.StatusSeeOther {
+		t.Fatalf("import returned %d", rec.Code)
+	}
+	rates, err := srv.listExchangeRates()
+	if err != nil {
+		t.Fatalf("listExchangeRates returned error: %v", err)
+	}
+	if len(rates) != 2 || rates[0].Currency != "EUR" || rates[1].Currency != "USD" || rates[1].Rate != 4200.5 || rates[1].Source != "import" {
+		t.Fatalf("unexpected exchange rates %+v", rates)
+	}
+
+	if rec := postForm(r, fmt.Sprintf("/admin/exchange-rates/%d", rates[0].ID), url.Values{"currency": {"EUR"}, "rate": {"4500"}}); rec.Code != http.StatusSeeOther {
+		t.Fatalf("update returned %d", rec.Code)
+	}
+
+	for _, tc := range []struct {
+		target   string
+		currency string
+	}{
+		{"/admin/exchange-rates", "COP"},
+		{fmt.Sprintf("/admin/exchange-rates/%d", rates[0].ID), "COP"},
+		{fmt.Sprintf("/admin/exchange-rates/%d", rates[0].ID), "USD"},
+	} {
+		rec := postForm(r, tc.target, url.Values{"currency": {tc.currency}, "rate": {"1"}, "active": {"1"}})
+		if rec.Code != http.StatusSeeOther || !strings.Contains(rec.Header().Get("Location"), "error") {
+			t.Fatalf("%s %s: expected an error redirect, got %d %q", tc.target, tc.currency, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+	if rec := postForm(r, "/admin/exchange-rates/import", url.Values{"rates": {"COP,1"}}); !strings.Contains(rec.Header().Get("Location"), "error") {
+		t.Fatalf("expected importing the base currency to be rejected, got %q", rec.Header().Get("Location"))
+	}
+	currencies, err := srv.listQuoteCurrencies("COP")
+	if err != nil || strings.Join(currencies, ",") != "COP,USD" {
+		t.Fatalf("listQuoteCurrencies = %v, %v", currencies, err)
+	}
+	if rate, err := srv.getExchangeRate("USD", "COP"); err != nil || rate != 4200.5 {
+		t.Fatalf("getExchangeRate(USD) = %v, %v", rate, err)
+	}
+	if _, err := srv.getExchangeRate("EUR", "COP"); err == nil {
+		t.Fatal("expected an inactive currency to be rejected")
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 64
+)
+
+// newLogger writes JSON lines in production, where logs are collected, and
+// readable text in development.
+func newLogger(w io.Writer, dev bool) *slog.Logger {
+	if dev {
+		return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+	return slog.New(slog.NewJSONHandler(w, nil))
+}
+
+// requestInfo follows a request through the middleware chain. authMiddleware
+// fills in the user, which the access log reads after the handler returns.
+type requestInfo struct {
+	ID     string
+	UserID int64
+	Email  string
+}
+
+type requestInfoContextKey struct{}
+
+// requestIDMiddleware tags each request with an ID, reusing a well-formed
+// X-Request-ID from a proxy, and echoes it in the response.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		info := &requestInfo{ID: id}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestInfoContextKey{}, info)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
+
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoContextKey{}).(*requestInfo)
+	return info
+}
+
+// setRequestUser records the signed-in user for the access log.
+func setRequestUser(r *http.Request, user sessionUser) {
+	if info := requestInfoFromContext(r.Context()); info != nil {
+		info.UserID = user.UserID
+		info.Email = user.Email
+	}
+}
+
+// requestLogger returns the default logger tagged with the request ID.
+func requestLogger(r *http.Request) *slog.Logger {
+	if info := requestInfoFromContext(r.Context()); info != nil {
+		return slog.Default().With("request_id", info.ID)
+	}
+	return slog.Default()
+}
+
+// serverError logs err with the request it belongs to and answers with a
+// generic 500; the details stay out of the response.
+func serverError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	requestLogger(r).Error(message, "method", r.Method, "path", r.URL.Path, "error", err)
+	http.Error(w, message, http.StatusInternalServerError)
+}
+
+// apiServerError is serverError for /api/v1, which answers in JSON.
+func apiServerError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	requestLogger(r).Error(message, "method", r.Method, "path", r.URL.Path, "error", err)
+	writeAPIError(w, http.StatusInternalServerError, message)
+}
+
+// statusRecorder remembers the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// accessLogMiddleware logs one line per request once it has been served. It
+// must run inside requestIDMiddleware.
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"bytes", rec.bytes,
+		}
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			attrs = append(attrs, "route", rctx.RoutePattern())
+		}
+		if info := requestInfoFromContext(r.Context()); info != nil && info.UserID != 0 {
+			attrs = append(attrs, "user_id", info.UserID, "user", info.Email)
+		}
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		requestLogger(r).Log(r.Context(), level, "request", attrs...)
+	})
+}
+
+// fatal logs err and exits; slog has no Fatal level.
+func fatal(message string, err error) {
+	slog.Error(message, "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRequestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(newLogger(&buf, false))
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+	})
+
+	r := chi.NewRouter()
+	r.Use(requestIDMiddleware)
+	r.Use(accessLogMiddleware)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			setRequestUser(r, sessionUser{UserID: 7, Email: "ventas@example.com"})
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Get("/quotes/{id}", func(w http.ResponseWriter, r *http.Request) {
+		serverError(w, r, "failed to load quote", errors.New("database is locked"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/quotes/42", nil)
+	req.Header.Set(requestIDHeader, "proxy-abc.1")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "locked") {
+		t.Fatalf("unexpected response %d %q", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get(requestIDHeader); got != "proxy-abc.1" {
+		t.Fatalf("expected the proxy request id to be kept, got %q", got)
+	}
+
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line is not JSON: %q", line)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected an error and an access log entry, got %v", entries)
+	}
+
+	failure, access := entries[0], entries[1]
+	if failure["msg"] != "failed to load quote" || failure["error"] != "database is locked" || failure["request_id"] != "proxy-abc.1" {
+		t.Fatalf("unexpected error entry %v", failure)
+	}
+	if access["msg"] != "request" || access["level"] != "ERROR" || access["status"] != float64(500) ||
+		access["route"] != "/quotes/{id}" || access["path"] != "/quotes/42" ||
+		access["user"] != "ventas@example.com" || access["request_id"] != "proxy-abc.1" {
+		t.Fatalf("unexpected access entry %v", access)
+	}
+
+	for _, id := range []string{"", "has space", strings.Repeat("a", maxRequestIDLength+1)} {
+		req := httptest.NewRequest(http.MethodGet, "/quotes/1", nil)
+		req.Header.Set(requestIDHeader, id)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if got := rec.Header().Get(requestIDHeader); got == id || !validRequestID(got) {
+			t.Fatalf("expected a generated request id for %q, got %q", id, got)
+		}
+	}
+}
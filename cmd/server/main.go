@@ -1,28 +1,76 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
 	"github.com/Simplici0/o.works/internal/config"
 	"github.com/Simplici0/o.works/internal/db"
+	"github.com/Simplici0/o.works/internal/mail"
 	"github.com/Simplici0/o.works/internal/migrations"
 	"github.com/Simplici0/o.works/internal/pricing"
+	"github.com/Simplici0/o.works/web"
 )
 
 type server struct {
-	auth *authService
-	db   *sql.DB
+	auth          *authService
+	db            *sql.DB
+	loginThrottle *loginThrottle
+	// resetThrottle limits password reset requests per email and IP.
+	resetThrottle *loginThrottle
+	mailer        mail.Sender
+	// baseURL is prefixed to emailed and public quote links; empty uses the request host.
+	baseURL string
+	// requireAdminTOTP blocks admins without a second factor until they enroll.
+	requireAdminTOTP bool
+	webhookClient    *http.Client
+	// webhookWake nudges the webhook worker when a delivery is queued.
+	webhookWake    chan struct{}
+	backupDir      string
+	backupInterval time.Duration
+	backupKeep     int
+	// assets overrides the embedded web files; development reads them from
+	// disk so template edits show up without a rebuild.
+	assets fs.FS
+	// repo overrides the SQL store built from db.
+	repo    store
+	metrics *metrics
+}
+
+// store returns the catalog, quote and user persistence.
+func (s *server) store() store {
+	var st store = newSQLStore(s.db)
+	if s.repo != nil {
+		st = s.repo
+	}
+	if s.metrics != nil {
+		return timedStore{store: st, metrics: s.metrics}
+	}
+	return st
+}
+
+// webFS returns the templates and static files.
+func (s *server) webFS() fs.FS {
+	if s.assets != nil {
+		return s.assets
+	}
+	return web.FS
 }
 
 type baseViewData struct {
@@ -30,18 +78,22 @@ type baseViewData struct {
 	SuccessMessage string
 }
 
+const loginFailedMessage = "Credenciales inválidas o demasiados intentos. Espera un momento e intenta de nuevo."
+
 type loginViewData struct {
 	baseViewData
 }
 
 type rateConfig struct {
-	MachineHourlyRate  float64
-	LaborPerMinute     float64
-	OverheadFixed      float64
-	OverheadPercent    float64
-	FailureRatePercent float64
-	TaxPercent         float64
-	Currency           string
+	MachineHourlyRate  float64 `json:"machine_hourly_rate"`
+	LaborPerMinute     float64 `json:"labor_per_minute"`
+	OverheadFixed      float64 `json:"overhead_fixed"`
+	OverheadPercent    float64 `json:"overhead_percent"`
+	FailureRatePercent float64 `json:"failure_rate_percent"`
+	TaxPercent         float64 `json:"tax_percent"`
+	Currency           string  `json:"currency"`
+	QuoteValidityDays  int     `json:"quote_validity_days"`
+	QuoteTerms         string  `json:"quote_terms"`
 }
 
 type ratesViewData struct {
@@ -50,11 +102,11 @@ type ratesViewData struct {
 }
 
 type material struct {
-	ID        int64
-	Name      string
-	CostPerKg float64
-	Notes     string
-	Active    bool
+	ID        int64   `json:"id"`
+	Name      string  `json:"name"`
+	CostPerKg float64 `json:"cost_per_kg"`
+	Notes     string  `json:"notes"`
+	Active    bool    `json:"active"`
 }
 
 type materialsViewData struct {
@@ -63,13 +115,13 @@ type materialsViewData struct {
 }
 
 type shippingRate struct {
-	ID       int64
-	Scope    string
-	Country  string
-	City     string
-	FlatCost float64
-	Notes    string
-	Active   bool
+	ID       int64   `json:"id"`
+	Scope    string  `json:"scope"`
+	Country  string  `json:"country"`
+	City     string  `json:"city"`
+	FlatCost float64 `json:"flat_cost"`
+	Notes    string  `json:"notes"`
+	Active   bool    `json:"active"`
 }
 
 type shippingViewData struct {
@@ -78,11 +130,11 @@ type shippingViewData struct {
 }
 
 type packagingRate struct {
-	ID       int64
-	Name     string
-	FlatCost float64
-	Notes    string
-	Active   bool
+	ID       int64   `json:"id"`
+	Name     string  `json:"name"`
+	FlatCost float64 `json:"flat_cost"`
+	Notes    string  `json:"notes"`
+	Active   bool    `json:"active"`
 }
 
 type packagingViewData struct {
@@ -90,6 +142,11 @@ type packagingViewData struct {
 	PackagingRates []packagingRate
 }
 
+const (
+	priceModeExclusive = "exclusive"
+	priceModeInclusive = "inclusive"
+)
+
 type quoteFormValues struct {
 	MaterialID    int64
 	ShippingID    int64
@@ -101,12 +158,21 @@ type quoteFormValues struct {
 	WastePercent  float64
 	MarginPercent float64
 	TaxEnabled    bool
-	TaxPercent    float64
+	CustomerType  string
+	PriceMode     string
+	FinalPrice    float64
+	TargetPrice   float64
+	Currency      string
+	CustomerName  string
+	Title         string
+	Notes         string
 }
 
 type quoteBreakdownViewData struct {
 	ErrorMessage string
 	Currency     string
+	PriceMode    string
+	TargetPrice  float64
 	Result       pricing.Result
 }
 
@@ -114,13 +180,68 @@ type quoteViewData struct {
 	Materials      []material
 	ShippingRates  []shippingRate
 	PackagingRates []packagingRate
+	Currencies     []string
 	Form           quoteFormValues
 	Breakdown      quoteBreakdownViewData
 }
 
+type quoteCalculation struct {
+	Values       quoteFormValues
+	Currency     string
+	ExchangeRate float64
+	TaxRules     []pricing.TaxRule
+	Result       pricing.Result
+	ValidUntil   string
+	Terms        string
+}
+
+type storedQuoteItem struct {
+	MaterialName string
+	Grams        float64
+	PrintMinutes float64
+	LaborMinutes float64
+	Quantity     float64
+}
+
+type storedQuote struct {
+	ID            int64
+	CreatedAt     string
+	Title         string
+	Notes         string
+	CustomerName  string
+	ValidUntil    string
+	Terms         string
+	CustomerType  string
+	PriceMode     string
+	FinalPrice    float64
+	Currency      string
+	ExchangeRate  float64
+	WastePercent  float64
+	MarginPercent float64
+	TaxEnabled    bool
+	TaxRules      []pricing.TaxRule
+	Result        pricing.Result
+	Items         []storedQuoteItem
+
+	PublicNonce     string
+	Decision        string
+	DecidedAt       string
+	DecisionIP      string
+	DecisionComment string
+}
+
+type quoteDetailViewData struct {
+	baseViewData
+	Quote      storedQuote
+	Breakdown  quoteBreakdownViewData
+	PublicLink string
+}
+
 type quoteListItem struct {
+	ID        int64
 	CreatedAt string
 	Title     string
+	Currency  string
 	Total     float64
 }
 
@@ -133,59 +254,204 @@ type quotesViewData struct {
 func main() {
 	cfg := config.Load()
 
-	database, err := db.Open(cfg.DBPath)
+	if len(os.Args) > 1 {
+		if err := runCommand(cfg, os.Args[1:], os.Stdin, os.Stdout); err != nil {
+			log.Fatalf("%s: %v", os.Args[1], err)
+		}
+		return
+	}
+
+	slog.SetDefault(newLogger(os.Stderr, cfg.IsDev()))
+	if !cfg.IsDev() && cfg.AppBaseURL == "" {
+		fatal("invalid configuration", errors.New("APP_BASE_URL must be set outside development"))
+	}
+
+	database, err := openDatabase(cfg)
 	if err != nil {
-		log.Fatalf("failed to open database: %v", err)
+		fatal("failed to open database", err)
 	}
 	defer database.Close()
 
-	if cfg.IsDev() {
-		if err := migrations.Up(database, "migrations"); err != nil {
-			log.Fatalf("failed to run database migrations: %v", err)
+	if cfg.MigrateOnStartup {
+		if err := migrations.Up(database); err != nil {
+			fatal("failed to run database migrations", err)
 		}
 	}
 
-	auth := newAuthService(database, cfg.SessionSecret)
+	auth := newAuthService(database, cfg.SessionSecret, sessionPolicy{
+		IdleTimeout:   cfg.SessionIdleTimeout,
+		MaxAge:        cfg.SessionMaxAge,
+		SecureCookies: !cfg.IsDev(),
+	})
 	if err := auth.ensureAdminUser(cfg.AdminEmail, cfg.AdminPassword); err != nil {
-		log.Fatalf("failed to ensure admin user: %v", err)
+		fatal("failed to ensure admin user", err)
 	}
 
-	srv := &server{auth: auth, db: database}
-	if err := srv.ensureRateConfig(); err != nil {
-		log.Fatalf("failed to ensure rate config: %v", err)
+	// Without SMTP, development logs messages and production leaves mailer nil
+	// so admins are shown invite and reset links to share instead.
+	var mailer mail.Sender
+	if cfg.IsDev() {
+		mailer = mail.LogSender{}
+	}
+	if cfg.SMTPHost != "" {
+		mailer = mail.SMTPSender{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.SMTPFrom,
+		}
 	}
 
+	srv := &server{
+		auth:             auth,
+		db:               database,
+		loginThrottle:    newLoginThrottle(),
+		resetThrottle:    newLoginThrottle(),
+		mailer:           mailer,
+		baseURL:          cfg.AppBaseURL,
+		requireAdminTOTP: cfg.RequireAdminTOTP,
+		webhookClient:    &http.Client{Timeout: webhookTimeout},
+		webhookWake:      make(chan struct{}, 1),
+		backupDir:        cfg.BackupDir,
+		backupInterval:   cfg.BackupInterval,
+		backupKeep:       cfg.BackupKeep,
+	}
+	if cfg.MetricsToken != "" {
+		srv.metrics = newMetrics(database)
+	}
+	if cfg.IsPostgres() {
+		// PostgreSQL is backed up with its own tools; see admin_backups.html.
+		srv.backupDir = ""
+	}
+	if cfg.IsDev() {
+		if info, err := os.Stat("web/templates"); err == nil && info.IsDir() {
+			srv.assets = os.DirFS("web")
+		}
+	}
+	if err := srv.store().ensureRateConfig(); err != nil {
+		fatal("failed to ensure rate config", err)
+	}
+	go srv.runWebhookWorker(context.Background())
+	go srv.runBackupScheduler(context.Background())
+
 	r := chi.NewRouter()
+	r.Use(trustedProxyMiddleware(cfg.TrustedProxies))
+	r.Use(requestIDMiddleware)
+	r.Use(accessLogMiddleware)
+	r.Use(srv.metrics.middleware)
+	r.Use(srv.csrfMiddleware)
 	r.Use(srv.authMiddleware)
-	r.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(http.Dir("web/static"))))
+	static, err := fs.Sub(srv.webFS(), "static")
+	if err != nil {
+		fatal("failed to load static files", err)
+	}
+	if srv.metrics != nil {
+		r.Method(http.MethodGet, "/metrics", srv.metrics.handler(cfg.MetricsToken))
+	}
+	r.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(http.FS(static))))
 	r.Get("/", srv.handleHome)
 	r.Get("/login", srv.handleLoginForm)
 	r.Post("/login", srv.handleLoginSubmit)
 	r.Post("/logout", srv.handleLogout)
-	r.Get("/admin/rates", srv.handleAdminRatesForm)
-	r.Post("/admin/rates", srv.handleAdminRatesSubmit)
-	r.Get("/admin/materials", srv.handleAdminMaterialsForm)
-	r.Post("/admin/materials", srv.handleAdminMaterialsCreate)
-	r.Post("/admin/materials/{id}", srv.handleAdminMaterialsUpdate)
-	r.Get("/admin/shipping", srv.handleAdminShippingForm)
-	r.Post("/admin/shipping", srv.handleAdminShippingCreate)
-	r.Post("/admin/shipping/{id}", srv.handleAdminShippingUpdate)
-	r.Get("/admin/packaging", srv.handleAdminPackagingForm)
-	r.Post("/admin/packaging", srv.handleAdminPackagingCreate)
-	r.Post("/admin/packaging/{id}", srv.handleAdminPackagingUpdate)
+	r.Post("/logout/all", srv.handleLogoutAll)
+	r.Get("/login/2fa", srv.handleLoginTwoFactorForm)
+	r.Post("/login/2fa", srv.handleLoginTwoFactorSubmit)
+	r.Get("/password/forgot", srv.handleForgotPasswordForm)
+	r.Post("/password/forgot", srv.handleForgotPasswordSubmit)
+	r.Get("/password/reset/{token}", srv.handleResetPasswordForm)
+	r.Post("/password/reset/{token}", srv.handleResetPasswordSubmit)
+	r.Get("/account/password", srv.handleAccountPasswordForm)
+	r.Post("/account/password", srv.handleAccountPasswordSubmit)
+	r.Get("/account/2fa", srv.handleAccountTwoFactorForm)
+	r.Post("/account/2fa/enable", srv.handleAccountTwoFactorEnable)
+	r.Post("/account/2fa/disable", srv.handleAccountTwoFactorDisable)
+	r.Get("/public/quotes/{id}/{signature}", srv.handlePublicQuote)
+	r.Post("/public/quotes/{id}/{signature}/decision", srv.handlePublicQuoteDecision)
+
+	r.Route("/api/v1", srv.apiRoutes)
+
+	// Every signed-in role can read quotes and use the calculator.
 	r.Get("/quote", srv.handleQuoteForm)
 	r.Post("/quote/calc", srv.handleQuoteCalc)
 	r.Get("/quotes", srv.handleQuotesList)
+	r.Get("/quotes/export.csv", srv.handleQuotesExportCSV)
+	r.Get("/quotes/export.xlsx", srv.handleQuotesExportXLSX)
+	r.Get("/quotes/{id}", srv.handleQuoteDetail)
+	r.Get("/quotes/{id}/pdf", srv.handleQuotePDF)
+
+	r.Group(func(r chi.Router) {
+		r.Use(srv.requireRole(roleAdmin, roleSales))
+		r.Post("/quotes", srv.handleQuoteSave)
+		r.Post("/quotes/{id}/public-link", srv.handleQuotePublicLink)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(srv.requireRole(roleAdmin, roleOperator))
+		r.Get("/admin/materials", srv.handleAdminMaterialsForm)
+		r.Post("/admin/materials", srv.handleAdminMaterialsCreate)
+		r.Get("/admin/materials/export.csv", srv.handleAdminMaterialsExport)
+		r.Post("/admin/materials/import", srv.handleAdminMaterialsImport)
+		r.Post("/admin/materials/{id}", srv.handleAdminMaterialsUpdate)
+		r.Get("/admin/shipping", srv.handleAdminShippingForm)
+		r.Post("/admin/shipping", srv.handleAdminShippingCreate)
+		r.Get("/admin/shipping/export.csv", srv.handleAdminShippingExport)
+		r.Post("/admin/shipping/import", srv.handleAdminShippingImport)
+		r.Post("/admin/shipping/{id}", srv.handleAdminShippingUpdate)
+		r.Get("/admin/packaging", srv.handleAdminPackagingForm)
+		r.Post("/admin/packaging", srv.handleAdminPackagingCreate)
+		r.Get("/admin/packaging/export.csv", srv.handleAdminPackagingExport)
+		r.Post("/admin/packaging/import", srv.handleAdminPackagingImport)
+		r.Post("/admin/packaging/{id}", srv.handleAdminPackagingUpdate)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(srv.requireRole(roleAdmin))
+		r.Get("/admin/rates", srv.handleAdminRatesForm)
+		r.Post("/admin/rates", srv.handleAdminRatesSubmit)
+		r.Get("/admin/taxes", srv.handleAdminTaxesForm)
+		r.Post("/admin/taxes", srv.handleAdminTaxesCreate)
+		r.Post("/admin/taxes/{id}", srv.handleAdminTaxesUpdate)
+		r.Get("/admin/exchange-rates", srv.handleAdminExchangeRatesForm)
+		r.Post("/admin/exchange-rates", srv.handleAdminExchangeRatesCreate)
+		r.Post("/admin/exchange-rates/import", srv.handleAdminExchangeRatesImport)
+		r.Post("/admin/exchange-rates/{id}", srv.handleAdminExchangeRatesUpdate)
+		r.Get("/admin/users", srv.handleAdminUsersForm)
+		r.Post("/admin/users", srv.handleAdminUsersCreate)
+		r.Post("/admin/users/{id}", srv.handleAdminUsersUpdate)
+		r.Post("/admin/users/{id}/reset", srv.handleAdminUsersReset)
+		r.Post("/admin/users/{id}/reset-2fa", srv.handleAdminUsersResetTwoFactor)
+		r.Get("/admin/api-tokens", srv.handleAdminAPITokensForm)
+		r.Post("/admin/api-tokens", srv.handleAdminAPITokensCreate)
+		r.Post("/admin/api-tokens/{id}/revoke", srv.handleAdminAPITokensRevoke)
+		r.Get("/admin/webhooks", srv.handleAdminWebhooksForm)
+		r.Post("/admin/webhooks", srv.handleAdminWebhooksCreate)
+		r.Get("/admin/webhooks/deliveries", srv.handleAdminWebhookDeliveries)
+		r.Post("/admin/webhooks/deliveries/{id}/retry", srv.handleAdminWebhookDeliveryRetry)
+		r.Post("/admin/webhooks/{id}", srv.handleAdminWebhooksUpdate)
+		r.Post("/admin/webhooks/{id}/delete", srv.handleAdminWebhooksDelete)
+		r.Get("/admin/backups", srv.handleAdminBackupsForm)
+		r.Post("/admin/backups", srv.handleAdminBackupsCreate)
+		r.Get("/admin/backups/{name}", srv.handleAdminBackupDownload)
+	})
 
 	addr := ":" + cfg.Port
-	log.Printf("listening on %s", addr)
+	slog.Info("listening", "addr", addr, "env", cfg.AppEnv, "db_driver", cfg.DBDriver)
 	if err := http.ListenAndServe(addr, r); err != nil {
-		log.Fatalf("server stopped: %v", err)
+		fatal("server stopped", err)
+	}
+}
+
+// openDatabase opens the database selected by DB_DRIVER.
+func openDatabase(cfg config.Config) (*sql.DB, error) {
+	if cfg.IsPostgres() {
+		return db.OpenPostgres(cfg.DatabaseURL)
 	}
+	return db.Open(cfg.DBPath)
 }
 
 func (s *server) handleHome(w http.ResponseWriter, r *http.Request) {
-	s.renderTemplate(w, "home.html", nil)
+	s.renderTemplate(w, r, "home.html", nil)
 }
 
 func (s *server) handleLoginForm(w http.ResponseWriter, r *http.Request) {
@@ -193,7 +459,9 @@ func (s *server) handleLoginForm(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-	s.renderTemplate(w, "login.html", loginViewData{})
+	s.renderTemplate(w, r, "login.html", loginViewData{
+		baseViewData: baseViewData{SuccessMessage: r.URL.Query().Get("success")},
+	})
 }
 
 func (s *server) handleLoginSubmit(w http.ResponseWriter, r *http.Request) {
@@ -204,34 +472,88 @@ func (s *server) handleLoginSubmit(w http.ResponseWriter, r *http.Request) {
 
 	email := r.FormValue("email")
 	password := r.FormValue("password")
-	valid, err := s.auth.validateCredentials(email, password)
+	ip := clientIP(r)
+
+	// The same message is shown for unknown emails, wrong passwords and
+	// throttled attempts so the form can't be used to enumerate accounts.
+	if wait, ok := s.loginThrottle.allow(email, ip); !ok {
+		s.metrics.loginFailed(loginFailureThrottled)
+		requestLogger(r).Warn("login throttled", "email", email, "ip", ip, "retry_after", wait.Round(time.Second).String())
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		s.renderTemplate(w, r, "login.html", loginViewData{baseViewData: baseViewData{ErrorMessage: loginFailedMessage}})
+		return
+	}
+
+	userID, valid, err := s.auth.validateCredentials(email, password)
 	if err != nil {
-		http.Error(w, "authentication error", http.StatusInternalServerError)
+		serverError(w, r, "authentication error", err)
 		return
 	}
 	if !valid {
+		s.loginThrottle.recordFailure(email, ip)
+		s.metrics.loginFailed(loginFailureCredentials)
+		requestLogger(r).Warn("login failed", "email", email, "ip", ip)
 		w.WriteHeader(http.StatusUnauthorized)
-		s.renderTemplate(w, "login.html", loginViewData{baseViewData: baseViewData{ErrorMessage: "Credenciales inválidas. Intenta de nuevo."}})
+		s.renderTemplate(w, r, "login.html", loginViewData{baseViewData: baseViewData{ErrorMessage: loginFailedMessage}})
 		return
 	}
 
-	s.auth.setSessionCookie(w, email)
+	hasTOTP, err := s.auth.userHasTOTP(userID)
+	if err != nil {
+		serverError(w, r, "authentication error", err)
+		return
+	}
+	if hasTOTP {
+		s.auth.setPendingLogin(w, userID)
+		http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+		return
+	}
+	s.loginThrottle.recordSuccess(email, ip)
+
+	session, err := s.auth.createSession(userID, r)
+	if err != nil {
+		serverError(w, r, "failed to create session", err)
+		return
+	}
+
+	s.auth.setSessionCookie(w, session)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func (s *server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if user, ok := currentUser(r); ok {
+		if err := s.auth.deleteSession(user.SessionID); err != nil {
+			serverError(w, r, "failed to end session", err)
+			return
+		}
+	}
+
+	s.auth.clearSessionCookie(w)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// handleLogoutAll ends every session of the current user, on all devices.
+func (s *server) handleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	if user, ok := currentUser(r); ok {
+		if err := s.auth.deleteUserSessions(user.UserID); err != nil {
+			serverError(w, r, "failed to end sessions", err)
+			return
+		}
+	}
+
 	s.auth.clearSessionCookie(w)
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
 func (s *server) handleAdminRatesForm(w http.ResponseWriter, r *http.Request) {
-	rates, err := s.getRateConfig()
+	rates, err := s.store().getRateConfig()
 	if err != nil {
-		http.Error(w, "failed to load rate config", http.StatusInternalServerError)
+		serverError(w, r, "failed to load rate config", err)
 		return
 	}
 
-	s.renderTemplate(w, "admin_rates.html", ratesViewData{RateConfig: rates})
+	s.renderTemplate(w, r, "admin_rates.html", ratesViewData{RateConfig: rates})
 }
 
 func (s *server) handleAdminRatesSubmit(w http.ResponseWriter, r *http.Request) {
@@ -243,32 +565,41 @@ func (s *server) handleAdminRatesSubmit(w http.ResponseWriter, r *http.Request)
 	rates, validationErr := parseRateConfigForm(r)
 	if validationErr != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		s.renderTemplate(w, "admin_rates.html", ratesViewData{
+		s.renderTemplate(w, r, "admin_rates.html", ratesViewData{
 			baseViewData: baseViewData{ErrorMessage: validationErr.Error()},
 			RateConfig:   rates,
 		})
 		return
 	}
 
-	if err := s.updateRateConfig(rates); err != nil {
-		http.Error(w, "failed to save rate config", http.StatusInternalServerError)
+	err := s.store().updateRateConfig(rates)
+	if errors.Is(err, errBaseCurrencyInUse) {
+		w.WriteHeader(http.StatusBadRequest)
+		s.renderTemplate(w, r, "admin_rates.html", ratesViewData{
+			baseViewData: baseViewData{ErrorMessage: err.Error()},
+			RateConfig:   rates,
+		})
+		return
+	}
+	if err != nil {
+		serverError(w, r, "failed to save rate config", err)
 		return
 	}
 
-	s.renderTemplate(w, "admin_rates.html", ratesViewData{
+	s.renderTemplate(w, r, "admin_rates.html", ratesViewData{
 		baseViewData: baseViewData{SuccessMessage: "Configuración guardada correctamente."},
 		RateConfig:   rates,
 	})
 }
 
 func (s *server) handleAdminMaterialsForm(w http.ResponseWriter, r *http.Request) {
-	materials, err := s.listMaterials()
+	materials, err := s.store().listMaterials()
 	if err != nil {
-		http.Error(w, "failed to load materials", http.StatusInternalServerError)
+		serverError(w, r, "failed to load materials", err)
 		return
 	}
 
-	s.renderTemplate(w, "admin_materials.html", materialsViewData{
+	s.renderTemplate(w, r, "admin_materials.html", materialsViewData{
 		baseViewData: baseViewData{
 			ErrorMessage:   r.URL.Query().Get("error"),
 			SuccessMessage: r.URL.Query().Get("success"),
@@ -283,25 +614,15 @@ func (s *server) handleAdminMaterialsCreate(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	name := strings.TrimSpace(r.FormValue("name"))
-	notes := strings.TrimSpace(r.FormValue("notes"))
-	if name == "" {
-		http.Redirect(w, r, "/admin/materials?error=name+es+requerido", http.StatusSeeOther)
-		return
-	}
-
-	costPerKg, err := parsePositiveFloat(r.FormValue("cost_per_kg"), "cost_per_kg")
+	m, err := parseMaterialForm(r.FormValue)
 	if err != nil {
 		http.Redirect(w, r, "/admin/materials?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
 		return
 	}
+	m.Active = true
 
-	_, err = s.db.Exec(`
-		INSERT INTO materials (name, cost_per_kg, notes, active)
-		VALUES (?, ?, ?, TRUE)
-	`, name, costPerKg, notes)
-	if err != nil {
-		http.Error(w, "failed to create material", http.StatusInternalServerError)
+	if _, err := s.createMaterial(m); err != nil {
+		serverError(w, r, "failed to create material", err)
 		return
 	}
 
@@ -320,42 +641,18 @@ func (s *server) handleAdminMaterialsUpdate(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	name := strings.TrimSpace(r.FormValue("name"))
-	notes := strings.TrimSpace(r.FormValue("notes"))
-	if name == "" {
-		http.Redirect(w, r, "/admin/materials?error=name+es+requerido", http.StatusSeeOther)
-		return
-	}
-
-	costPerKg, err := parsePositiveFloat(r.FormValue("cost_per_kg"), "cost_per_kg")
+	m, err := parseMaterialForm(r.FormValue)
 	if err != nil {
 		http.Redirect(w, r, "/admin/materials?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
 		return
 	}
 
-	active := r.FormValue("active") == "1"
-
-	result, err := s.db.Exec(`
-		UPDATE materials
-		SET
-			name = ?,
-			cost_per_kg = ?,
-			notes = ?,
-			active = ?,
-			updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?
-	`, name, costPerKg, notes, active, id)
-	if err != nil {
-		http.Error(w, "failed to update material", http.StatusInternalServerError)
-		return
-	}
-
-	affected, err := result.RowsAffected()
+	found, err := s.updateMaterial(id, m)
 	if err != nil {
-		http.Error(w, "failed to update material", http.StatusInternalServerError)
+		serverError(w, r, "failed to update material", err)
 		return
 	}
-	if affected == 0 {
+	if !found {
 		http.NotFound(w, r)
 		return
 	}
@@ -364,13 +661,13 @@ func (s *server) handleAdminMaterialsUpdate(w http.ResponseWriter, r *http.Reque
 }
 
 func (s *server) handleAdminShippingForm(w http.ResponseWriter, r *http.Request) {
-	shippingRates, err := s.listShippingRates()
+	shippingRates, err := s.store().listShippingRates()
 	if err != nil {
-		http.Error(w, "failed to load shipping rates", http.StatusInternalServerError)
+		serverError(w, r, "failed to load shipping rates", err)
 		return
 	}
 
-	s.renderTemplate(w, "admin_shipping.html", shippingViewData{
+	s.renderTemplate(w, r, "admin_shipping.html", shippingViewData{
 		baseViewData: baseViewData{
 			ErrorMessage:   r.URL.Query().Get("error"),
 			SuccessMessage: r.URL.Query().Get("success"),
@@ -385,18 +682,14 @@ func (s *server) handleAdminShippingCreate(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	rate, err := parseShippingRateForm(r)
+	rate, err := parseShippingRateForm(r.FormValue)
 	if err != nil {
 		http.Redirect(w, r, "/admin/shipping?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
 		return
 	}
 
-	_, err = s.db.Exec(`
-		INSERT INTO shipping_rates (scope, country, city, flat_cost, notes, active)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, rate.Scope, rate.Country, rate.City, rate.FlatCost, rate.Notes, rate.Active)
-	if err != nil {
-		http.Error(w, "failed to create shipping rate", http.StatusInternalServerError)
+	if _, err := s.store().createShippingRate(rate); err != nil {
+		serverError(w, r, "failed to create shipping rate", err)
 		return
 	}
 
@@ -415,35 +708,18 @@ func (s *server) handleAdminShippingUpdate(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	rate, err := parseShippingRateForm(r)
+	rate, err := parseShippingRateForm(r.FormValue)
 	if err != nil {
 		http.Redirect(w, r, "/admin/shipping?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
 		return
 	}
 
-	result, err := s.db.Exec(`
-		UPDATE shipping_rates
-		SET
-			scope = ?,
-			country = ?,
-			city = ?,
-			flat_cost = ?,
-			notes = ?,
-			active = ?,
-			updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?
-	`, rate.Scope, rate.Country, rate.City, rate.FlatCost, rate.Notes, rate.Active, id)
-	if err != nil {
-		http.Error(w, "failed to update shipping rate", http.StatusInternalServerError)
-		return
-	}
-
-	affected, err := result.RowsAffected()
+	found, err := s.store().updateShippingRate(id, rate)
 	if err != nil {
-		http.Error(w, "failed to update shipping rate", http.StatusInternalServerError)
+		serverError(w, r, "failed to update shipping rate", err)
 		return
 	}
-	if affected == 0 {
+	if !found {
 		http.NotFound(w, r)
 		return
 	}
@@ -452,13 +728,13 @@ func (s *server) handleAdminShippingUpdate(w http.ResponseWriter, r *http.Reques
 }
 
 func (s *server) handleAdminPackagingForm(w http.ResponseWriter, r *http.Request) {
-	packagingRates, err := s.listPackagingRates()
+	packagingRates, err := s.store().listPackagingRates()
 	if err != nil {
-		http.Error(w, "failed to load packaging rates", http.StatusInternalServerError)
+		serverError(w, r, "failed to load packaging rates", err)
 		return
 	}
 
-	s.renderTemplate(w, "admin_packaging.html", packagingViewData{
+	s.renderTemplate(w, r, "admin_packaging.html", packagingViewData{
 		baseViewData: baseViewData{
 			ErrorMessage:   r.URL.Query().Get("error"),
 			SuccessMessage: r.URL.Query().Get("success"),
@@ -473,18 +749,14 @@ func (s *server) handleAdminPackagingCreate(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	rate, err := parsePackagingRateForm(r)
+	rate, err := parsePackagingRateForm(r.FormValue)
 	if err != nil {
 		http.Redirect(w, r, "/admin/packaging?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
 		return
 	}
 
-	_, err = s.db.Exec(`
-		INSERT INTO packaging_rates (name, flat_cost, notes, active)
-		VALUES (?, ?, ?, ?)
-	`, rate.Name, rate.FlatCost, rate.Notes, rate.Active)
-	if err != nil {
-		http.Error(w, "failed to create packaging rate", http.StatusInternalServerError)
+	if _, err := s.store().createPackagingRate(rate); err != nil {
+		serverError(w, r, "failed to create packaging rate", err)
 		return
 	}
 
@@ -503,33 +775,18 @@ func (s *server) handleAdminPackagingUpdate(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	rate, err := parsePackagingRateForm(r)
+	rate, err := parsePackagingRateForm(r.FormValue)
 	if err != nil {
 		http.Redirect(w, r, "/admin/packaging?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
 		return
 	}
 
-	result, err := s.db.Exec(`
-		UPDATE packaging_rates
-		SET
-			name = ?,
-			flat_cost = ?,
-			notes = ?,
-			active = ?,
-			updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?
-	`, rate.Name, rate.FlatCost, rate.Notes, rate.Active, id)
+	found, err := s.store().updatePackagingRate(id, rate)
 	if err != nil {
-		http.Error(w, "failed to update packaging rate", http.StatusInternalServerError)
+		serverError(w, r, "failed to update packaging rate", err)
 		return
 	}
-
-	affected, err := result.RowsAffected()
-	if err != nil {
-		http.Error(w, "failed to update packaging rate", http.StatusInternalServerError)
-		return
-	}
-	if affected == 0 {
+	if !found {
 		http.NotFound(w, r)
 		return
 	}
@@ -538,82 +795,184 @@ func (s *server) handleAdminPackagingUpdate(w http.ResponseWriter, r *http.Reque
 }
 
 func (s *server) handleQuoteForm(w http.ResponseWriter, r *http.Request) {
-	materials, err := s.listActiveMaterials()
+	s.renderQuotePage(w, r, http.StatusOK, quoteFormValues{Quantity: 1, CustomerType: customerTypeNatural, PriceMode: priceModeExclusive}, quoteBreakdownViewData{
+		ErrorMessage: "Completa los campos para calcular.",
+	})
+}
+
+func (s *server) renderQuotePage(w http.ResponseWriter, r *http.Request, status int, values quoteFormValues, breakdown quoteBreakdownViewData) {
+	materials, err := s.store().listActiveMaterials()
+	if err != nil {
+		serverError(w, r, "failed to load materials", err)
+		return
+	}
+	shippingRates, err := s.store().listActiveShippingRates()
+	if err != nil {
+		serverError(w, r, "failed to load shipping rates", err)
+		return
+	}
+	packagingRates, err := s.store().listActivePackagingRates()
 	if err != nil {
-		http.Error(w, "failed to load materials", http.StatusInternalServerError)
+		serverError(w, r, "failed to load packaging rates", err)
 		return
 	}
-	shippingRates, err := s.listActiveShippingRates()
+	rates, err := s.store().getRateConfig()
 	if err != nil {
-		http.Error(w, "failed to load shipping rates", http.StatusInternalServerError)
+		serverError(w, r, "failed to load rate config", err)
 		return
 	}
-	packagingRates, err := s.listActivePackagingRates()
+	currencies, err := s.listQuoteCurrencies(rates.Currency)
 	if err != nil {
-		http.Error(w, "failed to load packaging rates", http.StatusInternalServerError)
+		serverError(w, r, "failed to load currencies", err)
 		return
 	}
 
-	values := quoteFormValues{Quantity: 1}
-	if len(materials) > 0 {
+	if values.MaterialID == 0 && len(materials) > 0 {
 		values.MaterialID = materials[0].ID
 	}
+	if breakdown.Currency == "" {
+		breakdown.Currency = rates.Currency
+	}
 
-	s.renderTemplate(w, "quote.html", quoteViewData{
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
+	s.renderTemplate(w, r, "quote.html", quoteViewData{
 		Materials:      materials,
 		ShippingRates:  shippingRates,
 		PackagingRates: packagingRates,
+		Currencies:     currencies,
 		Form:           values,
-		Breakdown: quoteBreakdownViewData{
-			ErrorMessage: "Completa los campos para calcular.",
-			Currency:     "COP",
-		},
+		Breakdown:      breakdown,
 	})
 }
 
 func (s *server) handleQuoteCalc(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		s.renderBreakdownPartial(w, quoteBreakdownViewData{ErrorMessage: "Formulario inválido."})
+		s.renderBreakdownPartial(w, r, quoteBreakdownViewData{ErrorMessage: "Formulario inválido."})
 		return
 	}
 
 	values, err := parseQuoteFormValues(r)
 	if err != nil {
-		s.renderBreakdownPartial(w, quoteBreakdownViewData{ErrorMessage: err.Error()})
+		s.renderBreakdownPartial(w, r, quoteBreakdownViewData{ErrorMessage: err.Error()})
 		return
 	}
 
-	rates, err := s.getRateConfig()
+	calc, err := s.calculateQuote(values)
 	if err != nil {
-		s.renderBreakdownPartial(w, quoteBreakdownViewData{ErrorMessage: "No se pudo cargar la configuración de tarifas."})
+		requestLogger(r).Warn("quote calculation failed", "material_id", values.MaterialID, "error", err)
+		s.renderBreakdownPartial(w, r, quoteBreakdownViewData{ErrorMessage: err.Error()})
 		return
 	}
 
-	selectedMaterial, err := s.getActiveMaterialByID(values.MaterialID)
+	s.renderBreakdownPartial(w, r, quoteBreakdownViewData{
+		Currency:    calc.Currency,
+		PriceMode:   calc.Values.PriceMode,
+		TargetPrice: calc.Values.TargetPrice,
+		Result:      calc.Result,
+	})
+}
+
+func (s *server) handleQuoteSave(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	values, err := parseQuoteFormValues(r)
 	if err != nil {
-		s.renderBreakdownPartial(w, quoteBreakdownViewData{ErrorMessage: err.Error()})
+		s.renderQuotePage(w, r, http.StatusBadRequest, values, quoteBreakdownViewData{ErrorMessage: err.Error()})
 		return
 	}
 
-	shippingCost, err := s.getOptionalActiveShippingCost(values.ShippingID)
+	calc, err := s.calculateQuote(values)
 	if err != nil {
-		s.renderBreakdownPartial(w, quoteBreakdownViewData{ErrorMessage: err.Error()})
+		requestLogger(r).Warn("quote calculation failed", "material_id", values.MaterialID, "error", err)
+		s.renderQuotePage(w, r, http.StatusBadRequest, values, quoteBreakdownViewData{ErrorMessage: err.Error()})
 		return
 	}
 
-	packagingCost, err := s.getOptionalActivePackagingCost(values.PackagingID)
+	id, err := s.store().saveQuote(calc)
 	if err != nil {
-		s.renderBreakdownPartial(w, quoteBreakdownViewData{ErrorMessage: err.Error()})
+		serverError(w, r, "failed to save quote", err)
 		return
 	}
+	s.metrics.quoteSaved()
+	s.emitQuoteWebhookEvent(webhookEventQuoteCreated, id)
 
-	result := pricing.Calculate(pricing.ItemInput{
-		Grams:        values.Grams,
-		PrintMinutes: values.PrintMinutes,
-		LaborMinutes: values.LaborMinutes,
-		Quantity:     values.Quantity,
-		CostPerKg:    selectedMaterial.CostPerKg,
-	}, pricing.GlobalInput{
+	http.Redirect(w, r, fmt.Sprintf("/quotes/%d?success=Cotizaci%%C3%%B3n+guardada+correctamente", id), http.StatusSeeOther)
+}
+
+func (s *server) handleQuoteDetail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid quote id", http.StatusBadRequest)
+		return
+	}
+
+	quote, err := s.store().getQuote(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "failed to load quote", err)
+		return
+	}
+
+	s.renderTemplate(w, r, "quote_detail.html", quoteDetailViewData{
+		baseViewData: baseViewData{
+			ErrorMessage:   r.URL.Query().Get("error"),
+			SuccessMessage: r.URL.Query().Get("success"),
+		},
+		Quote: quote,
+		Breakdown: quoteBreakdownViewData{
+			Currency:  quote.Currency,
+			PriceMode: quote.PriceMode,
+			Result:    quote.Result,
+		},
+		PublicLink: s.publicQuoteURL(r, quote),
+	})
+}
+
+// calculateQuote resolves the catalog entries and rates referenced by the form
+// values and runs the pricing calculation. Errors are meant to be shown to the user.
+func (s *server) calculateQuote(values quoteFormValues) (quoteCalculation, error) {
+	rates, err := s.store().getRateConfig()
+	if err != nil {
+		return quoteCalculation{}, fmt.Errorf("No se pudo cargar la configuración de tarifas.")
+	}
+
+	if values.Currency == "" {
+		values.Currency = rates.Currency
+	}
+	exchangeRate, err := s.getExchangeRate(values.Currency, rates.Currency)
+	if err != nil {
+		return quoteCalculation{}, err
+	}
+
+	selectedMaterial, err := s.store().getActiveMaterialByID(values.MaterialID)
+	if err != nil {
+		return quoteCalculation{}, err
+	}
+
+	shippingCost, err := s.store().getOptionalActiveShippingCost(values.ShippingID)
+	if err != nil {
+		return quoteCalculation{}, err
+	}
+
+	packagingCost, err := s.store().getOptionalActivePackagingCost(values.PackagingID)
+	if err != nil {
+		return quoteCalculation{}, err
+	}
+
+	taxRules, err := s.listApplicableTaxRules(values.CustomerType)
+	if err != nil {
+		return quoteCalculation{}, fmt.Errorf("No se pudieron cargar los impuestos.")
+	}
+
+	global := pricing.GlobalInput{
 		MachineHourlyRate:  rates.MachineHourlyRate,
 		LaborPerMinute:     rates.LaborPerMinute,
 		OverheadFixed:      rates.OverheadFixed,
@@ -622,63 +981,80 @@ func (s *server) handleQuoteCalc(w http.ResponseWriter, r *http.Request) {
 		WastePercent:       values.WastePercent,
 		MarginPercent:      values.MarginPercent,
 		TaxEnabled:         values.TaxEnabled,
-		TaxPercent:         values.TaxPercent,
+		TaxPercent:         rates.TaxPercent,
+		TaxRules:           taxRules,
 		PackagingCost:      packagingCost,
 		ShippingCost:       shippingCost,
-	})
+	}
 
-	s.renderBreakdownPartial(w, quoteBreakdownViewData{
-		Currency: rates.Currency,
-		Result:   result,
-	})
+	item := pricing.ItemInput{
+		Grams:        values.Grams,
+		PrintMinutes: values.PrintMinutes,
+		LaborMinutes: values.LaborMinutes,
+		Quantity:     values.Quantity,
+		CostPerKg:    selectedMaterial.CostPerKg,
+	}
+
+	var result pricing.Result
+	if values.PriceMode == priceModeInclusive {
+		result, err = pricing.CalculateTaxInclusive(item, global, values.FinalPrice*exchangeRate)
+		if errors.Is(err, pricing.ErrFinalPriceTooLow) {
+			return quoteCalculation{}, fmt.Errorf("El precio final no cubre empaque y envío.")
+		}
+		if err != nil {
+			return quoteCalculation{}, err
+		}
+		values.MarginPercent = result.Breakdown.MarginPercent
+	} else {
+		if values.TargetPrice > 0 {
+			marginPercent, err := pricing.SolveMargin(item, global, values.TargetPrice*exchangeRate)
+			if errors.Is(err, pricing.ErrTargetBelowCost) {
+				global.MarginPercent = 0
+				minimum := pricing.Calculate(item, global).Totals.Total / exchangeRate
+				return quoteCalculation{}, fmt.Errorf("El precio objetivo está por debajo del costo (mínimo %.2f %s).", minimum, values.Currency)
+			}
+			if err != nil {
+				return quoteCalculation{}, fmt.Errorf("No se puede calcular el margen para el precio objetivo.")
+			}
+			global.MarginPercent = marginPercent
+			values.MarginPercent = marginPercent
+		}
+		result = pricing.Calculate(item, global)
+	}
+
+	converted, err := pricing.Convert(result, exchangeRate)
+	if err != nil {
+		return quoteCalculation{}, fmt.Errorf("La tasa de cambio de %s no es válida.", values.Currency)
+	}
+
+	s.metrics.quoteCalculated()
+	return quoteCalculation{
+		Values:       values,
+		Currency:     values.Currency,
+		ExchangeRate: exchangeRate,
+		TaxRules:     pricing.EffectiveTaxRules(global),
+		Result:       converted,
+		ValidUntil:   time.Now().AddDate(0, 0, rates.QuoteValidityDays).Format(time.DateOnly),
+		Terms:        rates.QuoteTerms,
+	}, nil
 }
 
 func (s *server) handleQuotesList(w http.ResponseWriter, r *http.Request) {
 	query := strings.TrimSpace(r.URL.Query().Get("q"))
-	quotes, err := s.listQuotes(query)
+	quotes, err := s.store().listQuotes(query)
 	if err != nil {
-		http.Error(w, "failed to load quotes", http.StatusInternalServerError)
+		serverError(w, r, "failed to load quotes", err)
 		return
 	}
 
-	s.renderTemplate(w, "quotes.html", quotesViewData{
+	s.renderTemplate(w, r, "quotes.html", quotesViewData{
 		Query:  query,
 		Quotes: quotes,
 	})
 }
 
-func (s *server) listQuotes(query string) ([]quoteListItem, error) {
-	search := "%" + query + "%"
-	rows, err := s.db.Query(`
-		SELECT
-			created_at,
-			COALESCE(title, ''),
-			totals_json
-		FROM quotes
-		WHERE (? = '' OR COALESCE(title, '') LIKE ? OR COALESCE(notes, '') LIKE ?)
-		ORDER BY datetime(created_at) DESC, id DESC
-	`, query, search, search)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	quotes := make([]quoteListItem, 0)
-	for rows.Next() {
-		var item quoteListItem
-		var totalsJSON string
-		if err := rows.Scan(&item.CreatedAt, &item.Title, &totalsJSON); err != nil {
-			return nil, err
-		}
-		item.Total = extractTotalFromJSON(totalsJSON)
-		quotes = append(quotes, item)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-
-	return quotes, nil
+func nullableID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id > 0}
 }
 
 func extractTotalFromJSON(totalsJSON string) float64 {
@@ -697,33 +1073,72 @@ func extractTotalFromJSON(totalsJSON string) float64 {
 }
 
 func parseRateConfigForm(r *http.Request) (rateConfig, error) {
-	rates := rateConfig{Currency: "COP"}
+	rates := rateConfig{Currency: strings.ToUpper(strings.TrimSpace(r.FormValue("currency")))}
 
 	var err error
-	if rates.MachineHourlyRate, err = parseNonNegativeFloat(r.FormValue("machine_hourly_rate"), "machine_hourly_rate"); err != nil {
+	if rates.MachineHourlyRate, err = parseFloat(r.FormValue("machine_hourly_rate"), "machine_hourly_rate"); err != nil {
 		return rates, err
 	}
-	if rates.LaborPerMinute, err = parseNonNegativeFloat(r.FormValue("labor_per_minute"), "labor_per_minute"); err != nil {
+	if rates.LaborPerMinute, err = parseFloat(r.FormValue("labor_per_minute"), "labor_per_minute"); err != nil {
 		return rates, err
 	}
-	if rates.OverheadFixed, err = parseNonNegativeFloat(r.FormValue("overhead_fixed"), "overhead_fixed"); err != nil {
+	if rates.OverheadFixed, err = parseFloat(r.FormValue("overhead_fixed"), "overhead_fixed"); err != nil {
 		return rates, err
 	}
-	if rates.OverheadPercent, err = parsePercent(r.FormValue("overhead_percent"), "overhead_percent"); err != nil {
+	if rates.OverheadPercent, err = parseFloat(r.FormValue("overhead_percent"), "overhead_percent"); err != nil {
 		return rates, err
 	}
-	if rates.FailureRatePercent, err = parsePercent(r.FormValue("failure_rate_percent"), "failure_rate_percent"); err != nil {
+	if rates.FailureRatePercent, err = parseFloat(r.FormValue("failure_rate_percent"), "failure_rate_percent"); err != nil {
 		return rates, err
 	}
-	if rates.TaxPercent, err = parsePercent(r.FormValue("tax_percent"), "tax_percent"); err != nil {
+	if rates.TaxPercent, err = parseFloat(r.FormValue("tax_percent"), "tax_percent"); err != nil {
 		return rates, err
 	}
+	validityDays, err := strconv.Atoi(strings.TrimSpace(r.FormValue("quote_validity_days")))
+	if err != nil {
+		return rates, fmt.Errorf("quote_validity_days debe ser un entero mayor o igual a 0")
+	}
+	rates.QuoteValidityDays = validityDays
+	rates.QuoteTerms = strings.TrimSpace(r.FormValue("quote_terms"))
+
+	return rates, validateRateConfig(rates)
+}
 
-	return rates, nil
+// validateRateConfig checks the rules shared by the rates form and the API.
+func validateRateConfig(rates rateConfig) error {
+	if err := validateNonNegative(rates.MachineHourlyRate, "machine_hourly_rate"); err != nil {
+		return err
+	}
+	if err := validateNonNegative(rates.LaborPerMinute, "labor_per_minute"); err != nil {
+		return err
+	}
+	if err := validateNonNegative(rates.OverheadFixed, "overhead_fixed"); err != nil {
+		return err
+	}
+	if err := validatePercent(rates.OverheadPercent, "overhead_percent"); err != nil {
+		return err
+	}
+	if err := validatePercent(rates.FailureRatePercent, "failure_rate_percent"); err != nil {
+		return err
+	}
+	if err := validatePercent(rates.TaxPercent, "tax_percent"); err != nil {
+		return err
+	}
+	if rates.QuoteValidityDays < 0 {
+		return fmt.Errorf("quote_validity_days debe ser un entero mayor o igual a 0")
+	}
+	if !isCurrencyCode(rates.Currency) {
+		return fmt.Errorf("currency debe ser un código ISO de 3 letras")
+	}
+	return nil
 }
 
 func parseQuoteFormValues(r *http.Request) (quoteFormValues, error) {
-	values := quoteFormValues{}
+	values := quoteFormValues{
+		CustomerName: strings.TrimSpace(r.FormValue("customer_name")),
+		Title:        strings.TrimSpace(r.FormValue("title")),
+		Notes:        strings.TrimSpace(r.FormValue("notes")),
+	}
 
 	var err error
 	if values.MaterialID, err = parseRequiredID(r.FormValue("material_id"), "material_id"); err != nil {
@@ -755,8 +1170,36 @@ func parseQuoteFormValues(r *http.Request) (quoteFormValues, error) {
 	}
 
 	values.TaxEnabled = r.FormValue("taxEnabled") == "1"
-	if values.TaxPercent, err = parsePercent(r.FormValue("taxPercent"), "taxPercent"); err != nil {
-		return values, err
+	values.CustomerType = strings.TrimSpace(r.FormValue("customer_type"))
+	if values.CustomerType == "" {
+		values.CustomerType = customerTypeNatural
+	}
+	if !isValidCustomerType(values.CustomerType) {
+		return values, fmt.Errorf("customer_type inválido")
+	}
+
+	values.Currency = strings.ToUpper(strings.TrimSpace(r.FormValue("currency")))
+	if values.Currency != "" && !isCurrencyCode(values.Currency) {
+		return values, fmt.Errorf("currency inválida")
+	}
+
+	values.PriceMode = strings.TrimSpace(r.FormValue("price_mode"))
+	if values.PriceMode == "" {
+		values.PriceMode = priceModeExclusive
+	}
+	switch values.PriceMode {
+	case priceModeExclusive:
+		if strings.TrimSpace(r.FormValue("targetPrice")) != "" {
+			if values.TargetPrice, err = parseNonNegativeFloat(r.FormValue("targetPrice"), "targetPrice"); err != nil {
+				return values, err
+			}
+		}
+	case priceModeInclusive:
+		if values.FinalPrice, err = parsePositiveFloat(r.FormValue("finalPrice"), "finalPrice"); err != nil {
+			return values, err
+		}
+	default:
+		return values, fmt.Errorf("price_mode inválido")
 	}
 
 	return values, nil
@@ -781,425 +1224,302 @@ func parseOptionalID(raw string) (int64, error) {
 	return value, nil
 }
 
-func parseNonNegativeFloat(raw, field string) (float64, error) {
+func parseFloat(raw, field string) (float64, error) {
 	value, err := strconv.ParseFloat(raw, 64)
 	if err != nil {
 		return 0, fmt.Errorf("%s debe ser numérico", field)
 	}
-	if value < 0 {
-		return 0, fmt.Errorf("%s debe ser mayor o igual a 0", field)
+	return value, nil
+}
+
+func parseNonNegativeFloat(raw, field string) (float64, error) {
+	value, err := parseFloat(raw, field)
+	if err != nil {
+		return 0, err
+	}
+	if err := validateNonNegative(value, field); err != nil {
+		return 0, err
 	}
 	return value, nil
 }
 
 func parsePercent(raw, field string) (float64, error) {
-	value, err := parseNonNegativeFloat(raw, field)
+	value, err := parseFloat(raw, field)
 	if err != nil {
 		return 0, err
 	}
-	if value > 100 {
-		return 0, fmt.Errorf("%s debe estar entre 0 y 100", field)
+	if err := validatePercent(value, field); err != nil {
+		return 0, err
 	}
 	return value, nil
 }
 
 func parsePositiveFloat(raw, field string) (float64, error) {
-	value, err := strconv.ParseFloat(raw, 64)
+	value, err := parseFloat(raw, field)
 	if err != nil {
-		return 0, fmt.Errorf("%s debe ser numérico", field)
+		return 0, err
 	}
-	if value <= 0 {
-		return 0, fmt.Errorf("%s debe ser mayor a 0", field)
+	if err := validatePositive(value, field); err != nil {
+		return 0, err
 	}
 	return value, nil
 }
 
-func parseShippingRateForm(r *http.Request) (shippingRate, error) {
-	rate := shippingRate{
-		Scope:   strings.TrimSpace(r.FormValue("scope")),
-		Country: strings.TrimSpace(r.FormValue("country")),
-		City:    strings.TrimSpace(r.FormValue("city")),
-		Notes:   strings.TrimSpace(r.FormValue("notes")),
-		Active:  r.FormValue("active") == "1",
+func validateNonNegative(value float64, field string) error {
+	if value < 0 {
+		return fmt.Errorf("%s debe ser mayor o igual a 0", field)
 	}
+	return nil
+}
 
-	if rate.Scope != "CO" && rate.Scope != "INTL" {
-		return rate, fmt.Errorf("scope debe ser CO o INTL")
+func validatePercent(value float64, field string) error {
+	if err := validateNonNegative(value, field); err != nil {
+		return err
 	}
-	if rate.Country == "" {
-		return rate, fmt.Errorf("country es requerido")
+	if value > 100 {
+		return fmt.Errorf("%s debe estar entre 0 y 100", field)
+	}
+	return nil
+}
+
+func validatePositive(value float64, field string) error {
+	if value <= 0 {
+		return fmt.Errorf("%s debe ser mayor a 0", field)
+	}
+	return nil
+}
+
+// parseMaterialForm reads a material through value, which returns a field by
+// name: handlers pass r.FormValue and the CSV import passes a row lookup.
+func parseMaterialForm(value func(string) string) (material, error) {
+	m := material{
+		Name:   strings.TrimSpace(value("name")),
+		Notes:  strings.TrimSpace(value("notes")),
+		Active: value("active") == "1",
+	}
+
+	if m.Name == "" {
+		return m, fmt.Errorf("name es requerido")
 	}
 
 	var err error
-	rate.FlatCost, err = parseNonNegativeFloat(r.FormValue("flat_cost"), "flat_cost")
-	if err != nil {
+	if m.CostPerKg, err = parsePositiveFloat(value("cost_per_kg"), "cost_per_kg"); err != nil {
+		return m, err
+	}
+
+	return m, nil
+}
+
+func validateMaterial(m material) error {
+	if m.Name == "" {
+		return fmt.Errorf("name es requerido")
+	}
+	return validatePositive(m.CostPerKg, "cost_per_kg")
+}
+
+func parseShippingRateForm(value func(string) string) (shippingRate, error) {
+	rate := shippingRate{
+		Scope:   strings.TrimSpace(value("scope")),
+		Country: strings.TrimSpace(value("country")),
+		City:    strings.TrimSpace(value("city")),
+		Notes:   strings.TrimSpace(value("notes")),
+		Active:  value("active") == "1",
+	}
+	if err := validateShippingRate(rate); err != nil {
+		return rate, err
+	}
+
+	var err error
+	if rate.FlatCost, err = parseNonNegativeFloat(value("flat_cost"), "flat_cost"); err != nil {
 		return rate, err
 	}
 
 	return rate, nil
 }
 
-func parsePackagingRateForm(r *http.Request) (packagingRate, error) {
-	rate := packagingRate{
-		Name:   strings.TrimSpace(r.FormValue("name")),
-		Notes:  strings.TrimSpace(r.FormValue("notes")),
-		Active: r.FormValue("active") == "1",
+func validateShippingRate(rate shippingRate) error {
+	if rate.Scope != "CO" && rate.Scope != "INTL" {
+		return fmt.Errorf("scope debe ser CO o INTL")
 	}
+	if rate.Country == "" {
+		return fmt.Errorf("country es requerido")
+	}
+	return validateNonNegative(rate.FlatCost, "flat_cost")
+}
 
-	if rate.Name == "" {
-		return rate, fmt.Errorf("name es requerido")
+func parsePackagingRateForm(value func(string) string) (packagingRate, error) {
+	rate := packagingRate{
+		Name:   strings.TrimSpace(value("name")),
+		Notes:  strings.TrimSpace(value("notes")),
+		Active: value("active") == "1",
+	}
+	if err := validatePackagingRate(rate); err != nil {
+		return rate, err
 	}
 
 	var err error
-	rate.FlatCost, err = parseNonNegativeFloat(r.FormValue("flat_cost"), "flat_cost")
-	if err != nil {
+	if rate.FlatCost, err = parseNonNegativeFloat(value("flat_cost"), "flat_cost"); err != nil {
 		return rate, err
 	}
 
 	return rate, nil
 }
 
-func (s *server) renderTemplate(w http.ResponseWriter, page string, data any) {
-	templates, err := template.ParseFiles(
-		"web/templates/layout.html",
-		"web/templates/"+page,
+func validatePackagingRate(rate packagingRate) error {
+	if rate.Name == "" {
+		return fmt.Errorf("name es requerido")
+	}
+	return validateNonNegative(rate.FlatCost, "flat_cost")
+}
+
+func (s *server) renderTemplate(w http.ResponseWriter, r *http.Request, page string, data any) {
+	templates, err := template.New("layout.html").Funcs(s.templateFuncs(r)).ParseFS(s.webFS(),
+		"templates/layout.html",
+		"templates/quote_breakdown_partial.html",
+		"templates/"+page,
 	)
 	if err != nil {
-		http.Error(w, "failed to parse template", http.StatusInternalServerError)
+		serverError(w, r, "failed to parse template", err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := templates.ExecuteTemplate(w, "layout.html", data); err != nil {
-		http.Error(w, "failed to render template", http.StatusInternalServerError)
+		serverError(w, r, "failed to render template", err)
 		return
 	}
 }
 
-func (s *server) renderBreakdownPartial(w http.ResponseWriter, data quoteBreakdownViewData) {
-	tmpl, err := template.ParseFiles("web/templates/quote_breakdown_partial.html")
+func (s *server) renderBreakdownPartial(w http.ResponseWriter, r *http.Request, data quoteBreakdownViewData) {
+	tmpl, err := template.ParseFS(s.webFS(), "templates/quote_breakdown_partial.html")
 	if err != nil {
-		http.Error(w, "failed to parse template", http.StatusInternalServerError)
+		serverError(w, r, "failed to parse template", err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := tmpl.Execute(w, data); err != nil {
-		http.Error(w, "failed to render template", http.StatusInternalServerError)
+	if err := tmpl.ExecuteTemplate(w, "quote_breakdown", data); err != nil {
+		serverError(w, r, "failed to render template", err)
 		return
 	}
 }
 
 func (s *server) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/login" || r.URL.Path == "/static" || strings.HasPrefix(r.URL.Path, "/static/") {
+		// /metrics checks its own bearer token.
+		if r.URL.Path == "/login" || r.URL.Path == "/login/2fa" || r.URL.Path == "/metrics" || r.URL.Path == "/static" || strings.HasPrefix(r.URL.Path, "/static/") || strings.HasPrefix(r.URL.Path, "/public/") || strings.HasPrefix(r.URL.Path, "/password/") {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		if !isAuthenticated(r, s.auth) {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+		if token, ok := bearerToken(r); ok && isAPIRequest(r) {
+			user, ok, err := s.auth.lookupAPIToken(token)
+			if err != nil {
+				apiServerError(w, r, "authentication error", err)
+				return
+			}
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				writeAPIError(w, http.StatusUnauthorized, "invalid or expired token")
+				return
+			}
+			if s.missingRequiredTOTP(user) {
+				writeAPIError(w, http.StatusForbidden, "two-factor authentication must be enabled")
+				return
+			}
+
+			setRequestUser(r, user)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionUserContextKey{}, user)))
 			return
 		}
 
-		next.ServeHTTP(w, r)
-	})
-}
-
-func isAuthenticated(r *http.Request, auth *authService) bool {
-	cookie, err := r.Cookie(sessionCookieName)
-	if err != nil {
-		return false
-	}
-
-	_, ok := auth.verifySessionValue(cookie.Value)
-	return ok
-}
-
-func (s *server) ensureRateConfig() error {
-	_, err := s.db.Exec(`
-		INSERT INTO rate_config (
-			id,
-			machine_hourly_rate,
-			labor_per_minute,
-			overhead_fixed,
-			overhead_percent,
-			failure_rate_percent,
-			tax_percent,
-			currency
-		) VALUES (1, 0, 0, 0, 0, 0, 0, 'COP')
-		ON CONFLICT(id) DO NOTHING
-	`)
-	if err != nil {
-		return fmt.Errorf("insert default rate_config: %w", err)
-	}
-	return nil
-}
-
-func (s *server) getRateConfig() (rateConfig, error) {
-	if err := s.ensureRateConfig(); err != nil {
-		return rateConfig{}, err
-	}
-
-	var rc rateConfig
-	err := s.db.QueryRow(`
-		SELECT machine_hourly_rate, labor_per_minute, overhead_fixed, overhead_percent, failure_rate_percent, tax_percent, currency
-		FROM rate_config
-		WHERE id = 1
-	`).Scan(
-		&rc.MachineHourlyRate,
-		&rc.LaborPerMinute,
-		&rc.OverheadFixed,
-		&rc.OverheadPercent,
-		&rc.FailureRatePercent,
-		&rc.TaxPercent,
-		&rc.Currency,
-	)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return rateConfig{}, fmt.Errorf("rate_config singleton not found")
+		user, ok, err := authenticatedUser(r, s.auth)
+		if err != nil {
+			serverError(w, r, "authentication error", err)
+			return
 		}
-		return rateConfig{}, fmt.Errorf("query rate_config: %w", err)
-	}
-	return rc, nil
-}
-
-func (s *server) updateRateConfig(rc rateConfig) error {
-	_, err := s.db.Exec(`
-		UPDATE rate_config
-		SET
-			machine_hourly_rate = ?,
-			labor_per_minute = ?,
-			overhead_fixed = ?,
-			overhead_percent = ?,
-			failure_rate_percent = ?,
-			tax_percent = ?,
-			currency = 'COP',
-			updated_at = CURRENT_TIMESTAMP
-		WHERE id = 1
-	`,
-		rc.MachineHourlyRate,
-		rc.LaborPerMinute,
-		rc.OverheadFixed,
-		rc.OverheadPercent,
-		rc.FailureRatePercent,
-		rc.TaxPercent,
-	)
-	if err != nil {
-		return fmt.Errorf("update rate_config: %w", err)
-	}
-
-	return nil
-}
-
-func (s *server) listMaterials() ([]material, error) {
-	rows, err := s.db.Query(`
-		SELECT id, name, cost_per_kg, COALESCE(notes, ''), active
-		FROM materials
-		ORDER BY id DESC
-	`)
-	if err != nil {
-		return nil, fmt.Errorf("query materials: %w", err)
-	}
-	defer rows.Close()
-
-	materials := make([]material, 0)
-	for rows.Next() {
-		var m material
-		if err := rows.Scan(&m.ID, &m.Name, &m.CostPerKg, &m.Notes, &m.Active); err != nil {
-			return nil, fmt.Errorf("scan material: %w", err)
+		if !ok {
+			s.auth.clearSessionCookie(w)
+			if isAPIRequest(r) {
+				writeAPIError(w, http.StatusUnauthorized, "authentication required")
+				return
+			}
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
 		}
-		materials = append(materials, m)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("iterate materials: %w", err)
-	}
-
-	return materials, nil
-}
-
-func (s *server) listActiveMaterials() ([]material, error) {
-	rows, err := s.db.Query(`
-		SELECT id, name, cost_per_kg, COALESCE(notes, ''), active
-		FROM materials
-		WHERE active = TRUE
-		ORDER BY name ASC
-	`)
-	if err != nil {
-		return nil, fmt.Errorf("query active materials: %w", err)
-	}
-	defer rows.Close()
 
-	materials := make([]material, 0)
-	for rows.Next() {
-		var m material
-		if err := rows.Scan(&m.ID, &m.Name, &m.CostPerKg, &m.Notes, &m.Active); err != nil {
-			return nil, fmt.Errorf("scan active material: %w", err)
+		if s.missingRequiredTOTP(user) && !isTwoFactorExempt(r.URL.Path) {
+			if isAPIRequest(r) {
+				writeAPIError(w, http.StatusForbidden, "two-factor authentication must be enabled")
+				return
+			}
+			http.Redirect(w, r, "/account/2fa?error="+url.QueryEscape("Los administradores deben activar la autenticación de dos factores"), http.StatusSeeOther)
+			return
 		}
-		materials = append(materials, m)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("iterate active materials: %w", err)
-	}
 
-	return materials, nil
+		setRequestUser(r, user)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionUserContextKey{}, user)))
+	})
 }
 
-func (s *server) getActiveMaterialByID(id int64) (material, error) {
-	var m material
-	err := s.db.QueryRow(`
-		SELECT id, name, cost_per_kg, COALESCE(notes, ''), active
-		FROM materials
-		WHERE id = ? AND active = TRUE
-	`, id).Scan(&m.ID, &m.Name, &m.CostPerKg, &m.Notes, &m.Active)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return material{}, fmt.Errorf("material no encontrado o inactivo")
-		}
-		return material{}, fmt.Errorf("query material: %w", err)
-	}
-	return m, nil
+// missingRequiredTOTP reports whether user is an admin without a second factor
+// while REQUIRE_ADMIN_2FA is on. It applies to sessions and API tokens alike.
+func (s *server) missingRequiredTOTP(user sessionUser) bool {
+	return s.requireAdminTOTP && user.Role == roleAdmin && !user.TOTPEnabled
 }
 
-func (s *server) listShippingRates() ([]shippingRate, error) {
-	rows, err := s.db.Query(`
-		SELECT id, scope, country, COALESCE(city, ''), flat_cost, COALESCE(notes, ''), active
-		FROM shipping_rates
-		ORDER BY id DESC
-	`)
-	if err != nil {
-		return nil, fmt.Errorf("query shipping rates: %w", err)
-	}
-	defer rows.Close()
+type sessionUserContextKey struct{}
 
-	shippingRates := make([]shippingRate, 0)
-	for rows.Next() {
-		var rate shippingRate
-		if err := rows.Scan(&rate.ID, &rate.Scope, &rate.Country, &rate.City, &rate.FlatCost, &rate.Notes, &rate.Active); err != nil {
-			return nil, fmt.Errorf("scan shipping rate: %w", err)
-		}
-		shippingRates = append(shippingRates, rate)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("iterate shipping rates: %w", err)
-	}
-
-	return shippingRates, nil
+func isAuthenticated(r *http.Request, auth *authService) bool {
+	_, ok, err := authenticatedUser(r, auth)
+	return err == nil && ok
 }
 
-func (s *server) listActiveShippingRates() ([]shippingRate, error) {
-	rows, err := s.db.Query(`
-		SELECT id, scope, country, COALESCE(city, ''), flat_cost, COALESCE(notes, ''), active
-		FROM shipping_rates
-		WHERE active = TRUE
-		ORDER BY id DESC
-	`)
+func authenticatedUser(r *http.Request, auth *authService) (sessionUser, bool, error) {
+	cookie, err := r.Cookie(sessionCookieName)
 	if err != nil {
-		return nil, fmt.Errorf("query active shipping rates: %w", err)
+		return sessionUser{}, false, nil
 	}
-	defer rows.Close()
 
-	shippingRates := make([]shippingRate, 0)
-	for rows.Next() {
-		var rate shippingRate
-		if err := rows.Scan(&rate.ID, &rate.Scope, &rate.Country, &rate.City, &rate.FlatCost, &rate.Notes, &rate.Active); err != nil {
-			return nil, fmt.Errorf("scan active shipping rate: %w", err)
-		}
-		shippingRates = append(shippingRates, rate)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("iterate active shipping rates: %w", err)
-	}
-
-	return shippingRates, nil
+	return auth.lookupSession(cookie.Value)
 }
 
-func (s *server) getOptionalActiveShippingCost(id int64) (float64, error) {
-	if id == 0 {
-		return 0, nil
-	}
-
-	var cost float64
-	err := s.db.QueryRow(`SELECT flat_cost FROM shipping_rates WHERE id = ? AND active = TRUE`, id).Scan(&cost)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return 0, fmt.Errorf("shipping no encontrado o inactivo")
-		}
-		return 0, fmt.Errorf("query shipping: %w", err)
-	}
-
-	return cost, nil
+// currentUser returns the user stored in the request context by authMiddleware.
+func currentUser(r *http.Request) (sessionUser, bool) {
+	user, ok := r.Context().Value(sessionUserContextKey{}).(sessionUser)
+	return user, ok
 }
 
-func (s *server) listPackagingRates() ([]packagingRate, error) {
-	rows, err := s.db.Query(`
-		SELECT id, name, flat_cost, COALESCE(notes, ''), active
-		FROM packaging_rates
-		ORDER BY id DESC
-	`)
+func (s *server) createMaterial(m material) (int64, error) {
+	id, err := s.store().createMaterial(m)
 	if err != nil {
-		return nil, fmt.Errorf("query packaging rates: %w", err)
-	}
-	defer rows.Close()
-
-	packagingRates := make([]packagingRate, 0)
-	for rows.Next() {
-		var rate packagingRate
-		if err := rows.Scan(&rate.ID, &rate.Name, &rate.FlatCost, &rate.Notes, &rate.Active); err != nil {
-			return nil, fmt.Errorf("scan packaging rate: %w", err)
-		}
-		packagingRates = append(packagingRates, rate)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("iterate packaging rates: %w", err)
+		return 0, err
 	}
 
-	return packagingRates, nil
+	m.ID = id
+	s.announceMaterialCreated(m)
+	return id, nil
 }
 
-func (s *server) listActivePackagingRates() ([]packagingRate, error) {
-	rows, err := s.db.Query(`
-		SELECT id, name, flat_cost, COALESCE(notes, ''), active
-		FROM packaging_rates
-		WHERE active = TRUE
-		ORDER BY id DESC
-	`)
-	if err != nil {
-		return nil, fmt.Errorf("query active packaging rates: %w", err)
-	}
-	defer rows.Close()
-
-	packagingRates := make([]packagingRate, 0)
-	for rows.Next() {
-		var rate packagingRate
-		if err := rows.Scan(&rate.ID, &rate.Name, &rate.FlatCost, &rate.Notes, &rate.Active); err != nil {
-			return nil, fmt.Errorf("scan active packaging rate: %w", err)
-		}
-		packagingRates = append(packagingRates, rate)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("iterate active packaging rates: %w", err)
+// updateMaterial reports false when no material has the given id. A changed
+// cost_per_kg is announced to webhook subscribers.
+func (s *server) updateMaterial(id int64, m material) (bool, error) {
+	previousCost, found, err := s.store().updateMaterial(id, m)
+	if err != nil || !found {
+		return found, err
 	}
 
-	return packagingRates, nil
+	m.ID = id
+	s.announceMaterialUpdated(m, previousCost)
+	return true, nil
 }
 
-func (s *server) getOptionalActivePackagingCost(id int64) (float64, error) {
-	if id == 0 {
-		return 0, nil
-	}
+func (s *server) announceMaterialCreated(m material) {
+	s.emitWebhookEvent(webhookEventMaterialCreated, materialWebhookData{material: m})
+}
 
-	var cost float64
-	err := s.db.QueryRow(`SELECT flat_cost FROM packaging_rates WHERE id = ? AND active = TRUE`, id).Scan(&cost)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return 0, fmt.Errorf("packaging no encontrado o inactivo")
-		}
-		return 0, fmt.Errorf("query packaging: %w", err)
+func (s *server) announceMaterialUpdated(m material, previousCost float64) {
+	if m.CostPerKg != previousCost {
+		s.emitWebhookEvent(webhookEventMaterialPriceChanged, materialWebhookData{material: m, PreviousCostPerKg: &previousCost})
 	}
-
-	return cost, nil
 }
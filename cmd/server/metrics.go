@@ -0,0 +1,363 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	loginFailureCredentials = "credentials"
+	loginFailureSecondStep  = "second_factor"
+	loginFailureThrottled   = "throttled"
+)
+
+// metrics holds the Prometheus collectors served on /metrics. A nil
+// *metrics records nothing, so tests and commands can leave it unset.
+type metrics struct {
+	registry         *prometheus.Registry
+	httpRequests     *prometheus.CounterVec
+	httpDuration     *prometheus.HistogramVec
+	dbQueryDuration  *prometheus.HistogramVec
+	quotesCalculated prometheus.Counter
+	quotesSaved      prometheus.Counter
+	loginFailures    *prometheus.CounterVec
+}
+
+func newMetrics(database *sql.DB) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "oworks_http_requests_total",
+			Help: "HTTP requests by method, chi route pattern and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "oworks_http_request_duration_seconds",
+			Help:    "HTTP request latency by method and chi route pattern.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		dbQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "oworks_db_query_duration_seconds",
+			Help:    "Duration of store operations, including their transactions.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"operation"}),
+		quotesCalculated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "oworks_quotes_calculated_total",
+			Help: "Successful quote calculations from the calculator, saves and the API.",
+		}),
+		quotesSaved: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "oworks_quotes_saved_total",
+			Help: "Quotes saved from the web form.",
+		}),
+		loginFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "oworks_login_failures_total",
+			Help: "Rejected sign-in attempts by reason: credentials, second_factor or throttled.",
+		}, []string{"reason"}),
+	}
+
+	m.registry.MustRegister(
+		m.httpRequests,
+		m.httpDuration,
+		m.dbQueryDuration,
+		m.quotesCalculated,
+		m.quotesSaved,
+		m.loginFailures,
+		collectors.NewDBStatsCollector(database, "oworks"),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	for _, reason := range []string{loginFailureCredentials, loginFailureSecondStep, loginFailureThrottled} {
+		m.loginFailures.WithLabelValues(reason)
+	}
+
+	return m
+}
+
+// middleware counts and times requests by route pattern rather than path,
+// which keeps ids out of the label values.
+func (m *metrics) middleware(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		m.httpDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// handler serves the registry to requests carrying the bearer token.
+func (m *metrics) handler(token string) http.Handler {
+	metricsHandler := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := bearerToken(r)
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		metricsHandler.ServeHTTP(w, r)
+	})
+}
+
+func (m *metrics) observeQuery(operation string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.dbQueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+func (m *metrics) quoteCalculated() {
+	if m != nil {
+		m.quotesCalculated.Inc()
+	}
+}
+
+func (m *metrics) quoteSaved() {
+	if m != nil {
+		m.quotesSaved.Inc()
+	}
+}
+
+func (m *metrics) loginFailed(reason string) {
+	if m != nil {
+		m.loginFailures.WithLabelValues(reason).Inc()
+	}
+}
+
+// timedStore records how long each store operation takes.
+type timedStore struct {
+	store
+	metrics *metrics
+}
+
+// withTx times the operations inside the transaction, not the transaction
+// as a whole.
+func (t timedStore) withTx(fn func(st store) error) error {
+	return t.store.withTx(func(st store) error {
+		return fn(timedStore{store: st, metrics: t.metrics})
+	})
+}
+
+func (t timedStore) ensureRateConfig() error {
+	defer t.metrics.observeQuery("ensureRateConfig", time.Now())
+	return t.store.ensureRateConfig()
+}
+
+func (t timedStore) getRateConfig() (rateConfig, error) {
+	defer t.metrics.observeQuery("getRateConfig", time.Now())
+	return t.store.getRateConfig()
+}
+
+func (t timedStore) updateRateConfig(rc rateConfig) error {
+	defer t.metrics.observeQuery("updateRateConfig", time.Now())
+	return t.store.updateRateConfig(rc)
+}
+
+func (t timedStore) listMaterials() ([]material, error) {
+	defer t.metrics.observeQuery("listMaterials", time.Now())
+	return t.store.listMaterials()
+}
+
+func (t timedStore) listActiveMaterials() ([]material, error) {
+	defer t.metrics.observeQuery("listActiveMaterials", time.Now())
+	return t.store.listActiveMaterials()
+}
+
+func (t timedStore) getActiveMaterialByID(id int64) (material, error) {
+	defer t.metrics.observeQuery("getActiveMaterialByID", time.Now())
+	return t.store.getActiveMaterialByID(id)
+}
+
+func (t timedStore) createMaterial(m material) (int64, error) {
+	defer t.metrics.observeQuery("createMaterial", time.Now())
+	return t.store.createMaterial(m)
+}
+
+func (t timedStore) updateMaterial(id int64, m material) (float64, bool, error) {
+	defer t.metrics.observeQuery("updateMaterial", time.Now())
+	return t.store.updateMaterial(id, m)
+}
+
+func (t timedStore) listShippingRates() ([]shippingRate, error) {
+	defer t.metrics.observeQuery("listShippingRates", time.Now())
+	return t.store.listShippingRates()
+}
+
+func (t timedStore) listActiveShippingRates() ([]shippingRate, error) {
+	defer t.metrics.observeQuery("listActiveShippingRates", time.Now())
+	return t.store.listActiveShippingRates()
+}
+
+func (t timedStore) getOptionalActiveShippingCost(id int64) (float64, error) {
+	defer t.metrics.observeQuery("getOptionalActiveShippingCost", time.Now())
+	return t.store.getOptionalActiveShippingCost(id)
+}
+
+func (t timedStore) createShippingRate(rate shippingRate) (int64, error) {
+	defer t.metrics.observeQuery("createShippingRate", time.Now())
+	return t.store.createShippingRate(rate)
+}
+
+func (t timedStore) updateShippingRate(id int64, rate shippingRate) (bool, error) {
+	defer t.metrics.observeQuery("updateShippingRate", time.Now())
+	return t.store.updateShippingRate(id, rate)
+}
+
+func (t timedStore) listPackagingRates() ([]packagingRate, error) {
+	defer t.metrics.observeQuery("listPackagingRates", time.Now())
+	return t.store.listPackagingRates()
+}
+
+func (t timedStore) listActivePackagingRates() ([]packagingRate, error) {
+	defer t.metrics.observeQuery("listActivePackagingRates", time.Now())
+	return t.store.listActivePackagingRates()
+}
+
+func (t timedStore) getOptionalActivePackagingCost(id int64) (float64, error) {
+	defer t.metrics.observeQuery("getOptionalActivePackagingCost", time.Now())
+	return t.store.getOptionalActivePackagingCost(id)
+}
+
+func (t timedStore) createPackagingRate(rate packagingRate) (int64, error) {
+	defer t.metrics.observeQuery("createPackagingRate", time.Now())
+	return t.store.createPackagingRate(rate)
+}
+
+func (t timedStore) updatePackagingRate(id int64, rate packagingRate) (bool, error) {
+	defer t.metrics.observeQuery("updatePackagingRate", time.Now())
+	return t.store.updatePackagingRate(id, rate)
+}
+
+func (t timedStore) saveQuote(calc quoteCalculation) (int64, error) {
+	defer t.metrics.observeQuery("saveQuote", time.Now())
+	return t.store.saveQuote(calc)
+}
+
+func (t timedStore) getQuote(id int64) (storedQuote, error) {
+	defer t.metrics.observeQuery("getQuote", time.Now())
+	return t.store.getQuote(id)
+}
+
+func (t timedStore) getQuoteFormValues(id int64) (quoteFormValues, error) {
+	defer t.metrics.observeQuery("getQuoteFormValues", time.Now())
+	return t.store.getQuoteFormValues(id)
+}
+
+func (t timedStore) listQuotes(query string) ([]quoteListItem, error) {
+	defer t.metrics.observeQuery("listQuotes", time.Now())
+	return t.store.listQuotes(query)
+}
+
+func (t timedStore) listQuoteExportRows(query string) ([]quoteExportRow, error) {
+	defer t.metrics.observeQuery("listQuoteExportRows", time.Now())
+	return t.store.listQuoteExportRows(query)
+}
+
+func (t timedStore) setQuotePublicNonce(id int64, nonce string) (bool, error) {
+	defer t.metrics.observeQuery("setQuotePublicNonce", time.Now())
+	return t.store.setQuotePublicNonce(id, nonce)
+}
+
+func (t timedStore) recordQuoteDecision(id int64, decision, ip, comment string) error {
+	defer t.metrics.observeQuery("recordQuoteDecision", time.Now())
+	return t.store.recordQuoteDecision(id, decision, ip, comment)
+}
+
+func (t timedStore) listUsers() ([]user, error) {
+	defer t.metrics.observeQuery("listUsers", time.Now())
+	return t.store.listUsers()
+}
+
+func (t timedStore) userExists(email string) (bool, error) {
+	defer t.metrics.observeQuery("userExists", time.Now())
+	return t.store.userExists(email)
+}
+
+func (t timedStore) createUser(email, passwordHash, role string) (int64, error) {
+	defer t.metrics.observeQuery("createUser", time.Now())
+	return t.store.createUser(email, passwordHash, role)
+}
+
+func (t timedStore) updateUserAccess(id int64, role string, active bool) (bool, error) {
+	defer t.metrics.observeQuery("updateUserAccess", time.Now())
+	return t.store.updateUserAccess(id, role, active)
+}
+
+func (t timedStore) getUserEmail(id int64) (string, error) {
+	defer t.metrics.observeQuery("getUserEmail", time.Now())
+	return t.store.getUserEmail(id)
+}
+
+func (t timedStore) getUserIDByEmail(email string) (int64, error) {
+	defer t.metrics.observeQuery("getUserIDByEmail", time.Now())
+	return t.store.getUserIDByEmail(email)
+}
+
+func (t timedStore) getActiveUserCredentials(email string) (int64, string, error) {
+	defer t.metrics.observeQuery("getActiveUserCredentials", time.Now())
+	return t.store.getActiveUserCredentials(email)
+}
+
+func (t timedStore) getActiveUserIDByEmail(email string) (int64, error) {
+	defer t.metrics.observeQuery("getActiveUserIDByEmail", time.Now())
+	return t.store.getActiveUserIDByEmail(email)
+}
+
+func (t timedStore) getUserActive(id int64) (bool, error) {
+	defer t.metrics.observeQuery("getUserActive", time.Now())
+	return t.store.getUserActive(id)
+}
+
+func (t timedStore) updateUserPasswordHash(id int64, passwordHash string) error {
+	defer t.metrics.observeQuery("updateUserPasswordHash", time.Now())
+	return t.store.updateUserPasswordHash(id, passwordHash)
+}
+
+func (t timedStore) resetPasswordWithToken(tokenID, userID int64, passwordHash string) error {
+	defer t.metrics.observeQuery("resetPasswordWithToken", time.Now())
+	return t.store.resetPasswordWithToken(tokenID, userID, passwordHash)
+}
+
+func (t timedStore) getUserTOTP(id int64) (totpState, error) {
+	defer t.metrics.observeQuery("getUserTOTP", time.Now())
+	return t.store.getUserTOTP(id)
+}
+
+func (t timedStore) setUserTOTPSecret(id int64, secret string) error {
+	defer t.metrics.observeQuery("setUserTOTPSecret", time.Now())
+	return t.store.setUserTOTPSecret(id, secret)
+}
+
+func (t timedStore) enableUserTOTP(id, counter int64, recoveryCodeHashes []string) error {
+	defer t.metrics.observeQuery("enableUserTOTP", time.Now())
+	return t.store.enableUserTOTP(id, counter, recoveryCodeHashes)
+}
+
+func (t timedStore) resetUserTOTP(id int64) error {
+	defer t.metrics.observeQuery("resetUserTOTP", time.Now())
+	return t.store.resetUserTOTP(id)
+}
+
+func (t timedStore) advanceUserTOTPCounter(id, counter int64) (bool, error) {
+	defer t.metrics.observeQuery("advanceUserTOTPCounter", time.Now())
+	return t.store.advanceUserTOTPCounter(id, counter)
+}
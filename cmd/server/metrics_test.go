@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	database := newMigratedTestDB(t)
+	srv := &server{db: database, metrics: newMetrics(database)}
+
+	r := chi.NewRouter()
+	r.Use(srv.metrics.middleware)
+	r.Method(http.MethodGet, "/metrics", srv.metrics.handler("scrape-token"))
+	r.Get("/admin/materials/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := srv.store().listMaterials(); err != nil {
+			t.Errorf("listMaterials returned error: %v", err)
+		}
+		http.NotFound(w, r)
+	})
+
+	for _, path := range []string{"/admin/materials/1", "/admin/materials/2"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	srv.metrics.loginFailed(loginFailureCredentials)
+	srv.metrics.quoteCalculated()
+
+	for _, auth := range []string{"", "Bearer wrong"} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 for %q, got %d", auth, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer scrape-token")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`oworks_http_requests_total{method="GET",route="/admin/materials/{id}",status="404"} 2`,
+		`oworks_http_request_duration_seconds_count{method="GET",route="/admin/materials/{id}"} 2`,
+		`oworks_db_query_duration_seconds_count{operation="listMaterials"} 2`,
+		`oworks_login_failures_total{reason="credentials"} 1`,
+		`oworks_login_failures_total{reason="throttled"} 0`,
+		`oworks_quotes_calculated_total 1`,
+		`oworks_quotes_saved_total 0`,
+		`go_sql_open_connections{db_name="oworks"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output is missing %s", want)
+		}
+	}
+}
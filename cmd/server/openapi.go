@@ -0,0 +1,260 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Simplici0/o.works/internal/pricing"
+)
+
+// apiOperation describes one /api/v1 route for the OpenAPI document. Request
+// and Response are zero values of the body types; their schemas are derived
+// from the json tags, so they follow the Go types automatically.
+type apiOperation struct {
+	Method  string
+	Path    string
+	Summary string
+	// Description adds request details the schema cannot express, such as
+	// optional fields and their defaults.
+	Description string
+	Request     any
+	Response    any
+	Status      int
+	// Roles lists who may call the operation; empty means any signed-in user.
+	Roles []string
+}
+
+// apiOperations must list every route mounted by apiRoutes; TestOpenAPIMatchesRoutes
+// fails when they diverge.
+var apiOperations = []apiOperation{
+	{Method: http.MethodGet, Path: "/openapi.json", Summary: "This OpenAPI document", Response: map[string]any{}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/materials", Summary: "List materials", Response: []material{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/materials", Summary: "Create a material", Request: material{}, Response: material{}, Status: http.StatusCreated, Roles: []string{roleAdmin, roleOperator}},
+	{Method: http.MethodPut, Path: "/materials/{id}", Summary: "Replace a material", Request: material{}, Response: material{}, Status: http.StatusOK, Roles: []string{roleAdmin, roleOperator}},
+	{Method: http.MethodGet, Path: "/shipping-rates", Summary: "List shipping rates", Response: []shippingRate{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/shipping-rates", Summary: "Create a shipping rate", Request: shippingRate{}, Response: shippingRate{}, Status: http.StatusCreated, Roles: []string{roleAdmin, roleOperator}},
+	{Method: http.MethodPut, Path: "/shipping-rates/{id}", Summary: "Replace a shipping rate", Request: shippingRate{}, Response: shippingRate{}, Status: http.StatusOK, Roles: []string{roleAdmin, roleOperator}},
+	{Method: http.MethodGet, Path: "/packaging-rates", Summary: "List packaging rates", Response: []packagingRate{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/packaging-rates", Summary: "Create a packaging rate", Request: packagingRate{}, Response: packagingRate{}, Status: http.StatusCreated, Roles: []string{roleAdmin, roleOperator}},
+	{Method: http.MethodPut, Path: "/packaging-rates/{id}", Summary: "Replace a packaging rate", Request: packagingRate{}, Response: packagingRate{}, Status: http.StatusOK, Roles: []string{roleAdmin, roleOperator}},
+	{Method: http.MethodGet, Path: "/rate-config", Summary: "Get the global rates", Response: rateConfig{}, Status: http.StatusOK},
+	{Method: http.MethodPut, Path: "/rate-config", Summary: "Replace the global rates", Description: "currency is optional and keeps the stored base currency when omitted. It can only change while no materials, shipping rates, packaging rates or exchange rates exist.", Request: rateConfig{}, Response: rateConfig{}, Status: http.StatusOK, Roles: []string{roleAdmin}},
+	{Method: http.MethodPost, Path: "/quotes/calculate", Summary: "Price a job from raw inputs", Request: calculateRequest{}, Response: pricing.Result{}, Status: http.StatusOK},
+}
+
+// openAPIEnums lists the allowed values of string types that are enums.
+var openAPIEnums = map[reflect.Type][]string{
+	reflect.TypeOf(pricing.TaxBase("")): {string(pricing.TaxBaseNet), string(pricing.TaxBaseTax)},
+}
+
+var openAPIDocument = sync.OnceValue(buildOpenAPIDocument)
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+func (s *server) handleAPIOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPIDocument())
+}
+
+func buildOpenAPIDocument() map[string]any {
+	schemas := map[string]any{}
+	errorSchema := openAPISchema(reflect.TypeOf(apiError{}), schemas)
+
+	paths := map[string]any{}
+	for _, op := range apiOperations {
+		operation := map[string]any{
+			"summary":     op.Summary,
+			"operationId": openAPIOperationID(op),
+		}
+		var description []string
+		if op.Description != "" {
+			description = append(description, op.Description)
+		}
+		if len(op.Roles) > 0 {
+			description = append(description, "Requires one of the roles: "+strings.Join(op.Roles, ", ")+". API tokens need the read_write scope.")
+		}
+		if description != nil {
+			operation["description"] = strings.Join(description, " ")
+		}
+
+		var params []any
+		for _, match := range pathParamPattern.FindAllStringSubmatch(op.Path, -1) {
+			params = append(params, map[string]any{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "integer", "format": "int64"},
+			})
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+
+		if op.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": openAPISchema(reflect.TypeOf(op.Request), schemas)},
+				},
+			}
+		}
+
+		errorResponse := func(description string) map[string]any {
+			return map[string]any{
+				"description": description,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": errorSchema},
+				},
+			}
+		}
+		responses := map[string]any{
+			strconv.Itoa(op.Status): map[string]any{
+				"description": http.StatusText(op.Status),
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": openAPISchema(reflect.TypeOf(op.Response), schemas)},
+				},
+			},
+			"401": errorResponse("Missing, invalid or expired credentials"),
+		}
+		if op.Request != nil {
+			responses["400"] = errorResponse("Malformed JSON or unknown fields")
+			responses["422"] = errorResponse("Validation failed")
+		}
+		if len(op.Roles) > 0 {
+			responses["403"] = errorResponse("Role or token scope not allowed")
+		}
+		if params != nil {
+			responses["404"] = errorResponse("Not found")
+		}
+		operation["responses"] = responses
+
+		item, _ := paths[op.Path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "o.works API",
+			"version":     "1.0.0",
+			"description": "Catalog and pricing endpoints of the o.works quoting tool. Money amounts are in the base currency of rate-config.",
+		},
+		"servers": []any{map[string]any{"url": "/api/v1"}},
+		"security": []any{
+			map[string]any{"bearerAuth": []any{}},
+			map[string]any{"sessionCookie": []any{}},
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "API token created in /admin/api-tokens.",
+				},
+				"sessionCookie": map[string]any{
+					"type":        "apiKey",
+					"in":          "cookie",
+					"name":        sessionCookieName,
+					"description": "Browser session. Unsafe methods also need the X-CSRF-Token header.",
+				},
+			},
+		},
+	}
+}
+
+// openAPISchema returns the schema for t. Named structs are added to schemas
+// and referenced, so shared types like TaxRule appear once.
+func openAPISchema(t reflect.Type, schemas map[string]any) map[string]any {
+	if values, ok := openAPIEnums[t]; ok {
+		return map[string]any{"type": "string", "enum": values}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": openAPISchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object"}
+	case reflect.Struct:
+		name := openAPISchemaName(t)
+		ref := map[string]any{"$ref": "#/components/schemas/" + name}
+		if _, ok := schemas[name]; ok {
+			return ref
+		}
+		// Reserve the name first so recursive types terminate.
+		schemas[name] = nil
+
+		properties := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			fieldName, ok := jsonFieldName(field)
+			if !ok {
+				continue
+			}
+			property := openAPISchema(field.Type, schemas)
+			if fieldName == "id" {
+				property["readOnly"] = true
+			}
+			properties[fieldName] = property
+		}
+		schemas[name] = map[string]any{"type": "object", "properties": properties}
+		return ref
+	default:
+		panic("openapi: unsupported type " + t.String())
+	}
+}
+
+// openAPISchemaName exports the Go type name, e.g. shippingRate -> ShippingRate.
+func openAPISchemaName(t reflect.Type) string {
+	name := t.Name()
+	if name == "apiError" {
+		return "Error"
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, true
+}
+
+func openAPIOperationID(op apiOperation) string {
+	parts := []string{strings.ToLower(op.Method)}
+	for _, segment := range strings.FieldsFunc(op.Path, func(r rune) bool { return r == '/' || r == '-' || r == '.' }) {
+		if strings.HasPrefix(segment, "{") {
+			segment = "by " + strings.Trim(segment, "{}")
+		}
+		for _, word := range strings.Fields(segment) {
+			parts = append(parts, strings.ToUpper(word[:1])+word[1:])
+		}
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Simplici0/o.works/internal/pricing"
+)
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	r := chi.NewRouter()
+	r.Route("/api/v1", (&server{}).apiRoutes)
+
+	var routes []string
+	err := chi.Walk(r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routes = append(routes, method+" "+strings.TrimPrefix(route, "/api/v1"))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk routes: %v", err)
+	}
+
+	var documented []string
+	paths := openAPIDocument()["paths"].(map[string]any)
+	for path, item := range paths {
+		for method := range item.(map[string]any) {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	sort.Strings(routes)
+	sort.Strings(documented)
+	if !reflect.DeepEqual(routes, documented) {
+		t.Fatalf("routes and OpenAPI document diverge\nroutes:     %v\ndocumented: %v", routes, documented)
+	}
+}
+
+func TestOpenAPISchemasMatchJSON(t *testing.T) {
+	schemas := openAPIDocument()["components"].(map[string]any)["schemas"].(map[string]any)
+
+	values := map[string]any{
+		"ItemInput":        pricing.ItemInput{},
+		"GlobalInput":      pricing.GlobalInput{TaxRules: []pricing.TaxRule{}},
+		"Result":           pricing.Result{},
+		"Breakdown":        pricing.Breakdown{},
+		"Totals":           pricing.Totals{},
+		"TaxRule":          pricing.TaxRule{},
+		"TaxLine":          pricing.TaxLine{},
+		"CalculateRequest": calculateRequest{},
+		"Material":         material{},
+		"ShippingRate":     shippingRate{},
+		"PackagingRate":    packagingRate{},
+		"RateConfig":       rateConfig{},
+		"Error":            apiError{},
+	}
+	if len(values) != len(schemas) {
+		t.Fatalf("expected %d schemas, got %d", len(values), len(schemas))
+	}
+
+	for name, value := range values {
+		schema, ok := schemas[name].(map[string]any)
+		if !ok {
+			t.Fatalf("missing schema %s", name)
+		}
+		properties := schema["properties"].(map[string]any)
+
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			t.Fatalf("marshal %s: %v", name, err)
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(encoded, &fields); err != nil {
+			t.Fatalf("unmarshal %s: %v", name, err)
+		}
+
+		if len(fields) != len(properties) {
+			t.Fatalf("%s: JSON has %d fields, schema has %d properties", name, len(fields), len(properties))
+		}
+		for field := range fields {
+			if _, ok := properties[field]; !ok {
+				t.Fatalf("%s: field %q missing from schema", name, field)
+			}
+		}
+	}
+}
+
+func TestOpenAPIEndpoint(t *testing.T) {
+	_, handler := newAPITestRouter(t, roleViewer)
+
+	rec := serveAPI(t, handler, http.MethodGet, "/api/v1/openapi.json", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+
+	var doc struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode document: %v", err)
+	}
+	if doc.OpenAPI != "3.0.3" || doc.Paths["/quotes/calculate"]["post"] == nil {
+		t.Fatalf("unexpected document: %+v", doc)
+	}
+}
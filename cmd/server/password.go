@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+// argon2Params are the cost parameters for new hashes. They follow the second
+// recommended option of RFC 9106 with a smaller memory footprint.
+type argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  int
+	KeyLength   uint32
+}
+
+var defaultArgon2Params = argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Stored hashes with costs above these limits are rejected rather than
+// computed, so a tampered password_hash row cannot exhaust memory or CPU.
+const (
+	maxArgon2Memory     = 256 * 1024 // KiB, i.e. 256 MiB
+	maxArgon2Iterations = 16
+)
+
+var errInvalidPasswordHash = errors.New("invalid password hash")
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash returns an argon2id hash with the current parameters that
+// no user owns, for checks against unknown accounts.
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = hashPassword("dummy password for unknown users")
+	})
+	return dummyHash
+}
+
+// hashPassword returns an argon2id hash in PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+func hashPassword(password string) (string, error) {
+	p := defaultArgon2Params
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("read password salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		p.Memory,
+		p.Iterations,
+		p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// verifyPassword checks a password against a stored hash. needsRehash is true
+// when the stored value is a legacy SHA-256 or plaintext entry, or an argon2id
+// hash made with parameters other than the current defaults.
+func verifyPassword(storedHash, password string) (ok bool, needsRehash bool, err error) {
+	if !strings.HasPrefix(storedHash, argon2idPrefix) {
+		return verifyLegacyPassword(storedHash, password), true, nil
+	}
+
+	p, salt, key, err := decodeArgon2Hash(storedHash)
+	if err != nil {
+		return false, false, err
+	}
+
+	provided := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(provided, key) != 1 {
+		return false, false, nil
+	}
+
+	current := defaultArgon2Params
+	outdated := p.Memory != current.Memory ||
+		p.Iterations != current.Iterations ||
+		p.Parallelism != current.Parallelism ||
+		len(salt) != current.SaltLength ||
+		uint32(len(key)) != current.KeyLength
+	return true, outdated, nil
+}
+
+// verifyLegacyPassword accepts the unsalted SHA-256 hex hashes and plaintext
+// values stored before argon2id. They are only ever read to upgrade them.
+func verifyLegacyPassword(storedHash, password string) bool {
+	sum := sha256.Sum256([]byte(password))
+	if subtle.ConstantTimeCompare([]byte(storedHash), []byte(hex.EncodeToString(sum[:]))) == 1 {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(storedHash), []byte(password)) == 1
+}
+
+func decodeArgon2Hash(encoded string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, errInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errInvalidPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, errInvalidPasswordHash
+	}
+	if p.Memory == 0 || p.Memory > maxArgon2Memory ||
+		p.Iterations == 0 || p.Iterations > maxArgon2Iterations ||
+		p.Parallelism == 0 {
+		return p, nil, nil, errInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) == 0 {
+		return p, nil, nil, errInvalidPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, errInvalidPasswordHash
+	}
+
+	p.SaltLength = len(salt)
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
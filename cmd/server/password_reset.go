@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Simplici0/o.works/internal/mail"
+)
+
+const (
+	passwordTokenReset  = "reset"
+	passwordTokenInvite = "invite"
+
+	resetTokenTTL  = time.Hour
+	inviteTokenTTL = 72 * time.Hour
+
+	minPasswordLength = 10
+	mailSendTimeout   = 15 * time.Second
+)
+
+var errPasswordTokenUsed = errors.New("password token already used")
+
+var (
+	errNoBaseURL = errors.New("APP_BASE_URL is not set")
+	errNoMailer  = errors.New("SMTP_HOST is not set")
+)
+
+type passwordToken struct {
+	ID      int64
+	UserID  int64
+	Email   string
+	Purpose string
+}
+
+type accountPasswordViewData struct {
+	baseViewData
+}
+
+type forgotPasswordViewData struct {
+	baseViewData
+}
+
+type resetPasswordViewData struct {
+	baseViewData
+	Email   string
+	Purpose string
+}
+
+func (s *server) handleAccountPasswordForm(w http.ResponseWriter, r *http.Request) {
+	s.renderTemplate(w, r, "account_password.html", accountPasswordViewData{
+		baseViewData: baseViewData{
+			ErrorMessage:   r.URL.Query().Get("error"),
+			SuccessMessage: r.URL.Query().Get("success"),
+		},
+	})
+}
+
+// handleAccountPasswordSubmit changes the signed-in user's password and ends
+// their other sessions.
+func (s *server) handleAccountPasswordSubmit(w http.ResponseWriter, r *http.Request) {
+	current, _ := currentUser(r)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	ip := clientIP(r)
+	if _, ok := s.loginThrottle.allow(current.Email, ip); !ok {
+		http.Redirect(w, r, "/account/password?error="+url.QueryEscape(loginFailedMessage), http.StatusSeeOther)
+		return
+	}
+
+	_, valid, err := s.auth.validateCredentials(current.Email, r.FormValue("current_password"))
+	if err != nil {
+		serverError(w, r, "failed to change password", err)
+		return
+	}
+	if !valid {
+		s.loginThrottle.recordFailure(current.Email, ip)
+		http.Redirect(w, r, "/account/password?error="+url.QueryEscape("La contraseña actual no es correcta"), http.StatusSeeOther)
+		return
+	}
+
+	password := r.FormValue("password")
+	if err := validateNewPassword(password, r.FormValue("password_confirm")); err != nil {
+		http.Redirect(w, r, "/account/password?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	if err := s.auth.setPassword(current.UserID, password); err != nil {
+		serverError(w, r, "failed to change password", err)
+		return
+	}
+	if _, err := s.db.Exec(`DELETE FROM sessions WHERE user_id = ? AND id <> ?`, current.UserID, current.SessionID); err != nil {
+		serverError(w, r, "failed to change password", err)
+		return
+	}
+
+	http.Redirect(w, r, "/account/password?success="+url.QueryEscape("Contraseña actualizada. Se cerraron tus otras sesiones."), http.StatusSeeOther)
+}
+
+func (s *server) handleForgotPasswordForm(w http.ResponseWriter, r *http.Request) {
+	s.renderTemplate(w, r, "password_forgot.html", forgotPasswordViewData{})
+}
+
+// handleForgotPasswordSubmit always answers the same way so the form can't be
+// used to find out which emails have an account.
+func (s *server) handleForgotPasswordSubmit(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	ip := clientIP(r)
+	if _, ok := s.resetThrottle.allow(email, ip); ok {
+		// Every request counts against the throttle so the form can't be used
+		// to flood an inbox.
+		s.resetThrottle.recordFailure(email, ip)
+
+		userID, err := s.store().getActiveUserIDByEmail(email)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			requestLogger(r).Info("password reset requested for unknown email", "email", email, "ip", ip)
+		case err != nil:
+			serverError(w, r, "failed to request password reset", err)
+			return
+		default:
+			if err := s.checkPasswordEmail(); err != nil {
+				requestLogger(r).Error("password reset email not sent", "user_id", userID, "error", err)
+				break
+			}
+			link, err := s.createPasswordLink(r, userID, passwordTokenReset)
+			if err != nil {
+				serverError(w, r, "failed to request password reset", err)
+				return
+			}
+			if err := s.sendPasswordLink(r, email, passwordTokenReset, link); err != nil {
+				requestLogger(r).Error("send password reset email", "user_id", userID, "error", err)
+			}
+		}
+	}
+
+	s.renderTemplate(w, r, "password_forgot.html", forgotPasswordViewData{
+		baseViewData: baseViewData{SuccessMessage: "Si el correo tiene una cuenta activa, te enviamos un enlace para restablecer la contraseña."},
+	})
+}
+
+func (s *server) handleResetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	token, ok, err := s.auth.lookupPasswordToken(chi.URLParam(r, "token"))
+	if err != nil {
+		serverError(w, r, "failed to load reset link", err)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		s.renderTemplate(w, r, "password_reset.html", resetPasswordViewData{baseViewData: baseViewData{ErrorMessage: "El enlace no es válido o ya expiró. Solicita uno nuevo."}})
+		return
+	}
+
+	s.renderTemplate(w, r, "password_reset.html", resetPasswordViewData{Email: token.Email, Purpose: token.Purpose})
+}
+
+func (s *server) handleResetPasswordSubmit(w http.ResponseWriter, r *http.Request) {
+	token, ok, err := s.auth.lookupPasswordToken(chi.URLParam(r, "token"))
+	if err != nil {
+		serverError(w, r, "failed to load reset link", err)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		s.renderTemplate(w, r, "password_reset.html", resetPasswordViewData{baseViewData: baseViewData{ErrorMessage: "El enlace no es válido o ya expiró. Solicita uno nuevo."}})
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	password := r.FormValue("password")
+	if err := validateNewPassword(password, r.FormValue("password_confirm")); err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		s.renderTemplate(w, r, "password_reset.html", resetPasswordViewData{
+			baseViewData: baseViewData{ErrorMessage: err.Error()},
+			Email:        token.Email,
+			Purpose:      token.Purpose,
+		})
+		return
+	}
+
+	err = s.auth.consumePasswordToken(token, password)
+	if errors.Is(err, errPasswordTokenUsed) {
+		w.WriteHeader(http.StatusNotFound)
+		s.renderTemplate(w, r, "password_reset.html", resetPasswordViewData{baseViewData: baseViewData{ErrorMessage: "El enlace no es válido o ya expiró. Solicita uno nuevo."}})
+		return
+	}
+	if err != nil {
+		serverError(w, r, "failed to reset password", err)
+		return
+	}
+
+	http.Redirect(w, r, "/login?success="+url.QueryEscape("Contraseña guardada. Ya puedes iniciar sesión."), http.StatusSeeOther)
+}
+
+// checkPasswordEmail reports why password links can't be emailed. Without
+// APP_BASE_URL the link would point at whatever Host header the request sent,
+// which on the forgot-password form is attacker controlled.
+func (s *server) checkPasswordEmail() error {
+	if s.baseURL == "" {
+		return errNoBaseURL
+	}
+	if s.mailer == nil {
+		return errNoMailer
+	}
+	return nil
+}
+
+// sendPasswordLink emails a reset or invite link created by createPasswordLink.
+func (s *server) sendPasswordLink(r *http.Request, email, purpose, link string) error {
+	if err := s.checkPasswordEmail(); err != nil {
+		return err
+	}
+
+	msg := mail.Message{To: email}
+	switch purpose {
+	case passwordTokenInvite:
+		msg.Subject = "Invitación a o.works"
+		msg.Body = fmt.Sprintf("Hola,\n\nTe invitaron al cotizador de o.works. Crea tu contraseña en este enlace (válido por %d horas):\n\n%s\n\nSi no esperabas esta invitación, ignora este correo.\n", int(inviteTokenTTL.Hours()), link)
+	default:
+		msg.Subject = "Restablece tu contraseña de o.works"
+		msg.Body = fmt.Sprintf("Hola,\n\nRecibimos una solicitud para restablecer tu contraseña. Usa este enlace (válido por %d minutos):\n\n%s\n\nSi no fuiste tú, ignora este correo; tu contraseña no cambia.\n", int(resetTokenTTL.Minutes()), link)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), mailSendTimeout)
+	defer cancel()
+	return s.mailer.Send(ctx, msg)
+}
+
+func (s *server) createPasswordLink(r *http.Request, userID int64, purpose string) (string, error) {
+	ttl := resetTokenTTL
+	if purpose == passwordTokenInvite {
+		ttl = inviteTokenTTL
+	}
+
+	token, err := s.auth.createPasswordToken(userID, purpose, ttl)
+	if err != nil {
+		return "", err
+	}
+
+	return s.absoluteURL(r, "/password/reset/"+token), nil
+}
+
+// absoluteURL prefixes path with APP_BASE_URL, or with the request's own
+// origin when no base URL is configured. Links built from the request are only
+// fit to show to the signed-in user; sendPasswordLink refuses to email them.
+func (s *server) absoluteURL(r *http.Request, path string) string {
+	if s.baseURL != "" {
+		return s.baseURL + path
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + path
+}
+
+// createPasswordToken replaces any unused token of the same purpose for the
+// user and returns the new raw token. Only its hash is stored.
+func (a *authService) createPasswordToken(userID int64, purpose string, ttl time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("read password token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("begin password token: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM password_tokens WHERE user_id = ? AND purpose = ? AND used_at IS NULL`, userID, purpose); err != nil {
+		return "", fmt.Errorf("delete previous password tokens: %w", err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO password_tokens (user_id, token_hash, purpose, expires_at)
+		VALUES (?, ?, ?, ?)
+	`, userID, hashSessionToken(token), purpose, sqliteTime(time.Now().Add(ttl)))
+	if err != nil {
+		return "", fmt.Errorf("insert password token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("commit password token: %w", err)
+	}
+
+	return token, nil
+}
+
+func (a *authService) lookupPasswordToken(token string) (passwordToken, bool, error) {
+	if token == "" {
+		return passwordToken{}, false, nil
+	}
+
+	var t passwordToken
+	err := a.db.QueryRow(`
+		SELECT pt.id, pt.user_id, u.email, pt.purpose
+		FROM password_tokens pt
+		JOIN users u ON u.id = pt.user_id
+		WHERE pt.token_hash = ?
+			AND pt.used_at IS NULL
+			AND pt.expires_at > ?
+			AND u.active = TRUE
+	`, hashSessionToken(token), sqliteTime(time.Now())).Scan(&t.ID, &t.UserID, &t.Email, &t.Purpose)
+	if errors.Is(err, sql.ErrNoRows) {
+		return passwordToken{}, false, nil
+	}
+	if err != nil {
+		return passwordToken{}, false, fmt.Errorf("query password token: %w", err)
+	}
+
+	return t, true, nil
+}
+
+// consumePasswordToken sets the new password, marks the token used and ends
+// every session of the user, all in one transaction.
+func (a *authService) consumePasswordToken(token passwordToken, password string) error {
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return a.users().resetPasswordWithToken(token.ID, token.UserID, passwordHash)
+}
+
+func validateNewPassword(password, confirm string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return fmt.Errorf("la contraseña debe tener al menos %d caracteres", minPasswordLength)
+	}
+	if password != confirm {
+		return fmt.Errorf("las contraseñas no coinciden")
+	}
+	return nil
+}
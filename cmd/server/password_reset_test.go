@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Simplici0/o.works/internal/mail"
+)
+
+type captureSender struct {
+	messages []mail.Message
+	err      error
+}
+
+func (c *captureSender) Send(_ context.Context, msg mail.Message) error {
+	c.messages = append(c.messages, msg)
+	return c.err
+}
+
+func TestPasswordTokenLifecycle(t *testing.T) {
+	database := newMigratedTestDB(t)
+	auth, userID := newSessionTestAuth(t, database)
+
+	first, err := auth.createPasswordToken(userID, passwordTokenReset, time.Hour)
+	if err != nil {
+		t.Fatalf("createPasswordToken returned error: %v", err)
+	}
+	token, err := auth.createPasswordToken(userID, passwordTokenReset, time.Hour)
+	if err != nil {
+		t.Fatalf("createPasswordToken returned error: %v", err)
+	}
+	if _, ok, err := auth.lookupPasswordToken(first); err != nil || ok {
+		t.Fatalf("expected a new token to replace the previous one, got %v, %v", ok, err)
+	}
+	if _, ok, err := auth.lookupPasswordToken("wrong"); err != nil || ok {
+		t.Fatalf("expected unknown token to be rejected, got %v, %v", ok, err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, err := auth.createSession(userID, req); err != nil {
+		t.Fatalf("createSession returned error: %v", err)
+	}
+
+	found, ok, err := auth.lookupPasswordToken(token)
+	if err != nil || !ok {
+		t.Fatalf("expected token to be valid, got %v, %v", ok, err)
+	}
+	if found.UserID != userID || found.Email != "admin@x.com" || found.Purpose != passwordTokenReset {
+		t.Fatalf("unexpected token: %+v", found)
+	}
+
+	if err := auth.consumePasswordToken(found, "new-password-123"); err != nil {
+		t.Fatalf("consumePasswordToken returned error: %v", err)
+	}
+	if err := auth.consumePasswordToken(found, "another-password"); !errors.Is(err, errPasswordTokenUsed) {
+		t.Fatalf("expected errPasswordTokenUsed, got %v", err)
+	}
+	if _, ok, err := auth.lookupPasswordToken(token); err != nil || ok {
+		t.Fatalf("expected used token to be rejected, got %v, %v", ok, err)
+	}
+
+	if _, valid, err := auth.validateCredentials("admin@x.com", "new-password-123"); err != nil || !valid {
+		t.Fatalf("expected new password to work, got %v, %v", valid, err)
+	}
+	var sessions int
+	if err := database.QueryRow(`SELECT COUNT(*) FROM sessions WHERE user_id = ?`, userID).Scan(&sessions); err != nil {
+		t.Fatalf("count sessions: %v", err)
+	}
+	if sessions != 0 {
+		t.Fatalf("expected sessions to be deleted, got %d", sessions)
+	}
+
+	expired, err := auth.createPasswordToken(userID, passwordTokenInvite, -time.Minute)
+	if err != nil {
+		t.Fatalf("createPasswordToken returned error: %v", err)
+	}
+	if _, ok, err := auth.lookupPasswordToken(expired); err != nil || ok {
+		t.Fatalf("expected expired token to be rejected, got %v, %v", ok, err)
+	}
+}
+
+func TestSendPasswordLink(t *testing.T) {
+	database := newMigratedTestDB(t)
+	auth, userID := newSessionTestAuth(t, database)
+	sender := &captureSender{}
+	srv := &server{auth: auth, db: database, mailer: sender, baseURL: "https://cotiza.example.com"}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/users", nil)
+	link, err := srv.createPasswordLink(req, userID, passwordTokenInvite)
+	if err != nil {
+		t.Fatalf("createPasswordLink returned error: %v", err)
+	}
+	if !strings.HasPrefix(link, "https://cotiza.example.com/password/reset/") {
+		t.Fatalf("unexpected link %q", link)
+	}
+
+	if err := srv.sendPasswordLink(req, "admin@x.com", passwordTokenInvite, link); err != nil {
+		t.Fatalf("sendPasswordLink returned error: %v", err)
+	}
+	if len(sender.messages) != 1 {
+		t.Fatalf("expected one message, got %d", len(sender.messages))
+	}
+	msg := sender.messages[0]
+	if msg.To != "admin@x.com" || !strings.Contains(msg.Subject, "Invitación") || !strings.Contains(msg.Body, link) {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+
+	token := strings.TrimPrefix(link, "https://cotiza.example.com/password/reset/")
+	found, ok, err := auth.lookupPasswordToken(token)
+	if err != nil || !ok || found.Purpose != passwordTokenInvite {
+		t.Fatalf("expected emailed token to be valid, got %+v, %v, %v", found, ok, err)
+	}
+}
+
+func TestForgotPasswordEmailsOnlyBaseURLLinks(t *testing.T) {
+	database := newMigratedTestDB(t)
+	auth, _ := newSessionTestAuth(t, database)
+	sender := &captureSender{}
+	srv := &server{auth: auth, db: database, mailer: sender, resetThrottle: newLoginThrottle()}
+
+	submit := func() {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/password/forgot", strings.NewReader(url.Values{"email": {"admin@x.com"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Host = "evil.example"
+		rec := httptest.NewRecorder()
+		srv.handleForgotPasswordSubmit(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rec.Code)
+		}
+	}
+
+	submit()
+	var tokens int
+	if err := database.QueryRow(`SELECT COUNT(*) FROM password_tokens`).Scan(&tokens); err != nil {
+		t.Fatalf("count password tokens: %v", err)
+	}
+	if len(sender.messages) != 0 || tokens != 0 {
+		t.Fatalf("expected no email and no token without APP_BASE_URL, got %d messages and %d tokens", len(sender.messages), tokens)
+	}
+
+	srv.baseURL = "https://cotiza.example.com"
+	submit()
+	if len(sender.messages) != 1 {
+		t.Fatalf("expected one message, got %d", len(sender.messages))
+	}
+	if body := sender.messages[0].Body; !strings.Contains(body, "https://cotiza.example.com/password/reset/") || strings.Contains(body, "evil.example") {
+		t.Fatalf("unexpected reset link in %q", body)
+	}
+
+	srv.mailer = nil
+	if err := srv.sendPasswordLink(httptest.NewRequest(http.MethodPost, "/admin/users", nil), "admin@x.com", passwordTokenInvite, "https://cotiza.example.com/x"); !errors.Is(err, errNoMailer) {
+		t.Fatalf("sendPasswordLink without a mailer returned %v, want errNoMailer", err)
+	}
+}
+
+func TestAbsoluteURLFallsBackToRequestHost(t *testing.T) {
+	srv := &server{}
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/password/forgot", nil)
+
+	if got := srv.absoluteURL(req, "/password/reset/abc"); got != "http://localhost:8080/password/reset/abc" {
+		t.Fatalf("absoluteURL = %q", got)
+	}
+}
+
+func TestValidateNewPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		confirm  string
+		wantErr  string
+	}{
+		{name: "valid", password: "contraseña1", confirm: "contraseña1"},
+		{name: "too short", password: "corta", confirm: "corta", wantErr: "al menos 10"},
+		{name: "mismatch", password: "contraseña1", confirm: "contraseña2", wantErr: "no coinciden"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateNewPassword(tt.password, tt.confirm)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
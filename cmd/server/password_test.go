@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/Simplici0/o.works/internal/db"
+)
+
+func TestHashPasswordRoundTrip(t *testing.T) {
+	hash, err := hashPassword("s3cret")
+	if err != nil {
+		t.Fatalf("hashPassword returned error: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=2$") {
+		t.Fatalf("unexpected hash format: %s", hash)
+	}
+
+	other, err := hashPassword("s3cret")
+	if err != nil {
+		t.Fatalf("hashPassword returned error: %v", err)
+	}
+	if hash == other {
+		t.Fatal("expected different salts for the same password")
+	}
+
+	ok, needsRehash, err := verifyPassword(hash, "s3cret")
+	if err != nil || !ok || needsRehash {
+		t.Fatalf("verifyPassword = %v, %v, %v", ok, needsRehash, err)
+	}
+	ok, _, err = verifyPassword(hash, "wrong")
+	if err != nil || ok {
+		t.Fatalf("expected wrong password to fail, got %v, %v", ok, err)
+	}
+}
+
+func TestVerifyPasswordFlagsOutdatedParams(t *testing.T) {
+	salt := []byte("saltsaltsaltsalt")
+	key := argon2.IDKey([]byte("s3cret"), salt, 1, 1024, 1, 32)
+	weak := fmt.Sprintf("$argon2id$v=19$m=1024,t=1,p=1$%s$%s",
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+
+	ok, needsRehash, err := verifyPassword(weak, "s3cret")
+	if err != nil || !ok || !needsRehash {
+		t.Fatalf("verifyPassword = %v, %v, %v", ok, needsRehash, err)
+	}
+
+	if _, _, err := verifyPassword("$argon2id$v=19$broken", "x"); err == nil {
+		t.Fatal("expected malformed hash to return an error")
+	}
+}
+
+func TestVerifyPasswordRejectsOutOfRangeParams(t *testing.T) {
+	encoded := base64.RawStdEncoding.EncodeToString([]byte("saltsaltsaltsalt"))
+	for _, params := range []string{
+		"m=0,t=3,p=2",
+		"m=65536,t=0,p=2",
+		"m=65536,t=3,p=0",
+		"m=4294967295,t=3,p=2",
+		"m=65536,t=100000,p=2",
+		"m=65536,t=3,p=256",
+	} {
+		stored := "$argon2id$v=19$" + params + "$" + encoded + "$" + encoded
+		if _, _, err := verifyPassword(stored, "s3cret"); !errors.Is(err, errInvalidPasswordHash) {
+			t.Errorf("verifyPassword with %s returned %v, want errInvalidPasswordHash", params, err)
+		}
+	}
+}
+
+func TestValidateCredentialsUpgradesLegacyHashes(t *testing.T) {
+	sum := sha256.Sum256([]byte("legacy"))
+
+	tests := []struct {
+		name   string
+		stored string
+	}{
+		{name: "sha256", stored: hex.EncodeToString(sum[:])},
+		{name: "plaintext", stored: "legacy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := newUsersTestDB(t)
+			if _, err := database.Exec(`INSERT INTO users (email, password_hash) VALUES ('a@x.com', ?)`, tt.stored); err != nil {
+				t.Fatalf("failed to seed user: %v", err)
+			}
+			auth := newAuthService(database, "secret", defaultSessionPolicy)
+
+			_, valid, err := auth.validateCredentials("a@x.com", "wrong")
+			if err != nil || valid {
+				t.Fatalf("expected wrong password to fail, got %v, %v", valid, err)
+			}
+
+			_, valid, err = auth.validateCredentials("a@x.com", "legacy")
+			if err != nil || !valid {
+				t.Fatalf("expected legacy password to validate, got %v, %v", valid, err)
+			}
+
+			var stored string
+			if err := database.QueryRow(`SELECT password_hash FROM users WHERE email = 'a@x.com'`).Scan(&stored); err != nil {
+				t.Fatalf("failed to read hash: %v", err)
+			}
+			if !strings.HasPrefix(stored, argon2idPrefix) {
+				t.Fatalf("expected hash to be upgraded, got %s", stored)
+			}
+
+			_, valid, err = auth.validateCredentials("a@x.com", "legacy")
+			if err != nil || !valid {
+				t.Fatalf("expected upgraded password to validate, got %v, %v", valid, err)
+			}
+		})
+	}
+}
+
+func TestUsersRejectNonArgon2Hashes(t *testing.T) {
+	database := newMigratedTestDB(t)
+
+	if _, err := database.Exec(`INSERT INTO users (email, password_hash) VALUES ('a@x.com', 'plain')`); err == nil {
+		t.Fatal("expected plaintext insert to be rejected")
+	}
+
+	auth := newAuthService(database, "secret", defaultSessionPolicy)
+	if err := auth.ensureAdminUser("a@x.com", "secret"); err != nil {
+		t.Fatalf("ensureAdminUser returned error: %v", err)
+	}
+	if _, err := database.Exec(`UPDATE users SET password_hash = 'plain' WHERE email = 'a@x.com'`); err == nil {
+		t.Fatal("expected plaintext update to be rejected")
+	}
+}
+
+// newUsersTestDB creates a users table without the argon2id triggers so legacy
+// rows can be seeded.
+func newUsersTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	_, err = database.Exec(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			email TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT 'admin',
+			active BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		t.Fatalf("failed to create users table: %v", err)
+	}
+
+	return database
+}
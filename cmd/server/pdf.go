@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Simplici0/o.works/internal/quotepdf"
+)
+
+const quotePDFBrand = "o.works"
+
+func (s *server) handleQuotePDF(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid quote id", http.StatusBadRequest)
+		return
+	}
+
+	quote, err := s.store().getQuote(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "failed to load quote", err)
+		return
+	}
+
+	logo, err := fs.ReadFile(s.webFS(), "static/logo.svg")
+	if err != nil {
+		serverError(w, r, "failed to load logo", err)
+		return
+	}
+
+	customerFacing := r.URL.Query().Get("variant") == "customer"
+
+	var buf bytes.Buffer
+	if err := quotepdf.Render(&buf, quotePDFDocument(quote, customerFacing), logo); err != nil {
+		serverError(w, r, "failed to render pdf", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="cotizacion-%d.pdf"`, quote.ID))
+	_, _ = buf.WriteTo(w)
+}
+
+// quotePDFDocument maps a stored quote to the PDF layout. The customer-facing
+// variant hides internal cost lines (material, machine, overhead, failure
+// insurance, margin) and shows only what the customer pays for.
+func quotePDFDocument(quote storedQuote, customerFacing bool) quotepdf.Document {
+	doc := quotepdf.Document{
+		Brand:      quotePDFBrand,
+		Heading:    fmt.Sprintf("Cotización #%d", quote.ID),
+		Reference:  quote.Title,
+		Customer:   quote.CustomerName,
+		Date:       quoteDate(quote.CreatedAt),
+		ValidUntil: quote.ValidUntil,
+		Currency:   quote.Currency,
+		Notes:      quote.Notes,
+		Terms:      quote.Terms,
+	}
+
+	for _, item := range quote.Items {
+		description := fmt.Sprintf("Impresión 3D en %s (%.2f g)", item.MaterialName, item.Grams)
+		if !customerFacing {
+			description = fmt.Sprintf("%s · %.2f g · %.2f min impresión · %.2f min mano de obra", item.MaterialName, item.Grams, item.PrintMinutes, item.LaborMinutes)
+		}
+		doc.Items = append(doc.Items, quotepdf.Item{Description: description, Quantity: item.Quantity})
+	}
+
+	b := quote.Result.Breakdown
+	totals := quote.Result.Totals
+	if customerFacing {
+		doc.Lines = append(doc.Lines, quotepdf.Line{Label: "Productos", Amount: totals.Total - b.Tax - b.PackagingCost - b.ShippingCost})
+		if b.PackagingCost > 0 {
+			doc.Lines = append(doc.Lines, quotepdf.Line{Label: "Empaque", Amount: b.PackagingCost})
+		}
+		if b.ShippingCost > 0 {
+			doc.Lines = append(doc.Lines, quotepdf.Line{Label: "Envío", Amount: b.ShippingCost})
+		}
+	} else {
+		doc.Lines = append(doc.Lines,
+			quotepdf.Line{Label: "Material", Amount: b.MaterialCost},
+			quotepdf.Line{Label: "Máquina", Amount: b.MachineCost},
+			quotepdf.Line{Label: "Mano de obra", Amount: b.LaborCost},
+			quotepdf.Line{Label: "Subtotal", Amount: b.Subtotal},
+			quotepdf.Line{Label: "Overhead", Amount: b.Overhead},
+			quotepdf.Line{Label: "Seguro de falla", Amount: b.FailureInsurance},
+			quotepdf.Line{Label: "Packaging", Amount: b.PackagingCost},
+			quotepdf.Line{Label: "Shipping", Amount: b.ShippingCost},
+			quotepdf.Line{Label: fmt.Sprintf("Margen (%.2f%%)", b.MarginPercent), Amount: b.Margin},
+		)
+	}
+
+	doc.Lines = append(doc.Lines, quotepdf.Line{Label: "Subtotal antes de impuestos", Amount: totals.Total - b.Tax})
+	for _, line := range b.TaxLines {
+		if !line.Withholding {
+			doc.Lines = append(doc.Lines, quotepdf.Line{Label: fmt.Sprintf("%s (%.2f%%)", line.Name, line.Percent), Amount: line.Amount})
+		}
+	}
+	doc.Lines = append(doc.Lines, quotepdf.Line{Label: "Total", Amount: totals.Total, Emphasis: true})
+
+	if b.Withholdings > 0 {
+		for _, line := range b.TaxLines {
+			if line.Withholding {
+				doc.Lines = append(doc.Lines, quotepdf.Line{Label: fmt.Sprintf("%s (%.2f%%)", line.Name, line.Percent), Amount: -line.Amount})
+			}
+		}
+		doc.Lines = append(doc.Lines, quotepdf.Line{Label: "Total a pagar", Amount: totals.Payable, Emphasis: true})
+	}
+
+	return doc
+}
+
+// quoteDate keeps the date part of a stored created_at value.
+func quoteDate(createdAt string) string {
+	if len(createdAt) >= len("2006-01-02") {
+		return createdAt[:len("2006-01-02")]
+	}
+	return createdAt
+}
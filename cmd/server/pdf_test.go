@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Simplici0/o.works/internal/pricing"
+	"github.com/Simplici0/o.works/internal/quotepdf"
+)
+
+func TestQuotePDFDocument_CustomerVariantHidesInternalLines(t *testing.T) {
+	quote := storedQuote{
+		ID:        3,
+		CreatedAt: "2026-10-18T12:00:00Z",
+		Currency:  "COP",
+		Items:     []storedQuoteItem{{MaterialName: "PLA", Grams: 100, Quantity: 2}},
+		Result: pricing.Calculate(
+			pricing.ItemInput{Grams: 1000, Quantity: 1, CostPerKg: 100},
+			pricing.GlobalInput{
+				MarginPercent:      30,
+				FailureRatePercent: 10,
+				ShippingCost:       20,
+				TaxEnabled:         true,
+				TaxRules: []pricing.TaxRule{
+					{Name: "IVA", Percent: 19, Base: pricing.TaxBaseNet},
+					{Name: "ReteIVA", Percent: 15, Base: pricing.TaxBaseTax, Withholding: true},
+				},
+			},
+		),
+	}
+
+	customer := quotePDFDocument(quote, true)
+	internal := quotePDFDocument(quote, false)
+
+	for _, line := range customer.Lines {
+		for _, hidden := range []string{"Margen", "Seguro de falla", "Material", "Overhead"} {
+			if strings.HasPrefix(line.Label, hidden) {
+				t.Fatalf("customer variant shows internal line %q", line.Label)
+			}
+		}
+	}
+	if !hasPDFLine(internal, "Seguro de falla") || !hasPDFLine(internal, "Margen (30.00%)") {
+		t.Fatalf("internal variant is missing cost lines: %+v", internal.Lines)
+	}
+
+	products := customer.Lines[0]
+	if products.Label != "Productos" || products.Amount != 143 {
+		t.Fatalf("unexpected products line: %+v", products)
+	}
+
+	last := customer.Lines[len(customer.Lines)-1]
+	if last.Label != "Total a pagar" || last.Amount != quote.Result.Totals.Payable {
+		t.Fatalf("unexpected last line: %+v", last)
+	}
+	if customer.Date != "2026-10-18" {
+		t.Fatalf("unexpected date: %q", customer.Date)
+	}
+}
+
+func hasPDFLine(doc quotepdf.Document, label string) bool {
+	for _, line := range doc.Lines {
+		if line.Label == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+
+	"github.com/Simplici0/o.works/internal/db"
+	"github.com/Simplici0/o.works/internal/migrations"
+)
+
+// testPostgresDSN is set when the suite runs against PostgreSQL. Every
+// newMigratedTestDB call then gets its own schema on that server instead of a
+// SQLite file.
+var testPostgresDSN string
+
+// TestMain runs the suite against PostgreSQL when OWORKS_TEST_DB=postgres:
+//
+//	OWORKS_TEST_DB=postgres go test ./cmd/server
+//
+// It starts an embedded server, whose binaries are downloaded once and
+// cached under ~/.embedded-postgres-go, unless OWORKS_TEST_POSTGRES_DSN points
+// at a database the tests may create schemas in.
+func TestMain(m *testing.M) {
+	if os.Getenv("OWORKS_TEST_DB") != "postgres" {
+		os.Exit(m.Run())
+	}
+
+	testPostgresDSN = os.Getenv("OWORKS_TEST_POSTGRES_DSN")
+	if testPostgresDSN != "" {
+		os.Exit(m.Run())
+	}
+
+	dsn, stop, err := startEmbeddedPostgres()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start embedded postgres: %v\n", err)
+		os.Exit(1)
+	}
+	testPostgresDSN = dsn
+	code := m.Run()
+	if err := stop(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to stop embedded postgres: %v\n", err)
+	}
+	os.Exit(code)
+}
+
+func startEmbeddedPostgres() (string, func() error, error) {
+	port, err := freePort()
+	if err != nil {
+		return "", nil, err
+	}
+	runtimeDir, err := os.MkdirTemp("", "oworks-postgres-")
+	if err != nil {
+		return "", nil, err
+	}
+
+	config := embeddedpostgres.DefaultConfig().
+		Version(embeddedpostgres.V17).
+		Port(port).
+		RuntimePath(runtimeDir).
+		StartTimeout(time.Minute).
+		Logger(io.Discard)
+	server := embeddedpostgres.NewDatabase(config)
+	if err := server.Start(); err != nil {
+		_ = os.RemoveAll(runtimeDir)
+		return "", nil, err
+	}
+
+	stop := func() error {
+		defer os.RemoveAll(runtimeDir)
+		return server.Stop()
+	}
+	return config.GetConnectionURL() + "?sslmode=disable", stop, nil
+}
+
+func freePort() (uint32, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return uint32(l.Addr().(*net.TCPAddr).Port), nil
+}
+
+// newPostgresTestDB migrates a throwaway schema and drops it when the test
+// ends.
+func newPostgresTestDB(t *testing.T, dsn string) *sql.DB {
+	t.Helper()
+
+	admin, err := db.OpenPostgres(dsn)
+	if err != nil {
+		t.Fatalf("failed to open postgres: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = admin.Close()
+	})
+
+	schema := fmt.Sprintf("oworks_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+	})
+
+	// lib/pq passes unknown settings through as session parameters.
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatalf("failed to parse dsn: %v", err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		dsn = u.String()
+	} else {
+		dsn += " search_path=" + schema
+	}
+
+	database, err := db.OpenPostgres(dsn)
+	if err != nil {
+		t.Fatalf("failed to open postgres schema: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	if err := migrations.Up(database); err != nil {
+		t.Fatalf("failed to migrate postgres: %v", err)
+	}
+
+	return database
+}
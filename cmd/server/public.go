@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Simplici0/o.works/internal/quotepdf"
+)
+
+const (
+	quoteDecisionAccepted = "accepted"
+	quoteDecisionRejected = "rejected"
+
+	maxDecisionCommentLength = 1000
+)
+
+var errQuoteAlreadyDecided = errors.New("quote already decided")
+
+type publicQuoteViewData struct {
+	ErrorMessage string
+	Quote        storedQuote
+	Document     quotepdf.Document
+	ActionURL    string
+	Expired      bool
+}
+
+// handleQuotePublicLink generates a fresh nonce for the quote, which
+// invalidates any link shared before.
+func (s *server) handleQuotePublicLink(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid quote id", http.StatusBadRequest)
+		return
+	}
+
+	nonce, err := newPublicNonce()
+	if err != nil {
+		serverError(w, r, "failed to generate public link", err)
+		return
+	}
+
+	found, err := s.store().setQuotePublicNonce(id, nonce)
+	if err != nil {
+		serverError(w, r, "failed to generate public link", err)
+		return
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/quotes/%d?success=Enlace+p%%C3%%BAblico+generado", id), http.StatusSeeOther)
+}
+
+func (s *server) handlePublicQuote(w http.ResponseWriter, r *http.Request) {
+	quote, ok := s.loadPublicQuote(w, r)
+	if !ok {
+		return
+	}
+
+	s.renderPublicQuote(w, r, http.StatusOK, quote, r.URL.Query().Get("error"))
+}
+
+func (s *server) handlePublicQuoteDecision(w http.ResponseWriter, r *http.Request) {
+	quote, ok := s.loadPublicQuote(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	decision := r.FormValue("decision")
+	if decision != quoteDecisionAccepted && decision != quoteDecisionRejected {
+		s.renderPublicQuote(w, r, http.StatusUnprocessableEntity, quote, "decisión inválida")
+		return
+	}
+
+	comment := strings.TrimSpace(r.FormValue("comment"))
+	if len(comment) > maxDecisionCommentLength {
+		s.renderPublicQuote(w, r, http.StatusUnprocessableEntity, quote, fmt.Sprintf("comentario no puede superar %d caracteres", maxDecisionCommentLength))
+		return
+	}
+
+	if quoteExpired(quote, time.Now()) {
+		s.renderPublicQuote(w, r, http.StatusUnprocessableEntity, quote, "La cotización está vencida.")
+		return
+	}
+
+	err := s.store().recordQuoteDecision(quote.ID, decision, clientIP(r), comment)
+	if errors.Is(err, errQuoteAlreadyDecided) {
+		s.renderPublicQuote(w, r, http.StatusConflict, quote, "La cotización ya fue respondida.")
+		return
+	}
+	if err != nil {
+		serverError(w, r, "failed to record decision", err)
+		return
+	}
+
+	event := webhookEventQuoteAccepted
+	if decision == quoteDecisionRejected {
+		event = webhookEventQuoteRejected
+	}
+	s.emitQuoteWebhookEvent(event, quote.ID)
+
+	http.Redirect(w, r, publicQuotePath(quote.ID, chi.URLParam(r, "signature")), http.StatusSeeOther)
+}
+
+// loadPublicQuote resolves the quote behind a signed public URL. Unknown ids,
+// quotes without a link and bad signatures all look the same to the caller.
+func (s *server) loadPublicQuote(w http.ResponseWriter, r *http.Request) (storedQuote, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.NotFound(w, r)
+		return storedQuote{}, false
+	}
+
+	quote, err := s.store().getQuote(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return storedQuote{}, false
+	}
+	if err != nil {
+		serverError(w, r, "failed to load quote", err)
+		return storedQuote{}, false
+	}
+
+	if !s.verifyQuoteSignature(quote.ID, quote.PublicNonce, chi.URLParam(r, "signature")) {
+		http.NotFound(w, r)
+		return storedQuote{}, false
+	}
+
+	return quote, true
+}
+
+func (s *server) renderPublicQuote(w http.ResponseWriter, r *http.Request, status int, quote storedQuote, errorMessage string) {
+	tmpl, err := template.New("public_quote.html").Funcs(s.templateFuncs(r)).ParseFS(s.webFS(), "templates/public_quote.html")
+	if err != nil {
+		serverError(w, r, "failed to parse template", err)
+		return
+	}
+
+	data := publicQuoteViewData{
+		ErrorMessage: errorMessage,
+		Quote:        quote,
+		Document:     quotePDFDocument(quote, true),
+		ActionURL:    publicQuotePath(quote.ID, s.signQuote(quote.ID, quote.PublicNonce)) + "/decision",
+		Expired:      quoteExpired(quote, time.Now()),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := tmpl.Execute(w, data); err != nil {
+		requestLogger(r).Error("failed to render template", "method", r.Method, "path", r.URL.Path, "error", err)
+	}
+}
+
+// signQuote returns the URL signature for a quote. The nonce is part of the
+// signed payload so regenerating it revokes earlier links.
+func (s *server) signQuote(id int64, nonce string) string {
+	mac := hmac.New(sha256.New, s.auth.sessionSecret)
+	_, _ = fmt.Fprintf(mac, "quote:%d:%s", id, nonce)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *server) verifyQuoteSignature(id int64, nonce, signature string) bool {
+	if nonce == "" {
+		return false
+	}
+	expected := s.signQuote(id, nonce)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// publicQuoteURL builds the absolute link shown to staff, or "" when the quote
+// has no link yet.
+func (s *server) publicQuoteURL(r *http.Request, quote storedQuote) string {
+	if quote.PublicNonce == "" {
+		return ""
+	}
+
+	return s.absoluteURL(r, publicQuotePath(quote.ID, s.signQuote(quote.ID, quote.PublicNonce)))
+}
+
+func publicQuotePath(id int64, signature string) string {
+	return fmt.Sprintf("/public/quotes/%d/%s", id, signature)
+}
+
+func newPublicNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("read random nonce: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// quoteExpired reports whether the quote's validity date is in the past. The
+// validity date itself is still valid for the whole day.
+func quoteExpired(quote storedQuote, now time.Time) bool {
+	if quote.ValidUntil == "" {
+		return false
+	}
+	validUntil, err := time.ParseInLocation(time.DateOnly, quote.ValidUntil, now.Location())
+	if err != nil {
+		return false
+	}
+	return now.After(validUntil.AddDate(0, 0, 1))
+}
+
+// clientIP returns the request's peer address without the port. Behind a
+// trusted proxy, trustedProxyMiddleware has already replaced it with the client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
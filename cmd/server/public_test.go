@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVerifyQuoteSignature(t *testing.T) {
+	srv := &server{auth: newAuthService(nil, "secret", defaultSessionPolicy)}
+
+	signature := srv.signQuote(7, "nonce-a")
+	if !srv.verifyQuoteSignature(7, "nonce-a", signature) {
+		t.Fatal("expected signature to verify")
+	}
+	if srv.verifyQuoteSignature(8, "nonce-a", signature) {
+		t.Fatal("signature must not verify for another quote")
+	}
+	if srv.verifyQuoteSignature(7, "nonce-b", signature) {
+		t.Fatal("regenerated nonce must revoke the old signature")
+	}
+	if srv.verifyQuoteSignature(7, "", srv.signQuote(7, "")) {
+		t.Fatal("quotes without a nonce must not have a public link")
+	}
+}
+
+func TestPublicQuoteURL(t *testing.T) {
+	srv := &server{auth: newAuthService(nil, "secret", defaultSessionPolicy)}
+	quote := storedQuote{ID: 7, PublicNonce: "nonce-a"}
+	path := publicQuotePath(7, srv.signQuote(7, "nonce-a"))
+	r := httptest.NewRequest(http.MethodGet, "/quotes/7", nil)
+	r.Host = "internal:8080"
+
+	if got := srv.publicQuoteURL(r, storedQuote{ID: 7}); got != "" {
+		t.Fatalf("expected no link without a nonce, got %q", got)
+	}
+	if got, want := srv.publicQuoteURL(r, quote), "http://internal:8080"+path; got != want {
+		t.Fatalf("publicQuoteURL = %q, want %q", got, want)
+	}
+
+	srv.baseURL = "https://cotiza.example.com"
+	if got, want := srv.publicQuoteURL(r, quote), "https://cotiza.example.com"+path; got != want {
+		t.Fatalf("publicQuoteURL = %q, want %q", got, want)
+	}
+}
+
+func TestRecordQuoteDecisionOnlyOnce(t *testing.T) {
+	database := newMigratedTestDB(t)
+	srv := &server{db: database}
+
+	if _, err := database.Exec(`INSERT INTO materials (name, cost_per_kg) VALUES ('PLA', 100000)`); err != nil {
+		t.Fatalf("failed to seed material: %v", err)
+	}
+
+	calc, err := srv.calculateQuote(quoteFormValues{MaterialID: 1, Grams: 100, Quantity: 1})
+	if err != nil {
+		t.Fatalf("calculateQuote returned error: %v", err)
+	}
+	id, err := srv.store().saveQuote(calc)
+	if err != nil {
+		t.Fatalf("saveQuote returned error: %v", err)
+	}
+
+	if err := srv.store().recordQuoteDecision(id, quoteDecisionAccepted, "203.0.113.5", "Adelante"); err != nil {
+		t.Fatalf("recordQuoteDecision returned error: %v", err)
+	}
+	if err := srv.store().recordQuoteDecision(id, quoteDecisionRejected, "203.0.113.6", ""); !errors.Is(err, errQuoteAlreadyDecided) {
+		t.Fatalf("expected errQuoteAlreadyDecided, got %v", err)
+	}
+
+	quote, err := srv.store().getQuote(id)
+	if err != nil {
+		t.Fatalf("getQuote returned error: %v", err)
+	}
+	if quote.Decision != quoteDecisionAccepted || quote.DecisionIP != "203.0.113.5" || quote.DecisionComment != "Adelante" || quote.DecidedAt == "" {
+		t.Fatalf("unexpected decision: %+v", quote)
+	}
+}
+
+func TestQuoteExpired(t *testing.T) {
+	now := time.Date(2026, 3, 10, 15, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		validUntil string
+		want       bool
+	}{
+		{validUntil: "", want: false},
+		{validUntil: "2026-03-10", want: false},
+		{validUntil: "2026-03-09", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := quoteExpired(storedQuote{ValidUntil: tt.validUntil}, now); got != tt.want {
+			t.Fatalf("quoteExpired(%q) = %v, want %v", tt.validUntil, got, tt.want)
+		}
+	}
+}
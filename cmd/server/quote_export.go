@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+
+	"github.com/Simplici0/o.works/internal/pricing"
+)
+
+const quoteExportSheet = "Cotizaciones"
+
+// quoteExportColumns are the fixed export columns. One "tax_line: <name>"
+// column per tax or withholding found in the exported quotes follows them.
+var quoteExportColumns = []string{
+	"id",
+	"created_at",
+	"title",
+	"customer_name",
+	"customer_type",
+	"price_mode",
+	"currency",
+	"exchange_rate",
+	"decision",
+	"material_cost",
+	"machine_cost",
+	"labor_cost",
+	"subtotal",
+	"overhead",
+	"failure_insurance",
+	"packaging_cost",
+	"shipping_cost",
+	"margin",
+	"margin_percent",
+	"tax",
+	"withholdings",
+	"pre_tax",
+	"total",
+	"payable",
+}
+
+type quoteExportRow struct {
+	ID           int64
+	CreatedAt    string
+	Title        string
+	CustomerName string
+	CustomerType string
+	PriceMode    string
+	Currency     string
+	ExchangeRate float64
+	Decision     string
+	Result       pricing.Result
+}
+
+// quoteExportTable is the header and cells shared by the CSV and XLSX files.
+// Amounts are float64 cells so the spreadsheet keeps them numeric.
+type quoteExportTable struct {
+	Header []string
+	Rows   [][]any
+}
+
+func (s *server) handleQuotesExportCSV(w http.ResponseWriter, r *http.Request) {
+	table, err := s.quoteExport(strings.TrimSpace(r.URL.Query().Get("q")))
+	if err != nil {
+		serverError(w, r, "failed to export quotes", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := writeQuoteExportCSV(&buf, table); err != nil {
+		serverError(w, r, "failed to export quotes", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+quoteExportFilename("csv")+`"`)
+	_, _ = buf.WriteTo(w)
+}
+
+func (s *server) handleQuotesExportXLSX(w http.ResponseWriter, r *http.Request) {
+	table, err := s.quoteExport(strings.TrimSpace(r.URL.Query().Get("q")))
+	if err != nil {
+		serverError(w, r, "failed to export quotes", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := writeQuoteExportXLSX(&buf, table); err != nil {
+		serverError(w, r, "failed to export quotes", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+quoteExportFilename("xlsx")+`"`)
+	_, _ = buf.WriteTo(w)
+}
+
+func quoteExportFilename(extension string) string {
+	return "cotizaciones-" + time.Now().Format("20060102") + "." + extension
+}
+
+func writeQuoteExportCSV(w io.Writer, table quoteExportTable) error {
+	writer := csv.NewWriter(w)
+	_ = writer.Write(table.Header)
+	for _, row := range table.Rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			switch v := cell.(type) {
+			case float64:
+				record[i] = formatCSVFloat(v)
+			case string:
+				record[i] = csvText(v)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		_ = writer.Write(record)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("write csv: %w", err)
+	}
+	return nil
+}
+
+func writeQuoteExportXLSX(w io.Writer, table quoteExportTable) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	if err := f.SetSheetName("Sheet1", quoteExportSheet); err != nil {
+		return fmt.Errorf("rename sheet: %w", err)
+	}
+	stream, err := f.NewStreamWriter(quoteExportSheet)
+	if err != nil {
+		return fmt.Errorf("create stream writer: %w", err)
+	}
+	bold, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return fmt.Errorf("create header style: %w", err)
+	}
+	if err := stream.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}); err != nil {
+		return fmt.Errorf("freeze header: %w", err)
+	}
+
+	header := make([]any, len(table.Header))
+	for i, name := range table.Header {
+		header[i] = excelize.Cell{StyleID: bold, Value: name}
+	}
+	if err := stream.SetRow("A1", header); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	for i, row := range table.Rows {
+		cell, err := excelize.CoordinatesToCellName(1, i+2)
+		if err != nil {
+			return err
+		}
+		if err := stream.SetRow(cell, row); err != nil {
+			return fmt.Errorf("write row %d: %w", i+2, err)
+		}
+	}
+	if err := stream.Flush(); err != nil {
+		return fmt.Errorf("flush sheet: %w", err)
+	}
+
+	if err := f.Write(w); err != nil {
+		return fmt.Errorf("write xlsx: %w", err)
+	}
+	return nil
+}
+
+// quoteExport builds the table for the quotes matching query, in the same
+// order as the /quotes list.
+func (s *server) quoteExport(query string) (quoteExportTable, error) {
+	quotes, err := s.store().listQuoteExportRows(query)
+	if err != nil {
+		return quoteExportTable{}, err
+	}
+
+	var taxNames []string
+	taxColumn := make(map[string]int)
+	for _, q := range quotes {
+		for _, line := range q.Result.Breakdown.TaxLines {
+			if _, ok := taxColumn[line.Name]; !ok {
+				taxColumn[line.Name] = len(quoteExportColumns) + len(taxNames)
+				taxNames = append(taxNames, line.Name)
+			}
+		}
+	}
+
+	table := quoteExportTable{
+		Header: append([]string(nil), quoteExportColumns...),
+		Rows:   make([][]any, 0, len(quotes)),
+	}
+	for _, name := range taxNames {
+		table.Header = append(table.Header, "tax_line: "+name)
+	}
+
+	for _, q := range quotes {
+		b := q.Result.Breakdown
+		t := q.Result.Totals
+		row := []any{
+			q.ID,
+			q.CreatedAt,
+			q.Title,
+			q.CustomerName,
+			q.CustomerType,
+			q.PriceMode,
+			q.Currency,
+			q.ExchangeRate,
+			q.Decision,
+			b.MaterialCost,
+			b.MachineCost,
+			b.LaborCost,
+			b.Subtotal,
+			b.Overhead,
+			b.FailureInsurance,
+			b.PackagingCost,
+			b.ShippingCost,
+			b.Margin,
+			b.MarginPercent,
+			b.Tax,
+			b.Withholdings,
+			t.PreTax,
+			t.Total,
+			t.Payable,
+		}
+		for range taxNames {
+			row = append(row, "")
+		}
+		for _, line := range b.TaxLines {
+			amount := line.Amount
+			if existing, ok := row[taxColumn[line.Name]].(float64); ok {
+				amount += existing
+			}
+			row[taxColumn[line.Name]] = amount
+		}
+		table.Rows = append(table.Rows, row)
+	}
+
+	return table, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+
+	"github.com/Simplici0/o.works/internal/pricing"
+)
+
+func newQuoteExportTestServer(t *testing.T) *server {
+	t.Helper()
+
+	database := newMigratedTestDB(t)
+	srv := &server{db: database}
+	if _, err := database.Exec(`INSERT INTO materials (name, cost_per_kg) VALUES ('PLA', 100000)`); err != nil {
+		t.Fatalf("failed to seed material: %v", err)
+	}
+	if _, err := database.Exec(`UPDATE tax_rules SET active = TRUE WHERE name = 'Retención en la fuente'`); err != nil {
+		t.Fatalf("failed to activate withholding: %v", err)
+	}
+
+	for _, values := range []quoteFormValues{
+		{MaterialID: 1, Grams: 100, Quantity: 1, TaxEnabled: true, CustomerType: customerTypeJuridica, Title: "Llaveros"},
+		{MaterialID: 1, Grams: 50, Quantity: 2, CustomerType: customerTypeNatural, Title: "Prototipo"},
+	} {
+		calc, err := srv.calculateQuote(values)
+		if err != nil {
+			t.Fatalf("calculateQuote returned error: %v", err)
+		}
+		if _, err := srv.store().saveQuote(calc); err != nil {
+			t.Fatalf("saveQuote returned error: %v", err)
+		}
+	}
+
+	return srv
+}
+
+func TestQuoteExportColumnsCoverBreakdown(t *testing.T) {
+	encoded, err := json.Marshal(pricing.Result{})
+	if err != nil {
+		t.Fatalf("marshal result: %v", err)
+	}
+	var result map[string]map[string]any
+	if err := json.Unmarshal(encoded, &result); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+
+	for _, part := range []string{"breakdown", "totals"} {
+		for field := range result[part] {
+			if field == "tax_lines" {
+				continue
+			}
+			if !slices.Contains(quoteExportColumns, field) {
+				t.Fatalf("%s field %q is missing from the export", part, field)
+			}
+		}
+	}
+}
+
+func TestQuoteExportTable(t *testing.T) {
+	srv := newQuoteExportTestServer(t)
+
+	table, err := srv.quoteExport("")
+	if err != nil {
+		t.Fatalf("quoteExport returned error: %v", err)
+	}
+	if len(table.Rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(table.Rows))
+	}
+	want := append(append([]string(nil), quoteExportColumns...), "tax_line: IVA", "tax_line: Retención en la fuente")
+	if !slices.Equal(table.Header, want) {
+		t.Fatalf("unexpected header: %v", table.Header)
+	}
+
+	column := func(name string) int { return slices.Index(table.Header, name) }
+	llaveros := table.Rows[1]
+	if llaveros[column("title")] != "Llaveros" || llaveros[column("total")] != 11900.0 || llaveros[column("payable")] != 11650.0 {
+		t.Fatalf("unexpected row: %v", llaveros)
+	}
+	if llaveros[column("tax_line: IVA")] != 1900.0 || llaveros[column("tax_line: Retención en la fuente")] != 250.0 {
+		t.Fatalf("unexpected tax lines: %v", llaveros)
+	}
+	if prototipo := table.Rows[0]; prototipo[column("tax_line: IVA")] != "" {
+		t.Fatalf("expected an empty tax cell for an untaxed quote: %v", prototipo)
+	}
+
+	filtered, err := srv.quoteExport("Llave")
+	if err != nil {
+		t.Fatalf("quoteExport returned error: %v", err)
+	}
+	if len(filtered.Rows) != 1 || filtered.Rows[0][column("title")] != "Llaveros" {
+		t.Fatalf("expected the search to filter the export: %v", filtered.Rows)
+	}
+}
+
+func TestQuoteExportHandlers(t *testing.T) {
+	srv := newQuoteExportTestServer(t)
+
+	rec := httptest.NewRecorder()
+	srv.handleQuotesExportCSV(rec, httptest.NewRequest(http.MethodGet, "/quotes/export.csv?q=Proto", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("unexpected csv response %d %v", rec.Code, rec.Header())
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("read csv: %v", err)
+	}
+	if len(records) != 2 || records[1][2] != "Prototipo" || records[1][0] != "2" {
+		t.Fatalf("unexpected csv: %v", records)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.handleQuotesExportXLSX(rec, httptest.NewRequest(http.MethodGet, "/quotes/export.xlsx", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected xlsx status %d", rec.Code)
+	}
+
+	f, err := excelize.OpenReader(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("open xlsx: %v", err)
+	}
+	defer f.Close()
+
+	rows, err := f.GetRows(quoteExportSheet)
+	if err != nil {
+		t.Fatalf("read sheet: %v", err)
+	}
+	if len(rows) != 3 || rows[0][0] != "id" || rows[2][2] != "Llaveros" {
+		t.Fatalf("unexpected sheet: %v", rows)
+	}
+	totalCell, err := excelize.CoordinatesToCellName(slices.Index(quoteExportColumns, "total")+1, 3)
+	if err != nil {
+		t.Fatalf("cell name: %v", err)
+	}
+	// Number cells carry no type attribute in the sheet XML.
+	if cellType, err := f.GetCellType(quoteExportSheet, totalCell); err != nil || (cellType != excelize.CellTypeUnset && cellType != excelize.CellTypeNumber) {
+		t.Fatalf("expected a numeric total cell, got %v, %v", cellType, err)
+	}
+}
+
+func TestWriteQuoteExportCSVEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeQuoteExportCSV(&buf, quoteExportTable{
+		Header: []string{"title", "customer", "total"},
+		Rows:   [][]any{{"=cmd|' /C calc'!A0", "@Cliente", -1500.5}},
+	})
+	if err != nil {
+		t.Fatalf("writeQuoteExportCSV returned error: %v", err)
+	}
+	want := "title,customer,total\n'=cmd|' /C calc'!A0,'@Cliente,-1500.5\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected csv:\n%s", buf.String())
+	}
+}
@@ -72,3 +72,59 @@ func TestParseQuoteFormValues_InvalidNumbers(t *testing.T) {
 		t.Fatalf("expected numeric validation error")
 	}
 }
+
+func TestParseQuoteFormValues_InclusiveModeRequiresFinalPrice(t *testing.T) {
+	form := url.Values{}
+	form.Set("material_id", "1")
+	form.Set("grams", "120")
+	form.Set("printMinutes", "95")
+	form.Set("laborMinutes", "15")
+	form.Set("quantity", "2")
+	form.Set("wastePercent", "7")
+	form.Set("marginPercent", "35")
+	form.Set("price_mode", "inclusive")
+
+	req := httptest.NewRequest("POST", "/quote/calc", nil)
+	req.Form = form
+
+	if _, err := parseQuoteFormValues(req); err == nil {
+		t.Fatalf("expected finalPrice validation error")
+	}
+
+	form.Set("finalPrice", "120000")
+	values, err := parseQuoteFormValues(req)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if values.PriceMode != priceModeInclusive || values.FinalPrice != 120000 {
+		t.Fatalf("unexpected values: %+v", values)
+	}
+}
+
+func TestParseQuoteFormValues_TargetPrice(t *testing.T) {
+	form := url.Values{}
+	form.Set("material_id", "1")
+	form.Set("grams", "120")
+	form.Set("printMinutes", "95")
+	form.Set("laborMinutes", "15")
+	form.Set("quantity", "2")
+	form.Set("wastePercent", "7")
+	form.Set("marginPercent", "35")
+	form.Set("targetPrice", "120000")
+
+	req := httptest.NewRequest("POST", "/quote/calc", nil)
+	req.Form = form
+
+	values, err := parseQuoteFormValues(req)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if values.PriceMode != priceModeExclusive || values.TargetPrice != 120000 {
+		t.Fatalf("unexpected values: %+v", values)
+	}
+
+	form.Set("targetPrice", "abc")
+	if _, err := parseQuoteFormValues(req); err == nil {
+		t.Fatalf("expected targetPrice validation error")
+	}
+}
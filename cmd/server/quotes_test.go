@@ -2,9 +2,13 @@ package main
 
 import (
 	"database/sql"
+	"path/filepath"
 	"testing"
 
 	_ "modernc.org/sqlite"
+
+	"github.com/Simplici0/o.works/internal/db"
+	"github.com/Simplici0/o.works/internal/migrations"
 )
 
 func TestListQuotesOrdersByDateDescAndReadsTotal(t *testing.T) {
@@ -15,7 +19,7 @@ func TestListQuotesOrdersByDateDescAndReadsTotal(t *testing.T) {
 	seedQuote(t, db, "2024-01-03 12:00:00", "Tercera", "nota tres", `{"total": 300.00}`)
 	seedQuote(t, db, "2024-01-02 11:00:00", "Segunda", "nota dos", `{"total": 200.25}`)
 
-	quotes, err := srv.listQuotes("")
+	quotes, err := srv.store().listQuotes("")
 	if err != nil {
 		t.Fatalf("listQuotes returned error: %v", err)
 	}
@@ -41,7 +45,7 @@ func TestListQuotesFilterByTitleAndNotes(t *testing.T) {
 	seedQuote(t, db, "2024-01-02 10:00:00", "Llaveros", "cliente vip", `{"total": 120}`)
 	seedQuote(t, db, "2024-01-03 10:00:00", "Prototipo", "urgente para casa", `{"total": 160}`)
 
-	byTitle, err := srv.listQuotes("Llave")
+	byTitle, err := srv.store().listQuotes("Llave")
 	if err != nil {
 		t.Fatalf("listQuotes title filter returned error: %v", err)
 	}
@@ -49,7 +53,7 @@ func TestListQuotesFilterByTitleAndNotes(t *testing.T) {
 		t.Fatalf("expected 1 quote filtered by title, got %+v", byTitle)
 	}
 
-	byNotes, err := srv.listQuotes("casa")
+	byNotes, err := srv.store().listQuotes("casa")
 	if err != nil {
 		t.Fatalf("listQuotes notes filter returned error: %v", err)
 	}
@@ -58,6 +62,79 @@ func TestListQuotesFilterByTitleAndNotes(t *testing.T) {
 	}
 }
 
+func TestSaveQuoteSnapshotsTaxRules(t *testing.T) {
+	database := newMigratedTestDB(t)
+	srv := &server{db: database}
+
+	if _, err := database.Exec(`INSERT INTO materials (name, cost_per_kg) VALUES ('PLA', 100000)`); err != nil {
+		t.Fatalf("failed to seed material: %v", err)
+	}
+	if _, err := database.Exec(`UPDATE tax_rules SET active = TRUE WHERE name = 'Retención en la fuente'`); err != nil {
+		t.Fatalf("failed to activate withholding: %v", err)
+	}
+
+	calc, err := srv.calculateQuote(quoteFormValues{
+		MaterialID:   1,
+		Grams:        100,
+		Quantity:     1,
+		TaxEnabled:   true,
+		CustomerType: customerTypeJuridica,
+		Title:        "Llaveros",
+	})
+	if err != nil {
+		t.Fatalf("calculateQuote returned error: %v", err)
+	}
+
+	id, err := srv.store().saveQuote(calc)
+	if err != nil {
+		t.Fatalf("saveQuote returned error: %v", err)
+	}
+
+	// Rule changes after saving must not affect the stored quote.
+	if _, err := database.Exec(`UPDATE tax_rules SET percent = 5`); err != nil {
+		t.Fatalf("failed to update tax rules: %v", err)
+	}
+
+	quote, err := srv.store().getQuote(id)
+	if err != nil {
+		t.Fatalf("getQuote returned error: %v", err)
+	}
+	if quote.Title != "Llaveros" || quote.CustomerType != customerTypeJuridica || len(quote.Items) != 1 {
+		t.Fatalf("unexpected quote: %+v", quote)
+	}
+	if len(quote.TaxRules) != 2 || quote.TaxRules[0].Name != "IVA" || quote.TaxRules[0].Percent != 19 {
+		t.Fatalf("unexpected tax rules snapshot: %+v", quote.TaxRules)
+	}
+	if len(quote.Result.Breakdown.TaxLines) != 2 {
+		t.Fatalf("unexpected tax lines: %+v", quote.Result.Breakdown.TaxLines)
+	}
+	if quote.Result.Totals.Total != 11900 || quote.Result.Totals.Payable != 11650 {
+		t.Fatalf("unexpected totals: %+v", quote.Result.Totals)
+	}
+}
+
+func newMigratedTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	if testPostgresDSN != "" {
+		return newPostgresTestDB(t, testPostgresDSN)
+	}
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	if err := migrations.Up(database); err != nil {
+		t.Fatalf("failed to migrate db: %v", err)
+	}
+
+	return database
+}
+
 func newQuotesTestDB(t *testing.T) *sql.DB {
 	t.Helper()
 
@@ -72,6 +149,7 @@ func newQuotesTestDB(t *testing.T) *sql.DB {
 			created_at DATETIME NOT NULL,
 			title TEXT,
 			notes TEXT,
+			currency TEXT NOT NULL DEFAULT 'COP',
 			totals_json TEXT NOT NULL
 		);
 	`)
@@ -0,0 +1,1209 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Simplici0/o.works/internal/db"
+	"github.com/Simplici0/o.works/internal/pricing"
+)
+
+// store is the persistence the HTTP handlers and commands need for the
+// catalog, quotes and users, including credentials and two-factor state.
+// Session, API token and password token lookups, taxes, exchange rates and
+// webhooks still query the database directly with SQL both
+// backends accept: booleans are TRUE/FALSE, timestamps are written with
+// sqliteTime and read back through parseSQLiteTime, which also takes the RFC
+// 3339 values PostgreSQL timestamps scan as. Running the tests with
+// OWORKS_TEST_DB=postgres exercises them on PostgreSQL.
+type store interface {
+	// withTx runs fn with a store whose operations share one transaction,
+	// which is committed when fn returns nil and rolled back otherwise.
+	withTx(fn func(st store) error) error
+
+	rateStore
+	materialStore
+	shippingStore
+	packagingStore
+	quoteStore
+	userStore
+}
+
+type rateStore interface {
+	ensureRateConfig() error
+	getRateConfig() (rateConfig, error)
+	updateRateConfig(rc rateConfig) error
+}
+
+type materialStore interface {
+	listMaterials() ([]material, error)
+	listActiveMaterials() ([]material, error)
+	getActiveMaterialByID(id int64) (material, error)
+	createMaterial(m material) (int64, error)
+	// updateMaterial returns the cost_per_kg before the update and false
+	// when no material has the given id.
+	updateMaterial(id int64, m material) (float64, bool, error)
+}
+
+type shippingStore interface {
+	listShippingRates() ([]shippingRate, error)
+	listActiveShippingRates() ([]shippingRate, error)
+	getOptionalActiveShippingCost(id int64) (float64, error)
+	createShippingRate(rate shippingRate) (int64, error)
+	updateShippingRate(id int64, rate shippingRate) (bool, error)
+}
+
+type packagingStore interface {
+	listPackagingRates() ([]packagingRate, error)
+	listActivePackagingRates() ([]packagingRate, error)
+	getOptionalActivePackagingCost(id int64) (float64, error)
+	createPackagingRate(rate packagingRate) (int64, error)
+	updatePackagingRate(id int64, rate packagingRate) (bool, error)
+}
+
+type quoteStore interface {
+	saveQuote(calc quoteCalculation) (int64, error)
+	getQuote(id int64) (storedQuote, error)
+	getQuoteFormValues(id int64) (quoteFormValues, error)
+	listQuotes(query string) ([]quoteListItem, error)
+	listQuoteExportRows(query string) ([]quoteExportRow, error)
+	// setQuotePublicNonce reports false when no quote has the given id.
+	setQuotePublicNonce(id int64, nonce string) (bool, error)
+	recordQuoteDecision(id int64, decision, ip, comment string) error
+}
+
+type userStore interface {
+	listUsers() ([]user, error)
+	userExists(email string) (bool, error)
+	createUser(email, passwordHash, role string) (int64, error)
+	// updateUserAccess reports false when no user has the given id.
+	updateUserAccess(id int64, role string, active bool) (bool, error)
+	// getUserEmail and getUserIDByEmail return sql.ErrNoRows for unknown
+	// users.
+	getUserEmail(id int64) (string, error)
+	getUserIDByEmail(email string) (int64, error)
+	// getActiveUserCredentials, getActiveUserIDByEmail and getUserActive
+	// return sql.ErrNoRows for unknown users, and the first two for
+	// deactivated ones too.
+	getActiveUserCredentials(email string) (int64, string, error)
+	getActiveUserIDByEmail(email string) (int64, error)
+	getUserActive(id int64) (bool, error)
+	updateUserPasswordHash(id int64, passwordHash string) error
+	// resetPasswordWithToken marks the token used, stores the new hash and
+	// ends the user's sessions in one transaction. It returns
+	// errPasswordTokenUsed when the token was already used.
+	resetPasswordWithToken(tokenID, userID int64, passwordHash string) error
+
+	getUserTOTP(id int64) (totpState, error)
+	// setUserTOTPSecret only replaces the secret while TOTP is not enabled.
+	setUserTOTPSecret(id int64, secret string) error
+	// enableUserTOTP turns TOTP on and replaces the recovery codes with the
+	// given hashes in one transaction.
+	enableUserTOTP(id, counter int64, recoveryCodeHashes []string) error
+	resetUserTOTP(id int64) error
+	// advanceUserTOTPCounter records an accepted TOTP step and reports false
+	// when it is not newer than the last one, i.e. the code was replayed.
+	advanceUserTOTPCounter(id, counter int64) (bool, error)
+}
+
+// sqlStore implements store on SQLite and PostgreSQL. Queries use ?
+// placeholders, which the PostgreSQL connection rewrites; the few
+// dialect-specific fragments are picked by the postgres flag.
+type sqlStore struct {
+	db       *sql.DB
+	tx       *sql.Tx
+	postgres bool
+}
+
+func newSQLStore(database *sql.DB) *sqlStore {
+	return &sqlStore{db: database, postgres: db.IsPostgres(database)}
+}
+
+// queryer is the part of *sql.DB and *sql.Tx the store queries through.
+type queryer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// q returns the transaction the store is bound to, or the database.
+func (st *sqlStore) q() queryer {
+	if st.tx != nil {
+		return st.tx
+	}
+	return st.db
+}
+
+func (st *sqlStore) withTx(fn func(st store) error) error {
+	return st.inTx(func(tx *sqlStore) error {
+		return fn(tx)
+	})
+}
+
+// inTx runs fn on a store bound to a new transaction, or to the current one
+// when the store already is.
+func (st *sqlStore) inTx(fn func(tx *sqlStore) error) error {
+	if st.tx != nil {
+		return fn(st)
+	}
+
+	tx, err := st.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(&sqlStore{db: st.db, tx: tx, postgres: st.postgres}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// quoteSearchFilter matches the /quotes search box against title and notes,
+// ignoring case. It takes the query, then the LIKE pattern twice.
+func (st *sqlStore) quoteSearchFilter() string {
+	like := "LIKE"
+	if st.postgres {
+		like = "ILIKE"
+	}
+	return `(? = '' OR COALESCE(title, '') ` + like + ` ? OR COALESCE(notes, '') ` + like + ` ?)`
+}
+
+// quoteOrder sorts quotes newest first. SQLite compares created_at as text,
+// so older rows written with a different layout are normalized first.
+func (st *sqlStore) quoteOrder() string {
+	if st.postgres {
+		return `created_at DESC, id DESC`
+	}
+	return `datetime(created_at) DESC, id DESC`
+}
+
+// storedTime formats a timestamp column the way SQLite stores it. PostgreSQL
+// timestamps scan as RFC 3339 strings.
+func storedTime(value string) string {
+	t, err := parseSQLiteTime(value)
+	if err != nil {
+		return value
+	}
+	return sqliteTime(t)
+}
+
+func (st *sqlStore) ensureRateConfig() error {
+	_, err := st.q().Exec(`
+		INSERT INTO rate_config (
+			id,
+			machine_hourly_rate,
+			labor_per_minute,
+			overhead_fixed,
+			overhead_percent,
+			failure_rate_percent,
+			tax_percent,
+			currency
+		) VALUES (1, 0, 0, 0, 0, 0, 0, 'COP')
+		ON CONFLICT(id) DO NOTHING
+	`)
+	if err != nil {
+		return fmt.Errorf("insert default rate_config: %w", err)
+	}
+	return nil
+}
+
+func (st *sqlStore) getRateConfig() (rateConfig, error) {
+	if err := st.ensureRateConfig(); err != nil {
+		return rateConfig{}, err
+	}
+
+	var rc rateConfig
+	err := st.q().QueryRow(`
+		SELECT machine_hourly_rate, labor_per_minute, overhead_fixed, overhead_percent, failure_rate_percent, tax_percent, currency, quote_validity_days, quote_terms
+		FROM rate_config
+		WHERE id = 1
+	`).Scan(
+		&rc.MachineHourlyRate,
+		&rc.LaborPerMinute,
+		&rc.OverheadFixed,
+		&rc.OverheadPercent,
+		&rc.FailureRatePercent,
+		&rc.TaxPercent,
+		&rc.Currency,
+		&rc.QuoteValidityDays,
+		&rc.QuoteTerms,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return rateConfig{}, fmt.Errorf("rate_config singleton not found")
+		}
+		return rateConfig{}, fmt.Errorf("query rate_config: %w", err)
+	}
+	return rc, nil
+}
+
+// errBaseCurrencyInUse is returned by updateRateConfig when the base currency
+// would change while catalog amounts or exchange rates are still expressed in
+// the current one.
+var errBaseCurrencyInUse = errors.New("currency no puede cambiar mientras existan materiales, tarifas de envío o empaque o tasas de cambio en la moneda actual")
+
+func (st *sqlStore) updateRateConfig(rc rateConfig) error {
+	return st.inTx(func(tx *sqlStore) error {
+		current, err := tx.getRateConfig()
+		if err != nil {
+			return err
+		}
+		if rc.Currency != current.Currency {
+			var inUse bool
+			err := tx.q().QueryRow(`
+				SELECT EXISTS(SELECT 1 FROM materials)
+					OR EXISTS(SELECT 1 FROM shipping_rates)
+					OR EXISTS(SELECT 1 FROM packaging_rates)
+					OR EXISTS(SELECT 1 FROM exchange_rates)
+			`).Scan(&inUse)
+			if err != nil {
+				return fmt.Errorf("query base currency usage: %w", err)
+			}
+			if inUse {
+				return errBaseCurrencyInUse
+			}
+		}
+
+		_, err = tx.q().Exec(`
+			UPDATE rate_config
+			SET
+				machine_hourly_rate = ?,
+				labor_per_minute = ?,
+				overhead_fixed = ?,
+				overhead_percent = ?,
+				failure_rate_percent = ?,
+				tax_percent = ?,
+				currency = ?,
+				quote_validity_days = ?,
+				quote_terms = ?,
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = 1
+		`,
+			rc.MachineHourlyRate,
+			rc.LaborPerMinute,
+			rc.OverheadFixed,
+			rc.OverheadPercent,
+			rc.FailureRatePercent,
+			rc.TaxPercent,
+			rc.Currency,
+			rc.QuoteValidityDays,
+			rc.QuoteTerms,
+		)
+		if err != nil {
+			return fmt.Errorf("update rate_config: %w", err)
+		}
+		return nil
+	})
+}
+
+func (st *sqlStore) listMaterials() ([]material, error) {
+	rows, err := st.q().Query(`
+		SELECT id, name, cost_per_kg, COALESCE(notes, ''), active
+		FROM materials
+		ORDER BY id DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query materials: %w", err)
+	}
+	defer rows.Close()
+
+	materials := make([]material, 0)
+	for rows.Next() {
+		var m material
+		if err := rows.Scan(&m.ID, &m.Name, &m.CostPerKg, &m.Notes, &m.Active); err != nil {
+			return nil, fmt.Errorf("scan material: %w", err)
+		}
+		materials = append(materials, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate materials: %w", err)
+	}
+
+	return materials, nil
+}
+
+func (st *sqlStore) listActiveMaterials() ([]material, error) {
+	rows, err := st.q().Query(`
+		SELECT id, name, cost_per_kg, COALESCE(notes, ''), active
+		FROM materials
+		WHERE active = TRUE
+		ORDER BY name ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query active materials: %w", err)
+	}
+	defer rows.Close()
+
+	materials := make([]material, 0)
+	for rows.Next() {
+		var m material
+		if err := rows.Scan(&m.ID, &m.Name, &m.CostPerKg, &m.Notes, &m.Active); err != nil {
+			return nil, fmt.Errorf("scan active material: %w", err)
+		}
+		materials = append(materials, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate active materials: %w", err)
+	}
+
+	return materials, nil
+}
+
+func (st *sqlStore) getActiveMaterialByID(id int64) (material, error) {
+	var m material
+	err := st.q().QueryRow(`
+		SELECT id, name, cost_per_kg, COALESCE(notes, ''), active
+		FROM materials
+		WHERE id = ? AND active = TRUE
+	`, id).Scan(&m.ID, &m.Name, &m.CostPerKg, &m.Notes, &m.Active)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return material{}, fmt.Errorf("material no encontrado o inactivo")
+		}
+		return material{}, fmt.Errorf("query material: %w", err)
+	}
+	return m, nil
+}
+
+func (st *sqlStore) createMaterial(m material) (int64, error) {
+	var id int64
+	err := st.q().QueryRow(`
+		INSERT INTO materials (name, cost_per_kg, notes, active)
+		VALUES (?, ?, ?, ?)
+		RETURNING id
+	`, m.Name, m.CostPerKg, m.Notes, m.Active).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("insert material: %w", err)
+	}
+	return id, nil
+}
+
+func (st *sqlStore) updateMaterial(id int64, m material) (float64, bool, error) {
+	var previousCost float64
+	err := st.q().QueryRow(`SELECT cost_per_kg FROM materials WHERE id = ?`, id).Scan(&previousCost)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("query material: %w", err)
+	}
+
+	result, err := st.q().Exec(`
+		UPDATE materials
+		SET
+			name = ?,
+			cost_per_kg = ?,
+			notes = ?,
+			active = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, m.Name, m.CostPerKg, m.Notes, m.Active, id)
+	if err != nil {
+		return 0, false, fmt.Errorf("update material: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, false, fmt.Errorf("update material: %w", err)
+	}
+	return previousCost, affected > 0, nil
+}
+
+func (st *sqlStore) listShippingRates() ([]shippingRate, error) {
+	rows, err := st.q().Query(`
+		SELECT id, scope, country, COALESCE(city, ''), flat_cost, COALESCE(notes, ''), active
+		FROM shipping_rates
+		ORDER BY id DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query shipping rates: %w", err)
+	}
+	defer rows.Close()
+
+	shippingRates := make([]shippingRate, 0)
+	for rows.Next() {
+		var rate shippingRate
+		if err := rows.Scan(&rate.ID, &rate.Scope, &rate.Country, &rate.City, &rate.FlatCost, &rate.Notes, &rate.Active); err != nil {
+			return nil, fmt.Errorf("scan shipping rate: %w", err)
+		}
+		shippingRates = append(shippingRates, rate)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate shipping rates: %w", err)
+	}
+
+	return shippingRates, nil
+}
+
+func (st *sqlStore) listActiveShippingRates() ([]shippingRate, error) {
+	rows, err := st.q().Query(`
+		SELECT id, scope, country, COALESCE(city, ''), flat_cost, COALESCE(notes, ''), active
+		FROM shipping_rates
+		WHERE active = TRUE
+		ORDER BY id DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query active shipping rates: %w", err)
+	}
+	defer rows.Close()
+
+	shippingRates := make([]shippingRate, 0)
+	for rows.Next() {
+		var rate shippingRate
+		if err := rows.Scan(&rate.ID, &rate.Scope, &rate.Country, &rate.City, &rate.FlatCost, &rate.Notes, &rate.Active); err != nil {
+			return nil, fmt.Errorf("scan active shipping rate: %w", err)
+		}
+		shippingRates = append(shippingRates, rate)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate active shipping rates: %w", err)
+	}
+
+	return shippingRates, nil
+}
+
+func (st *sqlStore) getOptionalActiveShippingCost(id int64) (float64, error) {
+	if id == 0 {
+		return 0, nil
+	}
+
+	var cost float64
+	err := st.q().QueryRow(`SELECT flat_cost FROM shipping_rates WHERE id = ? AND active = TRUE`, id).Scan(&cost)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("shipping no encontrado o inactivo")
+		}
+		return 0, fmt.Errorf("query shipping: %w", err)
+	}
+
+	return cost, nil
+}
+
+func (st *sqlStore) createShippingRate(rate shippingRate) (int64, error) {
+	var id int64
+	err := st.q().QueryRow(`
+		INSERT INTO shipping_rates (scope, country, city, flat_cost, notes, active)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, rate.Scope, rate.Country, rate.City, rate.FlatCost, rate.Notes, rate.Active).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("insert shipping rate: %w", err)
+	}
+	return id, nil
+}
+
+// updateShippingRate reports false when no shipping rate has the given id.
+func (st *sqlStore) updateShippingRate(id int64, rate shippingRate) (bool, error) {
+	result, err := st.q().Exec(`
+		UPDATE shipping_rates
+		SET
+			scope = ?,
+			country = ?,
+			city = ?,
+			flat_cost = ?,
+			notes = ?,
+			active = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, rate.Scope, rate.Country, rate.City, rate.FlatCost, rate.Notes, rate.Active, id)
+	if err != nil {
+		return false, fmt.Errorf("update shipping rate: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("update shipping rate: %w", err)
+	}
+	return affected > 0, nil
+}
+
+func (st *sqlStore) listPackagingRates() ([]packagingRate, error) {
+	rows, err := st.q().Query(`
+		SELECT id, name, flat_cost, COALESCE(notes, ''), active
+		FROM packaging_rates
+		ORDER BY id DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query packaging rates: %w", err)
+	}
+	defer rows.Close()
+
+	packagingRates := make([]packagingRate, 0)
+	for rows.Next() {
+		var rate packagingRate
+		if err := rows.Scan(&rate.ID, &rate.Name, &rate.FlatCost, &rate.Notes, &rate.Active); err != nil {
+			return nil, fmt.Errorf("scan packaging rate: %w", err)
+		}
+		packagingRates = append(packagingRates, rate)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate packaging rates: %w", err)
+	}
+
+	return packagingRates, nil
+}
+
+func (st *sqlStore) listActivePackagingRates() ([]packagingRate, error) {
+	rows, err := st.q().Query(`
+		SELECT id, name, flat_cost, COALESCE(notes, ''), active
+		FROM packaging_rates
+		WHERE active = TRUE
+		ORDER BY id DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query active packaging rates: %w", err)
+	}
+	defer rows.Close()
+
+	packagingRates := make([]packagingRate, 0)
+	for rows.Next() {
+		var rate packagingRate
+		if err := rows.Scan(&rate.ID, &rate.Name, &rate.FlatCost, &rate.Notes, &rate.Active); err != nil {
+			return nil, fmt.Errorf("scan active packaging rate: %w", err)
+		}
+		packagingRates = append(packagingRates, rate)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate active packaging rates: %w", err)
+	}
+
+	return packagingRates, nil
+}
+
+func (st *sqlStore) getOptionalActivePackagingCost(id int64) (float64, error) {
+	if id == 0 {
+		return 0, nil
+	}
+
+	var cost float64
+	err := st.q().QueryRow(`SELECT flat_cost FROM packaging_rates WHERE id = ? AND active = TRUE`, id).Scan(&cost)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("packaging no encontrado o inactivo")
+		}
+		return 0, fmt.Errorf("query packaging: %w", err)
+	}
+
+	return cost, nil
+}
+
+func (st *sqlStore) createPackagingRate(rate packagingRate) (int64, error) {
+	var id int64
+	err := st.q().QueryRow(`
+		INSERT INTO packaging_rates (name, flat_cost, notes, active)
+		VALUES (?, ?, ?, ?)
+		RETURNING id
+	`, rate.Name, rate.FlatCost, rate.Notes, rate.Active).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("insert packaging rate: %w", err)
+	}
+	return id, nil
+}
+
+// updatePackagingRate reports false when no packaging rate has the given id.
+func (st *sqlStore) updatePackagingRate(id int64, rate packagingRate) (bool, error) {
+	result, err := st.q().Exec(`
+		UPDATE packaging_rates
+		SET
+			name = ?,
+			flat_cost = ?,
+			notes = ?,
+			active = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, rate.Name, rate.FlatCost, rate.Notes, rate.Active, id)
+	if err != nil {
+		return false, fmt.Errorf("update packaging rate: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("update packaging rate: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// saveQuote stores the calculation together with a snapshot of the tax rules
+// that were applied, so later rule changes don't alter saved quotes.
+func (st *sqlStore) saveQuote(calc quoteCalculation) (int64, error) {
+	totalsJSON, err := json.Marshal(calc.Result.Totals)
+	if err != nil {
+		return 0, fmt.Errorf("encode quote totals: %w", err)
+	}
+	breakdownJSON, err := json.Marshal(calc.Result.Breakdown)
+	if err != nil {
+		return 0, fmt.Errorf("encode quote breakdown: %w", err)
+	}
+	taxRules := calc.TaxRules
+	if taxRules == nil {
+		taxRules = []pricing.TaxRule{}
+	}
+	taxRulesJSON, err := json.Marshal(taxRules)
+	if err != nil {
+		return 0, fmt.Errorf("encode quote tax rules: %w", err)
+	}
+
+	taxPercentSnapshot := 0.0
+	for _, rule := range taxRules {
+		if !rule.Withholding && rule.Base == pricing.TaxBaseNet {
+			taxPercentSnapshot += rule.Percent
+		}
+	}
+
+	values := calc.Values
+	var quoteID int64
+	err = st.inTx(func(tx *sqlStore) error {
+		err := tx.q().QueryRow(`
+			INSERT INTO quotes (
+				title,
+				notes,
+				waste_percent,
+				margin_percent,
+				tax_enabled,
+				tax_percent_snapshot,
+				totals_json,
+				breakdown_json,
+				customer_type,
+				tax_rules_json,
+				shipping_rate_id,
+				packaging_rate_id,
+				price_mode,
+				final_price,
+				currency,
+				exchange_rate,
+				customer_name,
+				valid_until,
+				terms
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id
+		`,
+			values.Title,
+			values.Notes,
+			values.WastePercent,
+			values.MarginPercent,
+			values.TaxEnabled,
+			taxPercentSnapshot,
+			string(totalsJSON),
+			string(breakdownJSON),
+			values.CustomerType,
+			string(taxRulesJSON),
+			nullableID(values.ShippingID),
+			nullableID(values.PackagingID),
+			values.PriceMode,
+			sql.NullFloat64{Float64: values.FinalPrice, Valid: values.PriceMode == priceModeInclusive},
+			calc.Currency,
+			calc.ExchangeRate,
+			values.CustomerName,
+			calc.ValidUntil,
+			calc.Terms,
+		).Scan(&quoteID)
+		if err != nil {
+			return fmt.Errorf("insert quote: %w", err)
+		}
+
+		_, err = tx.q().Exec(`
+			INSERT INTO quote_items (quote_id, material_id, grams, print_minutes, labor_minutes, quantity)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, quoteID, values.MaterialID, values.Grams, values.PrintMinutes, values.LaborMinutes, values.Quantity)
+		if err != nil {
+			return fmt.Errorf("insert quote item: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return quoteID, nil
+}
+
+func (st *sqlStore) getQuote(id int64) (storedQuote, error) {
+	var q storedQuote
+	var totalsJSON, breakdownJSON, taxRulesJSON string
+	var decidedAt sql.NullString
+	err := st.q().QueryRow(`
+		SELECT
+			id,
+			created_at,
+			COALESCE(title, ''),
+			COALESCE(notes, ''),
+			COALESCE(customer_name, ''),
+			COALESCE(valid_until, ''),
+			COALESCE(terms, ''),
+			customer_type,
+			price_mode,
+			COALESCE(final_price, 0),
+			currency,
+			exchange_rate,
+			waste_percent,
+			margin_percent,
+			tax_enabled,
+			tax_rules_json,
+			totals_json,
+			breakdown_json,
+			COALESCE(public_nonce, ''),
+			COALESCE(decision, ''),
+			decided_at,
+			COALESCE(decision_ip, ''),
+			COALESCE(decision_comment, '')
+		FROM quotes
+		WHERE id = ?
+	`, id).Scan(
+		&q.ID,
+		&q.CreatedAt,
+		&q.Title,
+		&q.Notes,
+		&q.CustomerName,
+		&q.ValidUntil,
+		&q.Terms,
+		&q.CustomerType,
+		&q.PriceMode,
+		&q.FinalPrice,
+		&q.Currency,
+		&q.ExchangeRate,
+		&q.WastePercent,
+		&q.MarginPercent,
+		&q.TaxEnabled,
+		&taxRulesJSON,
+		&totalsJSON,
+		&breakdownJSON,
+		&q.PublicNonce,
+		&q.Decision,
+		&decidedAt,
+		&q.DecisionIP,
+		&q.DecisionComment,
+	)
+	if err != nil {
+		return storedQuote{}, err
+	}
+	q.CreatedAt = storedTime(q.CreatedAt)
+	if decidedAt.Valid {
+		q.DecidedAt = storedTime(decidedAt.String)
+	}
+
+	if err := json.Unmarshal([]byte(taxRulesJSON), &q.TaxRules); err != nil {
+		return storedQuote{}, fmt.Errorf("decode quote tax rules: %w", err)
+	}
+	if err := json.Unmarshal([]byte(totalsJSON), &q.Result.Totals); err != nil {
+		return storedQuote{}, fmt.Errorf("decode quote totals: %w", err)
+	}
+	if err := json.Unmarshal([]byte(breakdownJSON), &q.Result.Breakdown); err != nil {
+		return storedQuote{}, fmt.Errorf("decode quote breakdown: %w", err)
+	}
+
+	rows, err := st.q().Query(`
+		SELECT m.name, qi.grams, qi.print_minutes, qi.labor_minutes, qi.quantity
+		FROM quote_items qi
+		JOIN materials m ON m.id = qi.material_id
+		WHERE qi.quote_id = ?
+		ORDER BY qi.id ASC
+	`, id)
+	if err != nil {
+		return storedQuote{}, fmt.Errorf("query quote items: %w", err)
+	}
+	defer rows.Close()
+
+	q.Items = make([]storedQuoteItem, 0)
+	for rows.Next() {
+		var item storedQuoteItem
+		if err := rows.Scan(&item.MaterialName, &item.Grams, &item.PrintMinutes, &item.LaborMinutes, &item.Quantity); err != nil {
+			return storedQuote{}, fmt.Errorf("scan quote item: %w", err)
+		}
+		q.Items = append(q.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return storedQuote{}, fmt.Errorf("iterate quote items: %w", err)
+	}
+
+	return q, nil
+}
+
+// getQuoteFormValues rebuilds the calculator inputs of a saved quote. A
+// target price was already solved into the stored margin, so it is not
+// needed again.
+func (st *sqlStore) getQuoteFormValues(id int64) (quoteFormValues, error) {
+	var values quoteFormValues
+	err := st.q().QueryRow(`
+		SELECT
+			qi.material_id,
+			COALESCE(q.shipping_rate_id, 0),
+			COALESCE(q.packaging_rate_id, 0),
+			qi.grams,
+			qi.print_minutes,
+			qi.labor_minutes,
+			qi.quantity,
+			q.waste_percent,
+			q.margin_percent,
+			q.tax_enabled,
+			q.customer_type,
+			q.price_mode,
+			COALESCE(q.final_price, 0),
+			q.currency,
+			COALESCE(q.customer_name, ''),
+			COALESCE(q.title, ''),
+			COALESCE(q.notes, '')
+		FROM quotes q
+		JOIN quote_items qi ON qi.quote_id = q.id
+		WHERE q.id = ?
+		ORDER BY qi.id ASC
+		LIMIT 1
+	`, id).Scan(
+		&values.MaterialID,
+		&values.ShippingID,
+		&values.PackagingID,
+		&values.Grams,
+		&values.PrintMinutes,
+		&values.LaborMinutes,
+		&values.Quantity,
+		&values.WastePercent,
+		&values.MarginPercent,
+		&values.TaxEnabled,
+		&values.CustomerType,
+		&values.PriceMode,
+		&values.FinalPrice,
+		&values.Currency,
+		&values.CustomerName,
+		&values.Title,
+		&values.Notes,
+	)
+	if err != nil {
+		return quoteFormValues{}, fmt.Errorf("query quote %d inputs: %w", id, err)
+	}
+	return values, nil
+}
+
+func (st *sqlStore) listQuotes(query string) ([]quoteListItem, error) {
+	search := "%" + query + "%"
+	rows, err := st.q().Query(`
+		SELECT
+			id,
+			created_at,
+			COALESCE(title, ''),
+			currency,
+			totals_json
+		FROM quotes
+		WHERE `+st.quoteSearchFilter()+`
+		ORDER BY `+st.quoteOrder()+`
+	`, query, search, search)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quotes := make([]quoteListItem, 0)
+	for rows.Next() {
+		var item quoteListItem
+		var totalsJSON string
+		if err := rows.Scan(&item.ID, &item.CreatedAt, &item.Title, &item.Currency, &totalsJSON); err != nil {
+			return nil, err
+		}
+		item.CreatedAt = storedTime(item.CreatedAt)
+		item.Total = extractTotalFromJSON(totalsJSON)
+		quotes = append(quotes, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return quotes, nil
+}
+
+func (st *sqlStore) listQuoteExportRows(query string) ([]quoteExportRow, error) {
+	search := "%" + query + "%"
+	rows, err := st.q().Query(`
+		SELECT
+			id,
+			created_at,
+			COALESCE(title, ''),
+			COALESCE(customer_name, ''),
+			customer_type,
+			price_mode,
+			currency,
+			exchange_rate,
+			COALESCE(decision, ''),
+			totals_json,
+			breakdown_json
+		FROM quotes
+		WHERE `+st.quoteSearchFilter()+`
+		ORDER BY `+st.quoteOrder()+`
+	`, query, search, search)
+	if err != nil {
+		return nil, fmt.Errorf("query quotes: %w", err)
+	}
+	defer rows.Close()
+
+	quotes := make([]quoteExportRow, 0)
+	for rows.Next() {
+		var (
+			q                         quoteExportRow
+			totalsJSON, breakdownJSON string
+		)
+		if err := rows.Scan(&q.ID, &q.CreatedAt, &q.Title, &q.CustomerName, &q.CustomerType, &q.PriceMode, &q.Currency, &q.ExchangeRate, &q.Decision, &totalsJSON, &breakdownJSON); err != nil {
+			return nil, fmt.Errorf("scan quote: %w", err)
+		}
+		q.CreatedAt = storedTime(q.CreatedAt)
+		if err := json.Unmarshal([]byte(totalsJSON), &q.Result.Totals); err != nil {
+			return nil, fmt.Errorf("decode quote %d totals: %w", q.ID, err)
+		}
+		if err := json.Unmarshal([]byte(breakdownJSON), &q.Result.Breakdown); err != nil {
+			return nil, fmt.Errorf("decode quote %d breakdown: %w", q.ID, err)
+		}
+		quotes = append(quotes, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate quotes: %w", err)
+	}
+
+	return quotes, nil
+}
+
+func (st *sqlStore) setQuotePublicNonce(id int64, nonce string) (bool, error) {
+	result, err := st.q().Exec(`UPDATE quotes SET public_nonce = ? WHERE id = ?`, nonce, id)
+	if err != nil {
+		return false, fmt.Errorf("update quote nonce: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("update quote nonce: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// recordQuoteDecision stores the customer's answer. A quote can only be
+// decided once; later attempts return errQuoteAlreadyDecided.
+func (st *sqlStore) recordQuoteDecision(id int64, decision, ip, comment string) error {
+	result, err := st.q().Exec(`
+		UPDATE quotes
+		SET
+			decision = ?,
+			decided_at = CURRENT_TIMESTAMP,
+			decision_ip = ?,
+			decision_comment = ?
+		WHERE id = ? AND decision IS NULL
+	`, decision, ip, comment, id)
+	if err != nil {
+		return fmt.Errorf("update quote decision: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("update quote decision: %w", err)
+	}
+	if affected == 0 {
+		return errQuoteAlreadyDecided
+	}
+
+	return nil
+}
+
+func (st *sqlStore) listUsers() ([]user, error) {
+	rows, err := st.q().Query(`
+		SELECT id, email, role, active, created_at, totp_enabled
+		FROM users
+		ORDER BY email ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]user, 0)
+	for rows.Next() {
+		var u user
+		if err := rows.Scan(&u.ID, &u.Email, &u.Role, &u.Active, &u.CreatedAt, &u.TOTPEnabled); err != nil {
+			return nil, fmt.Errorf("scan user: %w", err)
+		}
+		u.CreatedAt = storedTime(u.CreatedAt)
+		users = append(users, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate users: %w", err)
+	}
+
+	return users, nil
+}
+
+func (st *sqlStore) userExists(email string) (bool, error) {
+	var exists bool
+	if err := st.q().QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE email = ?)`, email).Scan(&exists); err != nil {
+		return false, fmt.Errorf("check user existence: %w", err)
+	}
+	return exists, nil
+}
+
+func (st *sqlStore) createUser(email, passwordHash, role string) (int64, error) {
+	var id int64
+	err := st.q().QueryRow(`
+		INSERT INTO users (email, password_hash, role, active)
+		VALUES (?, ?, ?, TRUE)
+		RETURNING id
+	`, email, passwordHash, role).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("insert user: %w", err)
+	}
+	return id, nil
+}
+
+func (st *sqlStore) updateUserAccess(id int64, role string, active bool) (bool, error) {
+	result, err := st.q().Exec(`UPDATE users SET role = ?, active = ? WHERE id = ?`, role, active, id)
+	if err != nil {
+		return false, fmt.Errorf("update user: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("update user: %w", err)
+	}
+	return affected > 0, nil
+}
+
+func (st *sqlStore) getUserEmail(id int64) (string, error) {
+	var email string
+	if err := st.q().QueryRow(`SELECT email FROM users WHERE id = ?`, id).Scan(&email); err != nil {
+		return "", err
+	}
+	return email, nil
+}
+
+func (st *sqlStore) getUserIDByEmail(email string) (int64, error) {
+	var id int64
+	if err := st.q().QueryRow(`SELECT id FROM users WHERE email = ?`, email).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (st *sqlStore) getActiveUserCredentials(email string) (int64, string, error) {
+	var (
+		id           int64
+		passwordHash string
+	)
+	err := st.q().QueryRow(`SELECT id, password_hash FROM users WHERE email = ? AND active = TRUE`, email).Scan(&id, &passwordHash)
+	if err != nil {
+		return 0, "", err
+	}
+	return id, passwordHash, nil
+}
+
+func (st *sqlStore) getActiveUserIDByEmail(email string) (int64, error) {
+	var id int64
+	if err := st.q().QueryRow(`SELECT id FROM users WHERE email = ? AND active = TRUE`, email).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (st *sqlStore) getUserActive(id int64) (bool, error) {
+	var active bool
+	if err := st.q().QueryRow(`SELECT active FROM users WHERE id = ?`, id).Scan(&active); err != nil {
+		return false, err
+	}
+	return active, nil
+}
+
+func (st *sqlStore) updateUserPasswordHash(id int64, passwordHash string) error {
+	if _, err := st.q().Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, passwordHash, id); err != nil {
+		return fmt.Errorf("update password hash: %w", err)
+	}
+	return nil
+}
+
+func (st *sqlStore) resetPasswordWithToken(tokenID, userID int64, passwordHash string) error {
+	return st.inTx(func(tx *sqlStore) error {
+		result, err := tx.q().Exec(`UPDATE password_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = ? AND used_at IS NULL`, tokenID)
+		if err != nil {
+			return fmt.Errorf("mark password token used: %w", err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("mark password token used: %w", err)
+		}
+		if affected == 0 {
+			return errPasswordTokenUsed
+		}
+
+		if err := tx.updateUserPasswordHash(userID, passwordHash); err != nil {
+			return err
+		}
+		if _, err := tx.q().Exec(`DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("delete user sessions: %w", err)
+		}
+		return nil
+	})
+}
+
+func (st *sqlStore) getUserTOTP(id int64) (totpState, error) {
+	state := totpState{UserID: id}
+	err := st.q().QueryRow(`
+		SELECT email, role, COALESCE(totp_secret, ''), totp_enabled, totp_last_counter
+		FROM users
+		WHERE id = ?
+	`, id).Scan(&state.Email, &state.Role, &state.Secret, &state.Enabled, &state.LastCounter)
+	if err != nil {
+		return totpState{}, fmt.Errorf("query totp state: %w", err)
+	}
+	return state, nil
+}
+
+func (st *sqlStore) setUserTOTPSecret(id int64, secret string) error {
+	if _, err := st.q().Exec(`UPDATE users SET totp_secret = ? WHERE id = ? AND totp_enabled = FALSE`, secret, id); err != nil {
+		return fmt.Errorf("store totp secret: %w", err)
+	}
+	return nil
+}
+
+func (st *sqlStore) enableUserTOTP(id, counter int64, recoveryCodeHashes []string) error {
+	return st.inTx(func(tx *sqlStore) error {
+		if _, err := tx.q().Exec(`UPDATE users SET totp_enabled = TRUE, totp_last_counter = ? WHERE id = ?`, counter, id); err != nil {
+			return fmt.Errorf("enable totp: %w", err)
+		}
+		if _, err := tx.q().Exec(`DELETE FROM user_recovery_codes WHERE user_id = ?`, id); err != nil {
+			return fmt.Errorf("delete recovery codes: %w", err)
+		}
+		for _, hash := range recoveryCodeHashes {
+			if _, err := tx.q().Exec(`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?, ?)`, id, hash); err != nil {
+				return fmt.Errorf("insert recovery code: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (st *sqlStore) resetUserTOTP(id int64) error {
+	return st.inTx(func(tx *sqlStore) error {
+		if _, err := tx.q().Exec(`UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_counter = 0 WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("reset totp: %w", err)
+		}
+		if _, err := tx.q().Exec(`DELETE FROM user_recovery_codes WHERE user_id = ?`, id); err != nil {
+			return fmt.Errorf("delete recovery codes: %w", err)
+		}
+		return nil
+	})
+}
+
+func (st *sqlStore) advanceUserTOTPCounter(id, counter int64) (bool, error) {
+	result, err := st.q().Exec(`
+		UPDATE users SET totp_last_counter = ?
+		WHERE id = ? AND totp_last_counter < ?
+	`, counter, id, counter)
+	if err != nil {
+		return false, fmt.Errorf("update totp counter: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("update totp counter: %w", err)
+	}
+	return affected == 1, nil
+}
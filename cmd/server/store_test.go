@@ -0,0 +1,237 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestSQLStore runs against whichever database the suite targets; see
+// TestMain for running it on PostgreSQL.
+func TestSQLStore(t *testing.T) {
+	srv := &server{db: newMigratedTestDB(t)}
+	st := srv.store()
+
+	if err := st.ensureRateConfig(); err != nil {
+		t.Fatalf("ensureRateConfig returned error: %v", err)
+	}
+	if err := st.updateRateConfig(rateConfig{MachineHourlyRate: 5000, LaborPerMinute: 100, TaxPercent: 19, Currency: "USD", QuoteValidityDays: 30, QuoteTerms: "50% anticipo"}); err != nil {
+		t.Fatalf("updateRateConfig returned error: %v", err)
+	}
+	rates, err := st.getRateConfig()
+	if err != nil {
+		t.Fatalf("getRateConfig returned error: %v", err)
+	}
+	if rates.MachineHourlyRate != 5000 || rates.Currency != "USD" || rates.QuoteValidityDays != 30 || rates.QuoteTerms != "50% anticipo" {
+		t.Fatalf("unexpected rate config %+v", rates)
+	}
+
+	materialID, err := st.createMaterial(material{Name: "PLA", CostPerKg: 80000.5, Active: true})
+	if err != nil {
+		t.Fatalf("createMaterial returned error: %v", err)
+	}
+	previous, found, err := st.updateMaterial(materialID, material{Name: "PLA+", CostPerKg: 90000, Active: true})
+	if err != nil || !found || previous != 80000.5 {
+		t.Fatalf("updateMaterial = %v, %v, %v", previous, found, err)
+	}
+	if _, found, err := st.updateMaterial(materialID+100, material{Name: "x", Active: true}); err != nil || found {
+		t.Fatalf("updateMaterial on a missing id = %v, %v", found, err)
+	}
+	if m, err := st.getActiveMaterialByID(materialID); err != nil || m.Name != "PLA+" || m.CostPerKg != 90000 {
+		t.Fatalf("getActiveMaterialByID = %+v, %v", m, err)
+	}
+
+	shippingID, err := st.createShippingRate(shippingRate{Scope: "national", Country: "CO", City: "Bogotá", FlatCost: 12000, Active: true})
+	if err != nil {
+		t.Fatalf("createShippingRate returned error: %v", err)
+	}
+	if cost, err := st.getOptionalActiveShippingCost(shippingID); err != nil || cost != 12000 {
+		t.Fatalf("getOptionalActiveShippingCost = %v, %v", cost, err)
+	}
+	if found, err := st.updateShippingRate(shippingID, shippingRate{Scope: "national", Country: "CO", FlatCost: 15000}); err != nil || !found {
+		t.Fatalf("updateShippingRate = %v, %v", found, err)
+	}
+	if _, err := st.getOptionalActiveShippingCost(shippingID); err == nil {
+		t.Fatal("expected an inactive shipping rate to be rejected")
+	}
+
+	packagingID, err := st.createPackagingRate(packagingRate{Name: "Caja", FlatCost: 3000, Active: true})
+	if err != nil {
+		t.Fatalf("createPackagingRate returned error: %v", err)
+	}
+	if active, err := st.listActivePackagingRates(); err != nil || len(active) != 1 || active[0].ID != packagingID {
+		t.Fatalf("listActivePackagingRates = %+v, %v", active, err)
+	}
+
+	calc, err := srv.calculateQuote(quoteFormValues{
+		MaterialID:   materialID,
+		PackagingID:  packagingID,
+		Grams:        100,
+		PrintMinutes: 60,
+		Quantity:     2.5,
+		TaxEnabled:   true,
+		CustomerType: customerTypeNatural,
+		Title:        "Llaveros",
+		Notes:        "Cliente frecuente",
+	})
+	if err != nil {
+		t.Fatalf("calculateQuote returned error: %v", err)
+	}
+	quoteID, err := st.saveQuote(calc)
+	if err != nil {
+		t.Fatalf("saveQuote returned error: %v", err)
+	}
+
+	quote, err := st.getQuote(quoteID)
+	if err != nil {
+		t.Fatalf("getQuote returned error: %v", err)
+	}
+	if quote.Title != "Llaveros" || len(quote.Items) != 1 || quote.Items[0].Quantity != 2.5 || quote.Result.Totals != calc.Result.Totals {
+		t.Fatalf("unexpected quote %+v", quote)
+	}
+	if _, err := time.Parse(time.DateTime, quote.CreatedAt); err != nil {
+		t.Fatalf("created_at %q is not in the stored layout: %v", quote.CreatedAt, err)
+	}
+	values, err := st.getQuoteFormValues(quoteID)
+	if err != nil || values.PackagingID != packagingID || values.Quantity != 2.5 {
+		t.Fatalf("getQuoteFormValues = %+v, %v", values, err)
+	}
+
+	for _, search := range []string{"", "llave", "FRECUENTE"} {
+		quotes, err := st.listQuotes(search)
+		if err != nil || len(quotes) != 1 || quotes[0].ID != quoteID {
+			t.Fatalf("listQuotes(%q) = %+v, %v", search, quotes, err)
+		}
+	}
+	if rows, err := st.listQuoteExportRows("casa"); err != nil || len(rows) != 0 {
+		t.Fatalf("listQuoteExportRows = %+v, %v", rows, err)
+	}
+
+	if found, err := st.setQuotePublicNonce(quoteID, "nonce"); err != nil || !found {
+		t.Fatalf("setQuotePublicNonce = %v, %v", found, err)
+	}
+	if err := st.recordQuoteDecision(quoteID, quoteDecisionAccepted, "203.0.113.5", ""); err != nil {
+		t.Fatalf("recordQuoteDecision returned error: %v", err)
+	}
+	if err := st.recordQuoteDecision(quoteID, quoteDecisionRejected, "203.0.113.5", ""); !errors.Is(err, errQuoteAlreadyDecided) {
+		t.Fatalf("expected errQuoteAlreadyDecided, got %v", err)
+	}
+	quote, err = st.getQuote(quoteID)
+	if err != nil || quote.PublicNonce != "nonce" || quote.Decision != quoteDecisionAccepted {
+		t.Fatalf("unexpected decided quote %+v, %v", quote, err)
+	}
+	if _, err := time.Parse(time.DateTime, quote.DecidedAt); err != nil {
+		t.Fatalf("decided_at %q is not in the stored layout: %v", quote.DecidedAt, err)
+	}
+
+	passwordHash, err := hashPassword("una-clave-segura")
+	if err != nil {
+		t.Fatalf("hashPassword returned error: %v", err)
+	}
+	userID, err := st.createUser("ventas@example.com", passwordHash, roleSales)
+	if err != nil {
+		t.Fatalf("createUser returned error: %v", err)
+	}
+	if exists, err := st.userExists("ventas@example.com"); err != nil || !exists {
+		t.Fatalf("userExists = %v, %v", exists, err)
+	}
+	if found, err := st.updateUserAccess(userID, roleViewer, false); err != nil || !found {
+		t.Fatalf("updateUserAccess = %v, %v", found, err)
+	}
+	if email, err := st.getUserEmail(userID); err != nil || email != "ventas@example.com" {
+		t.Fatalf("getUserEmail = %q, %v", email, err)
+	}
+	if _, err := st.getUserIDByEmail("nadie@example.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows, got %v", err)
+	}
+	users, err := st.listUsers()
+	if err != nil || len(users) != 1 || users[0].Role != roleViewer || users[0].Active {
+		t.Fatalf("listUsers = %+v, %v", users, err)
+	}
+}
+
+func TestSQLStoreUserCredentials(t *testing.T) {
+	database := newMigratedTestDB(t)
+	srv := &server{db: database}
+	st := srv.store()
+
+	userID, err := st.createUser("ventas@example.com", argon2idPrefix+"hash-1", roleSales)
+	if err != nil {
+		t.Fatalf("createUser returned error: %v", err)
+	}
+	if id, hash, err := st.getActiveUserCredentials("ventas@example.com"); err != nil || id != userID || hash != argon2idPrefix+"hash-1" {
+		t.Fatalf("getActiveUserCredentials = %d, %q, %v", id, hash, err)
+	}
+	if err := st.updateUserPasswordHash(userID, argon2idPrefix+"hash-2"); err != nil {
+		t.Fatalf("updateUserPasswordHash returned error: %v", err)
+	}
+	if _, hash, err := st.getActiveUserCredentials("ventas@example.com"); err != nil || hash != argon2idPrefix+"hash-2" {
+		t.Fatalf("getActiveUserCredentials after update = %q, %v", hash, err)
+	}
+
+	var tokenID int64
+	err = database.QueryRow(`
+		INSERT INTO password_tokens (user_id, token_hash, purpose, expires_at)
+		VALUES (?, 'token-hash', ?, ?)
+		RETURNING id
+	`, userID, passwordTokenReset, sqliteTime(time.Now().Add(time.Hour))).Scan(&tokenID)
+	if err != nil {
+		t.Fatalf("insert password token: %v", err)
+	}
+	if err := st.resetPasswordWithToken(tokenID, userID, argon2idPrefix+"hash-3"); err != nil {
+		t.Fatalf("resetPasswordWithToken returned error: %v", err)
+	}
+	if err := st.resetPasswordWithToken(tokenID, userID, argon2idPrefix+"hash-4"); !errors.Is(err, errPasswordTokenUsed) {
+		t.Fatalf("expected errPasswordTokenUsed, got %v", err)
+	}
+	if _, hash, err := st.getActiveUserCredentials("ventas@example.com"); err != nil || hash != argon2idPrefix+"hash-3" {
+		t.Fatalf("a used token must not change the password, got %q, %v", hash, err)
+	}
+
+	if err := st.setUserTOTPSecret(userID, "SECRET"); err != nil {
+		t.Fatalf("setUserTOTPSecret returned error: %v", err)
+	}
+	if err := st.enableUserTOTP(userID, 10, []string{"code-a", "code-b"}); err != nil {
+		t.Fatalf("enableUserTOTP returned error: %v", err)
+	}
+	if err := st.setUserTOTPSecret(userID, "OTHER"); err != nil {
+		t.Fatalf("setUserTOTPSecret returned error: %v", err)
+	}
+	state, err := st.getUserTOTP(userID)
+	if err != nil || !state.Enabled || state.Secret != "SECRET" || state.LastCounter != 10 || state.Email != "ventas@example.com" {
+		t.Fatalf("getUserTOTP = %+v, %v", state, err)
+	}
+	if ok, err := st.advanceUserTOTPCounter(userID, 10); err != nil || ok {
+		t.Fatalf("a replayed step must be rejected, got %v, %v", ok, err)
+	}
+	if ok, err := st.advanceUserTOTPCounter(userID, 11); err != nil || !ok {
+		t.Fatalf("advanceUserTOTPCounter = %v, %v", ok, err)
+	}
+	if err := st.resetUserTOTP(userID); err != nil {
+		t.Fatalf("resetUserTOTP returned error: %v", err)
+	}
+	var codes int
+	if err := database.QueryRow(`SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = ?`, userID).Scan(&codes); err != nil {
+		t.Fatalf("count recovery codes: %v", err)
+	}
+	if state, err := st.getUserTOTP(userID); err != nil || state.Enabled || state.Secret != "" || codes != 0 {
+		t.Fatalf("after reset: %+v, %d codes, %v", state, codes, err)
+	}
+
+	if _, err := st.updateUserAccess(userID, roleSales, false); err != nil {
+		t.Fatalf("updateUserAccess returned error: %v", err)
+	}
+	if active, err := st.getUserActive(userID); err != nil || active {
+		t.Fatalf("getUserActive = %v, %v", active, err)
+	}
+	if _, _, err := st.getActiveUserCredentials("ventas@example.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected inactive users to have no credentials, got %v", err)
+	}
+	if _, err := st.getActiveUserIDByEmail("ventas@example.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows for an inactive user, got %v", err)
+	}
+	if _, err := st.getUserActive(userID + 100); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows for an unknown user, got %v", err)
+	}
+}
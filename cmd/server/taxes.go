@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Simplici0/o.works/internal/pricing"
+)
+
+const (
+	taxKindTax         = "tax"
+	taxKindWithholding = "withholding"
+
+	customerTypeAll      = "all"
+	customerTypeNatural  = "natural"
+	customerTypeJuridica = "juridica"
+)
+
+type taxRule struct {
+	ID           int64
+	Name         string
+	Kind         string
+	Percent      float64
+	Base         string
+	CustomerType string
+	Notes        string
+	Active       bool
+}
+
+type taxesViewData struct {
+	baseViewData
+	TaxRules []taxRule
+}
+
+func (t taxRule) pricingRule() pricing.TaxRule {
+	return pricing.TaxRule{
+		Name:        t.Name,
+		Percent:     t.Percent,
+		Base:        pricing.TaxBase(t.Base),
+		Withholding: t.Kind == taxKindWithholding,
+	}
+}
+
+func (s *server) handleAdminTaxesForm(w http.ResponseWriter, r *http.Request) {
+	rules, err := s.listTaxRules()
+	if err != nil {
+		serverError(w, r, "failed to load tax rules", err)
+		return
+	}
+
+	s.renderTemplate(w, r, "admin_taxes.html", taxesViewData{
+		baseViewData: baseViewData{
+			ErrorMessage:   r.URL.Query().Get("error"),
+			SuccessMessage: r.URL.Query().Get("success"),
+		},
+		TaxRules: rules,
+	})
+}
+
+func (s *server) handleAdminTaxesCreate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := parseTaxRuleForm(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/taxes?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO tax_rules (name, kind, percent, base, customer_type, notes, active)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, rule.Name, rule.Kind, rule.Percent, rule.Base, rule.CustomerType, rule.Notes, rule.Active)
+	if err != nil {
+		serverError(w, r, "failed to create tax rule", err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/taxes?success=Impuesto+creado+correctamente", http.StatusSeeOther)
+}
+
+func (s *server) handleAdminTaxesUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid tax rule id", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := parseTaxRuleForm(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/taxes?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE tax_rules
+		SET
+			name = ?,
+			kind = ?,
+			percent = ?,
+			base = ?,
+			customer_type = ?,
+			notes = ?,
+			active = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, rule.Name, rule.Kind, rule.Percent, rule.Base, rule.CustomerType, rule.Notes, rule.Active, id)
+	if err != nil {
+		serverError(w, r, "failed to update tax rule", err)
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		serverError(w, r, "failed to update tax rule", err)
+		return
+	}
+	if affected == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/taxes?success=Impuesto+actualizado+correctamente", http.StatusSeeOther)
+}
+
+func parseTaxRuleForm(r *http.Request) (taxRule, error) {
+	rule := taxRule{
+		Name:         strings.TrimSpace(r.FormValue("name")),
+		Kind:         strings.TrimSpace(r.FormValue("kind")),
+		Base:         strings.TrimSpace(r.FormValue("base")),
+		CustomerType: strings.TrimSpace(r.FormValue("customer_type")),
+		Notes:        strings.TrimSpace(r.FormValue("notes")),
+		Active:       r.FormValue("active") == "1",
+	}
+
+	if rule.Name == "" {
+		return rule, fmt.Errorf("name es requerido")
+	}
+	if rule.Kind != taxKindTax && rule.Kind != taxKindWithholding {
+		return rule, fmt.Errorf("kind debe ser tax o withholding")
+	}
+	if rule.Base != string(pricing.TaxBaseNet) && rule.Base != string(pricing.TaxBaseTax) {
+		return rule, fmt.Errorf("base debe ser net o tax")
+	}
+	if rule.Kind == taxKindTax && rule.Base == string(pricing.TaxBaseTax) {
+		return rule, fmt.Errorf("base tax solo aplica a retenciones")
+	}
+	if rule.CustomerType != customerTypeAll && !isValidCustomerType(rule.CustomerType) {
+		return rule, fmt.Errorf("customer_type debe ser all, natural o juridica")
+	}
+
+	var err error
+	rule.Percent, err = parsePercent(r.FormValue("percent"), "percent")
+	if err != nil {
+		return rule, err
+	}
+
+	return rule, nil
+}
+
+func isValidCustomerType(customerType string) bool {
+	return customerType == customerTypeNatural || customerType == customerTypeJuridica
+}
+
+func (s *server) listTaxRules() ([]taxRule, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, kind, percent, base, customer_type, COALESCE(notes, ''), active
+		FROM tax_rules
+		ORDER BY kind = 'withholding', id ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query tax rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := make([]taxRule, 0)
+	for rows.Next() {
+		var rule taxRule
+		if err := rows.Scan(&rule.ID, &rule.Name, &rule.Kind, &rule.Percent, &rule.Base, &rule.CustomerType, &rule.Notes, &rule.Active); err != nil {
+			return nil, fmt.Errorf("scan tax rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate tax rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+// listApplicableTaxRules returns the active rules for a customer type, additive
+// taxes first so withholdings over tax can see them.
+func (s *server) listApplicableTaxRules(customerType string) ([]pricing.TaxRule, error) {
+	rows, err := s.db.Query(`
+		SELECT name, kind, percent, base
+		FROM tax_rules
+		WHERE active = TRUE AND customer_type IN ('all', ?)
+		ORDER BY kind = 'withholding', id ASC
+	`, customerType)
+	if err != nil {
+		return nil, fmt.Errorf("query applicable tax rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := make([]pricing.TaxRule, 0)
+	for rows.Next() {
+		var rule taxRule
+		if err := rows.Scan(&rule.Name, &rule.Kind, &rule.Percent, &rule.Base); err != nil {
+			return nil, fmt.Errorf("scan applicable tax rule: %w", err)
+		}
+		rules = append(rules, rule.pricingRule())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate applicable tax rules: %w", err)
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestParseTaxRuleForm_Success(t *testing.T) {
+	form := url.Values{}
+	form.Set("name", "ReteIVA")
+	form.Set("kind", "withholding")
+	form.Set("percent", "15")
+	form.Set("base", "tax")
+	form.Set("customer_type", "juridica")
+	form.Set("active", "1")
+
+	req := httptest.NewRequest("POST", "/admin/taxes", nil)
+	req.Form = form
+
+	rule, err := parseTaxRuleForm(req)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if rule.Kind != taxKindWithholding || rule.Base != "tax" || rule.CustomerType != customerTypeJuridica || !rule.Active {
+		t.Fatalf("unexpected rule: %+v", rule)
+	}
+	if !rule.pricingRule().Withholding {
+		t.Fatalf("expected withholding pricing rule")
+	}
+}
+
+func TestParseTaxRuleForm_RejectsTaxOverTax(t *testing.T) {
+	form := url.Values{}
+	form.Set("name", "IVA")
+	form.Set("kind", "tax")
+	form.Set("percent", "19")
+	form.Set("base", "tax")
+	form.Set("customer_type", "all")
+
+	req := httptest.NewRequest("POST", "/admin/taxes", nil)
+	req.Form = form
+
+	if _, err := parseTaxRuleForm(req); err == nil {
+		t.Fatalf("expected validation error")
+	}
+}
+
+func TestParseTaxRuleForm_InvalidCustomerType(t *testing.T) {
+	form := url.Values{}
+	form.Set("name", "IVA")
+	form.Set("kind", "tax")
+	form.Set("percent", "19")
+	form.Set("base", "net")
+	form.Set("customer_type", "empresa")
+
+	req := httptest.NewRequest("POST", "/admin/taxes", nil)
+	req.Form = form
+
+	if _, err := parseTaxRuleForm(req); err == nil {
+		t.Fatalf("expected validation error")
+	}
+}
+
+func TestTaxRuleStorage(t *testing.T) {
+	srv := &server{db: newMigratedTestDB(t)}
+	r := chi.NewRouter()
+	r.Post("/admin/taxes", srv.handleAdminTaxesCreate)
+	r.Post("/admin/taxes/{id}", srv.handleAdminTaxesUpdate)
+
+	rec := postForm(r, "/admin/taxes", url.Values{
+		"name": {"Impoconsumo"}, "kind": {"tax"}, "percent": {"8"}, "base": {"net"},
+		"customer_type": {"natural"}, "active": {"1"},
+	})
+	if rec.Code != http.StatusSeeOther || !strings.Contains(rec.Header().Get("Location"), "success") {
+		t.Fatalf("create returned %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	rules, err := srv.listTaxRules()
+	if err != nil {
+		t.Fatalf("listTaxRules returned error: %v", err)
+	}
+	var created taxRule
+	for _, rule := range rules {
+		if rule.Name == "Impoconsumo" {
+			created = rule
+		}
+	}
+	if created.ID == 0 || created.Percent != 8 || !created.Active || rules[len(rules)-1].Kind != taxKindWithholding {
+		t.Fatalf("unexpected tax rules %+v", rules)
+	}
+
+	applicable, err := srv.listApplicableTaxRules(customerTypeNatural)
+	if err != nil || len(applicable) != 2 || applicable[1].Name != "Impoconsumo" {
+		t.Fatalf("listApplicableTaxRules = %+v, %v", applicable, err)
+	}
+
+	rec = postForm(r, fmt.Sprintf("/admin/taxes/%d", created.ID), url.Values{
+		"name": {"Impoconsumo"}, "kind": {"tax"}, "percent": {"4.5"}, "base": {"net"},
+		"customer_type": {"all"},
+	})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("update returned %d", rec.Code)
+	}
+	if applicable, err := srv.listApplicableTaxRules(customerTypeJuridica); err != nil || len(applicable) != 1 {
+		t.Fatalf("expected the deactivated rule to be skipped, got %+v, %v", applicable, err)
+	}
+	if rec := postForm(r, "/admin/taxes/9999", url.Values{
+		"name": {"x"}, "kind": {"tax"}, "percent": {"1"}, "base": {"net"}, "customer_type": {"all"},
+	}); rec.Code != http.StatusNotFound {
+		t.Fatalf("update of a missing rule returned %d", rec.Code)
+	}
+}
+
+// postForm sends a form POST through h.
+func postForm(h http.Handler, target string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddedTemplatesParse(t *testing.T) {
+	srv := &server{}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	pages, err := fs.Glob(srv.webFS(), "templates/*.html")
+	if err != nil || len(pages) == 0 {
+		t.Fatalf("expected embedded templates, got %v, %v", pages, err)
+	}
+	for _, page := range pages {
+		if _, err := template.New("layout.html").Funcs(srv.templateFuncs(r)).ParseFS(srv.webFS(),
+			"templates/layout.html",
+			"templates/quote_breakdown_partial.html",
+			page,
+		); err != nil {
+			t.Fatalf("parse %s: %v", filepath.Base(page), err)
+		}
+	}
+
+	if _, err := fs.ReadFile(srv.webFS(), "static/logo.svg"); err != nil {
+		t.Fatalf("read embedded logo: %v", err)
+	}
+}
+
+func TestRenderTemplateUsesEmbeddedFiles(t *testing.T) {
+	srv := &server{backupDir: t.TempDir(), backupKeep: 7}
+
+	rec := httptest.NewRecorder()
+	srv.handleAdminBackupsForm(rec, httptest.NewRequest(http.MethodGet, "/admin/backups?success=listo", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "No hay respaldos.") || !strings.Contains(rec.Body.String(), "listo") {
+		t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+}
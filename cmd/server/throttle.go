@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttlePolicy describes how failed logins for one key (an email or an IP)
+// are slowed down. The first FreeAttempts failures are not delayed; after that
+// each failure doubles the wait from BaseDelay up to MaxDelay, and LockoutAfter
+// failures lock the key for LockoutDuration.
+type throttlePolicy struct {
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutAfter    int
+	LockoutDuration time.Duration
+	// ResetAfter forgets a key once it has had no failures for this long.
+	ResetAfter time.Duration
+}
+
+var (
+	emailThrottlePolicy = throttlePolicy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: 15 * time.Minute,
+		ResetAfter:      time.Hour,
+	}
+	// IPs get more room because several users may share one address.
+	ipThrottlePolicy = throttlePolicy{
+		FreeAttempts:    10,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    50,
+		LockoutDuration: 15 * time.Minute,
+		ResetAfter:      time.Hour,
+	}
+)
+
+type throttleEntry struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// loginThrottle tracks failed logins in memory. State is lost on restart,
+// which is acceptable for a single-instance deployment.
+type loginThrottle struct {
+	mu      sync.Mutex
+	entries map[string]*throttleEntry
+	now     func() time.Time
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{entries: make(map[string]*throttleEntry), now: time.Now}
+}
+
+// allow reports whether a login for email from ip may be attempted now, and if
+// not, how long the caller has to wait.
+func (t *loginThrottle) allow(email, ip string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	var wait time.Duration
+	for _, key := range throttleKeys(email, ip) {
+		entry, ok := t.entries[key.name]
+		if !ok {
+			continue
+		}
+		if remaining := entry.blockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+
+	return wait, wait <= 0
+}
+
+func (t *loginThrottle) recordFailure(email, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.prune(now)
+
+	for _, key := range throttleKeys(email, ip) {
+		entry, ok := t.entries[key.name]
+		if !ok {
+			entry = &throttleEntry{}
+			t.entries[key.name] = entry
+		}
+		entry.failures++
+		entry.lastFailure = now
+		entry.blockedUntil = now.Add(key.policy.delay(entry.failures))
+	}
+}
+
+// recordSuccess clears the history for the email and IP of a successful login.
+func (t *loginThrottle) recordSuccess(email, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range throttleKeys(email, ip) {
+		delete(t.entries, key.name)
+	}
+}
+
+func (t *loginThrottle) prune(now time.Time) {
+	for name, entry := range t.entries {
+		policy := emailThrottlePolicy
+		if strings.HasPrefix(name, "ip:") {
+			policy = ipThrottlePolicy
+		}
+		if now.Sub(entry.lastFailure) > policy.ResetAfter && !now.Before(entry.blockedUntil) {
+			delete(t.entries, name)
+		}
+	}
+}
+
+func (p throttlePolicy) delay(failures int) time.Duration {
+	if failures >= p.LockoutAfter {
+		return p.LockoutDuration
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+type throttleKey struct {
+	name   string
+	policy throttlePolicy
+}
+
+func throttleKeys(email, ip string) []throttleKey {
+	return []throttleKey{
+		{name: "email:" + strings.ToLower(strings.TrimSpace(email)), policy: emailThrottlePolicy},
+		{name: "ip:" + ip, policy: ipThrottlePolicy},
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottlePolicyDelay(t *testing.T) {
+	policy := throttlePolicy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        10 * time.Second,
+		LockoutAfter:    10,
+		LockoutDuration: 15 * time.Minute,
+	}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: 0},
+		{failures: 3, want: 0},
+		{failures: 4, want: time.Second},
+		{failures: 5, want: 2 * time.Second},
+		{failures: 6, want: 4 * time.Second},
+		{failures: 8, want: 10 * time.Second},
+		{failures: 10, want: 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := policy.delay(tt.failures); got != tt.want {
+			t.Fatalf("delay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginThrottleBacksOffAndLocksOut(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	throttle := newLoginThrottle()
+	throttle.now = func() time.Time { return now }
+
+	for i := 0; i < emailThrottlePolicy.FreeAttempts; i++ {
+		if _, ok := throttle.allow("a@x.com", "10.0.0.1"); !ok {
+			t.Fatalf("attempt %d should be allowed", i+1)
+		}
+		throttle.recordFailure("a@x.com", "10.0.0.1")
+	}
+	if _, ok := throttle.allow("a@x.com", "10.0.0.1"); !ok {
+		t.Fatal("free attempts must not be delayed")
+	}
+
+	throttle.recordFailure("A@x.com", "10.0.0.2")
+	wait, ok := throttle.allow("a@x.com", "10.0.0.3")
+	if ok || wait != time.Second {
+		t.Fatalf("expected the email to be delayed from any IP, got %s, %v", wait, ok)
+	}
+	if _, ok := throttle.allow("b@x.com", "10.0.0.1"); !ok {
+		t.Fatal("other emails from the same IP should still be allowed")
+	}
+
+	now = now.Add(time.Second)
+	if _, ok := throttle.allow("a@x.com", "10.0.0.1"); !ok {
+		t.Fatal("expected the delay to expire")
+	}
+
+	for i := 0; i < emailThrottlePolicy.LockoutAfter; i++ {
+		throttle.recordFailure("a@x.com", "10.0.0.1")
+	}
+	wait, ok = throttle.allow("a@x.com", "10.0.0.1")
+	if ok || wait != emailThrottlePolicy.LockoutDuration {
+		t.Fatalf("expected lockout, got %s, %v", wait, ok)
+	}
+
+	throttle.recordSuccess("a@x.com", "10.0.0.1")
+	if _, ok := throttle.allow("a@x.com", "10.0.0.1"); !ok {
+		t.Fatal("expected a successful login to clear the history")
+	}
+}
+
+func TestLoginThrottlePrunesStaleEntries(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	throttle := newLoginThrottle()
+	throttle.now = func() time.Time { return now }
+
+	throttle.recordFailure("a@x.com", "10.0.0.1")
+	now = now.Add(2 * time.Hour)
+	throttle.recordFailure("b@x.com", "10.0.0.2")
+
+	if _, ok := throttle.entries["email:a@x.com"]; ok {
+		t.Fatal("expected stale entry to be pruned")
+	}
+	if len(throttle.entries) != 2 {
+		t.Fatalf("expected only the new entries, got %d", len(throttle.entries))
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	qrcode "github.com/skip2/go-qrcode"
+
+	"github.com/Simplici0/o.works/internal/totp"
+)
+
+const (
+	totpIssuer = "o.works"
+	// totpSkew accepts codes from one step before or after the current one.
+	totpSkew          = 1
+	recoveryCodeCount = 10
+)
+
+type totpState struct {
+	UserID      int64
+	Email       string
+	Role        string
+	Secret      string
+	Enabled     bool
+	LastCounter int64
+}
+
+type loginTwoFactorViewData struct {
+	baseViewData
+}
+
+type accountTwoFactorViewData struct {
+	baseViewData
+	Enabled  bool
+	Required bool
+	Secret   string
+	QRCode   template.URL
+	// RecoveryCodes are shown once, right after enrollment.
+	RecoveryCodes []string
+}
+
+func (s *server) handleLoginTwoFactorForm(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.auth.pendingLogin(r); !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	s.renderTemplate(w, r, "login_2fa.html", loginTwoFactorViewData{})
+}
+
+func (s *server) handleLoginTwoFactorSubmit(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.auth.pendingLogin(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	state, err := s.auth.getTOTPState(userID)
+	if err != nil {
+		serverError(w, r, "authentication error", err)
+		return
+	}
+
+	ip := clientIP(r)
+	if _, ok := s.loginThrottle.allow(state.Email, ip); !ok {
+		s.metrics.loginFailed(loginFailureThrottled)
+		w.WriteHeader(http.StatusTooManyRequests)
+		s.renderTemplate(w, r, "login_2fa.html", loginTwoFactorViewData{baseViewData: baseViewData{ErrorMessage: "Código inválido o demasiados intentos. Espera un momento e intenta de nuevo."}})
+		return
+	}
+
+	valid, err := s.auth.verifySecondFactor(state, r.FormValue("code"))
+	if err != nil {
+		serverError(w, r, "authentication error", err)
+		return
+	}
+	if !valid {
+		s.loginThrottle.recordFailure(state.Email, ip)
+		s.metrics.loginFailed(loginFailureSecondStep)
+		w.WriteHeader(http.StatusUnauthorized)
+		s.renderTemplate(w, r, "login_2fa.html", loginTwoFactorViewData{baseViewData: baseViewData{ErrorMessage: "Código inválido o demasiados intentos. Espera un momento e intenta de nuevo."}})
+		return
+	}
+	s.loginThrottle.recordSuccess(state.Email, ip)
+
+	session, err := s.auth.createSession(userID, r)
+	if err != nil {
+		serverError(w, r, "failed to create session", err)
+		return
+	}
+
+	s.auth.clearPendingLogin(w)
+	s.auth.setSessionCookie(w, session)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (s *server) handleAccountTwoFactorForm(w http.ResponseWriter, r *http.Request) {
+	s.renderAccountTwoFactor(w, r, accountTwoFactorViewData{
+		baseViewData: baseViewData{
+			ErrorMessage:   r.URL.Query().Get("error"),
+			SuccessMessage: r.URL.Query().Get("success"),
+		},
+	})
+}
+
+func (s *server) handleAccountTwoFactorEnable(w http.ResponseWriter, r *http.Request) {
+	current, _ := currentUser(r)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	state, err := s.auth.getTOTPState(current.UserID)
+	if err != nil {
+		serverError(w, r, "failed to load two-factor settings", err)
+		return
+	}
+	if state.Enabled {
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return
+	}
+
+	counter, ok := totp.Verify(state.Secret, r.FormValue("code"), time.Now(), totpSkew)
+	if state.Secret == "" || !ok {
+		http.Redirect(w, r, "/account/2fa?error="+url.QueryEscape("Código inválido. Revisa la hora de tu teléfono e intenta de nuevo."), http.StatusSeeOther)
+		return
+	}
+
+	codes, err := s.auth.enableTOTP(current.UserID, counter)
+	if err != nil {
+		serverError(w, r, "failed to enable two-factor authentication", err)
+		return
+	}
+
+	s.renderAccountTwoFactor(w, r, accountTwoFactorViewData{
+		baseViewData:  baseViewData{SuccessMessage: "Autenticación de dos factores activada"},
+		RecoveryCodes: codes,
+	})
+}
+
+func (s *server) handleAccountTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	current, _ := currentUser(r)
+	if s.requireAdminTOTP && current.Role == roleAdmin {
+		http.Redirect(w, r, "/account/2fa?error="+url.QueryEscape("La autenticación de dos factores es obligatoria para administradores"), http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	state, err := s.auth.getTOTPState(current.UserID)
+	if err != nil {
+		serverError(w, r, "failed to load two-factor settings", err)
+		return
+	}
+
+	valid, err := s.auth.verifySecondFactor(state, r.FormValue("code"))
+	if err != nil {
+		serverError(w, r, "failed to disable two-factor authentication", err)
+		return
+	}
+	if !valid {
+		http.Redirect(w, r, "/account/2fa?error="+url.QueryEscape("Código inválido"), http.StatusSeeOther)
+		return
+	}
+
+	if err := s.auth.resetTOTP(current.UserID); err != nil {
+		serverError(w, r, "failed to disable two-factor authentication", err)
+		return
+	}
+
+	http.Redirect(w, r, "/account/2fa?success="+url.QueryEscape("Autenticación de dos factores desactivada"), http.StatusSeeOther)
+}
+
+// handleAdminUsersResetTwoFactor lets an admin remove the second factor of a
+// user who lost both the device and the recovery codes.
+func (s *server) handleAdminUsersResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.auth.resetTOTP(id); err != nil {
+		serverError(w, r, "failed to reset two-factor authentication", err)
+		return
+	}
+	if err := s.auth.deleteUserSessions(id); err != nil {
+		serverError(w, r, "failed to reset two-factor authentication", err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/users?success="+url.QueryEscape("Autenticación de dos factores restablecida"), http.StatusSeeOther)
+}
+
+// renderAccountTwoFactor shows the enrollment QR code when the user has no
+// second factor yet, creating the pending secret on first visit.
+func (s *server) renderAccountTwoFactor(w http.ResponseWriter, r *http.Request, data accountTwoFactorViewData) {
+	current, _ := currentUser(r)
+	state, err := s.auth.getTOTPState(current.UserID)
+	if err != nil {
+		serverError(w, r, "failed to load two-factor settings", err)
+		return
+	}
+
+	data.Enabled = state.Enabled
+	data.Required = s.requireAdminTOTP && current.Role == roleAdmin
+	if !state.Enabled {
+		if state.Secret == "" {
+			state.Secret, err = s.auth.startTOTPEnrollment(current.UserID)
+			if err != nil {
+				serverError(w, r, "failed to start two-factor enrollment", err)
+				return
+			}
+		}
+
+		png, err := qrcode.Encode(totp.KeyURI(totpIssuer, state.Email, state.Secret), qrcode.Medium, 220)
+		if err != nil {
+			serverError(w, r, "failed to render qr code", err)
+			return
+		}
+		data.Secret = state.Secret
+		data.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	}
+
+	s.renderTemplate(w, r, "account_2fa.html", data)
+}
+
+func (a *authService) getTOTPState(userID int64) (totpState, error) {
+	return a.users().getUserTOTP(userID)
+}
+
+// userHasTOTP reports whether the login for userID needs a second factor.
+func (a *authService) userHasTOTP(userID int64) (bool, error) {
+	state, err := a.users().getUserTOTP(userID)
+	if err != nil {
+		return false, err
+	}
+	return state.Enabled, nil
+}
+
+func (a *authService) startTOTPEnrollment(userID int64) (string, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	if err := a.users().setUserTOTPSecret(userID, secret); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// enableTOTP turns on the second factor and returns a fresh set of recovery
+// codes. Only their hashes are stored.
+func (a *authService) enableTOTP(userID, counter int64) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	if err := a.users().enableUserTOTP(userID, counter, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (a *authService) resetTOTP(userID int64) error {
+	return a.users().resetUserTOTP(userID)
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery
+// code. Accepted TOTP steps and recovery codes cannot be used again.
+func (a *authService) verifySecondFactor(state totpState, code string) (bool, error) {
+	if !state.Enabled || state.Secret == "" {
+		return false, nil
+	}
+
+	code = strings.TrimSpace(code)
+	if strings.Contains(code, "-") {
+		return a.useRecoveryCode(state.UserID, code)
+	}
+
+	counter, ok := totp.Verify(state.Secret, code, time.Now(), totpSkew)
+	if !ok {
+		return false, nil
+	}
+
+	return a.users().advanceUserTOTPCounter(state.UserID, counter)
+}
+
+func (a *authService) useRecoveryCode(userID int64, code string) (bool, error) {
+	result, err := a.db.Exec(`
+		UPDATE user_recovery_codes
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, fmt.Errorf("use recovery code: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("use recovery code: %w", err)
+	}
+
+	return affected == 1, nil
+}
+
+// newRecoveryCode returns a code like "k7p2-x9qm" with 40 bits of entropy.
+func newRecoveryCode() (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("read recovery code: %w", err)
+	}
+	for i, b := range buf {
+		buf[i] = alphabet[int(b)%len(alphabet)]
+	}
+
+	return string(buf[:4]) + "-" + string(buf[4:]), nil
+}
+
+// hashRecoveryCode uses a plain SHA-256: recovery codes are random and single
+// use, so a slow KDF adds nothing.
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// isTwoFactorExempt lists the paths an admin without a second factor may still
+// use when two-factor authentication is required.
+func isTwoFactorExempt(path string) bool {
+	return path == "/account/2fa" || strings.HasPrefix(path, "/account/2fa/") || path == "/logout" || path == "/logout/all"
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Simplici0/o.works/internal/totp"
+)
+
+func TestVerifySecondFactor(t *testing.T) {
+	database := newMigratedTestDB(t)
+	auth, userID := newSessionTestAuth(t, database)
+
+	secret, err := auth.startTOTPEnrollment(userID)
+	if err != nil {
+		t.Fatalf("startTOTPEnrollment returned error: %v", err)
+	}
+
+	state, err := auth.getTOTPState(userID)
+	if err != nil {
+		t.Fatalf("getTOTPState returned error: %v", err)
+	}
+	code, err := totp.Code(secret, totp.Counter(time.Now()))
+	if err != nil {
+		t.Fatalf("totp.Code returned error: %v", err)
+	}
+	if ok, err := auth.verifySecondFactor(state, code); err != nil || ok {
+		t.Fatalf("expected codes to be rejected before enrollment is confirmed, got %v, %v", ok, err)
+	}
+
+	// Enrollment consumed the previous step, so the current code is still usable once.
+	recoveryCodes, err := auth.enableTOTP(userID, totp.Counter(time.Now())-1)
+	if err != nil {
+		t.Fatalf("enableTOTP returned error: %v", err)
+	}
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %d", recoveryCodeCount, len(recoveryCodes))
+	}
+
+	state, err = auth.getTOTPState(userID)
+	if err != nil {
+		t.Fatalf("getTOTPState returned error: %v", err)
+	}
+	if ok, err := auth.verifySecondFactor(state, code); err != nil || !ok {
+		t.Fatalf("expected current code to verify, got %v, %v", ok, err)
+	}
+	if ok, err := auth.verifySecondFactor(state, code); err != nil || ok {
+		t.Fatalf("expected replayed code to be rejected, got %v, %v", ok, err)
+	}
+	if ok, err := auth.verifySecondFactor(state, "000000"); err != nil || ok {
+		t.Fatalf("expected wrong code to be rejected, got %v, %v", ok, err)
+	}
+
+	if ok, err := auth.verifySecondFactor(state, recoveryCodes[0]); err != nil || !ok {
+		t.Fatalf("expected recovery code to verify, got %v, %v", ok, err)
+	}
+	if ok, err := auth.verifySecondFactor(state, recoveryCodes[0]); err != nil || ok {
+		t.Fatalf("expected used recovery code to be rejected, got %v, %v", ok, err)
+	}
+
+	if err := auth.resetTOTP(userID); err != nil {
+		t.Fatalf("resetTOTP returned error: %v", err)
+	}
+	if enabled, err := auth.userHasTOTP(userID); err != nil || enabled {
+		t.Fatalf("expected totp to be disabled, got %v, %v", enabled, err)
+	}
+}
+
+func TestPendingLoginCookie(t *testing.T) {
+	auth := newAuthService(nil, "secret", defaultSessionPolicy)
+
+	rec := httptest.NewRecorder()
+	auth.setPendingLogin(rec, 42)
+	cookie := rec.Result().Cookies()[0]
+
+	req := httptest.NewRequest(http.MethodGet, "/login/2fa", nil)
+	req.AddCookie(cookie)
+	if userID, ok := auth.pendingLogin(req); !ok || userID != 42 {
+		t.Fatalf("pendingLogin = %d, %v", userID, ok)
+	}
+
+	forged := *cookie
+	forged.Value = "1" + cookie.Value[2:]
+	req = httptest.NewRequest(http.MethodGet, "/login/2fa", nil)
+	req.AddCookie(&forged)
+	if _, ok := auth.pendingLogin(req); ok {
+		t.Fatal("expected tampered cookie to be rejected")
+	}
+
+	expired := *cookie
+	payload := "42.1"
+	expired.Value = payload + "." + auth.signPendingLogin(payload)
+	req = httptest.NewRequest(http.MethodGet, "/login/2fa", nil)
+	req.AddCookie(&expired)
+	if _, ok := auth.pendingLogin(req); ok {
+		t.Fatal("expected expired cookie to be rejected")
+	}
+}
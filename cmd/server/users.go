@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	roleAdmin    = "admin"
+	roleSales    = "sales"
+	roleOperator = "operator"
+	roleViewer   = "viewer"
+)
+
+// userRoles lists the roles in the order they are offered in forms.
+var userRoles = []string{roleAdmin, roleSales, roleOperator, roleViewer}
+
+type user struct {
+	ID        int64
+	Email     string
+	Role      string
+	Active    bool
+	CreatedAt string
+	// TOTPEnabled reports whether the user enrolled a second factor.
+	TOTPEnabled bool
+}
+
+type usersViewData struct {
+	baseViewData
+	Users []user
+	Roles []string
+	// ShareLink is shown when an invite or reset email could not be sent, so
+	// the admin can hand the link over some other way.
+	ShareLink  string
+	ShareEmail string
+}
+
+// requireRole rejects requests from users whose role is not in roles. It runs
+// after authMiddleware, which stores the session user in the context.
+func (s *server) requireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			current, ok := currentUser(r)
+			if !ok || !hasRole(current, roles...) {
+				if isAPIRequest(r) {
+					writeAPIError(w, http.StatusForbidden, "forbidden")
+					return
+				}
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func hasRole(current sessionUser, roles ...string) bool {
+	for _, role := range roles {
+		if current.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// templateFuncs exposes the current user to templates so the layout can hide
+// links the role cannot use, plus the CSRF token every form must submit.
+func (s *server) templateFuncs(r *http.Request) template.FuncMap {
+	current, _ := currentUser(r)
+	return template.FuncMap{
+		"csrfField": func() template.HTML {
+			return csrfField(r)
+		},
+		"csrfToken": func() string {
+			return csrfTokenFromRequest(r)
+		},
+		"currentUser": func() sessionUser {
+			return current
+		},
+		"hasRole": func(roles ...string) bool {
+			return hasRole(current, roles...)
+		},
+	}
+}
+
+func (s *server) handleAdminUsersForm(w http.ResponseWriter, r *http.Request) {
+	s.renderUsersPage(w, r, http.StatusOK, usersViewData{
+		baseViewData: baseViewData{
+			ErrorMessage:   r.URL.Query().Get("error"),
+			SuccessMessage: r.URL.Query().Get("success"),
+		},
+	})
+}
+
+func (s *server) handleAdminUsersCreate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	invited, err := parseUserInviteForm(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/users?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	exists, err := s.store().userExists(invited.Email)
+	if err != nil {
+		serverError(w, r, "failed to create user", err)
+		return
+	}
+	if exists {
+		http.Redirect(w, r, "/admin/users?error="+url.QueryEscape("email ya existe"), http.StatusSeeOther)
+		return
+	}
+
+	// The user picks their own password through the invite link; until then
+	// the account has a random one nobody knows.
+	password, err := newTemporaryPassword()
+	if err != nil {
+		serverError(w, r, "failed to create user", err)
+		return
+	}
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		serverError(w, r, "failed to create user", err)
+		return
+	}
+
+	id, err := s.store().createUser(invited.Email, passwordHash, invited.Role)
+	if err != nil {
+		serverError(w, r, "failed to create user", err)
+		return
+	}
+
+	s.deliverPasswordLink(w, r, id, invited.Email, passwordTokenInvite, "Invitación enviada a "+invited.Email)
+}
+
+func (s *server) handleAdminUsersUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	role := strings.TrimSpace(r.FormValue("role"))
+	if !isValidRole(role) {
+		http.Redirect(w, r, "/admin/users?error="+url.QueryEscape("role debe ser admin, sales, operator o viewer"), http.StatusSeeOther)
+		return
+	}
+	active := r.FormValue("active") == "1"
+
+	if current, ok := currentUser(r); ok && current.UserID == id && (role != roleAdmin || !active) {
+		http.Redirect(w, r, "/admin/users?error="+url.QueryEscape("No puedes quitarte el rol admin ni desactivar tu propio usuario"), http.StatusSeeOther)
+		return
+	}
+
+	found, err := s.store().updateUserAccess(id, role, active)
+	if err != nil {
+		serverError(w, r, "failed to update user", err)
+		return
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !active {
+		if err := s.auth.deleteUserSessions(id); err != nil {
+			serverError(w, r, "failed to update user", err)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/admin/users?success=Usuario+actualizado+correctamente", http.StatusSeeOther)
+}
+
+// handleAdminUsersReset locks the user out with a random password, ends their
+// sessions and sends them a link to choose a new one.
+func (s *server) handleAdminUsersReset(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	email, err := s.store().getUserEmail(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "failed to reset password", err)
+		return
+	}
+
+	password, err := newTemporaryPassword()
+	if err != nil {
+		serverError(w, r, "failed to reset password", err)
+		return
+	}
+	if err := s.auth.setPassword(id, password); err != nil {
+		serverError(w, r, "failed to reset password", err)
+		return
+	}
+	if err := s.auth.deleteUserSessions(id); err != nil {
+		serverError(w, r, "failed to reset password", err)
+		return
+	}
+
+	s.deliverPasswordLink(w, r, id, email, passwordTokenReset, "Enlace para restablecer la contraseña enviado a "+email)
+}
+
+// deliverPasswordLink emails an invite or reset link and renders the users
+// page. If the email can't be sent the link is shown to the admin instead.
+func (s *server) deliverPasswordLink(w http.ResponseWriter, r *http.Request, userID int64, email, purpose, sentMessage string) {
+	link, err := s.createPasswordLink(r, userID, purpose)
+	if err != nil {
+		serverError(w, r, "failed to create password link", err)
+		return
+	}
+
+	if err := s.sendPasswordLink(r, email, purpose, link); err != nil {
+		requestLogger(r).Error("send password email", "purpose", purpose, "user_id", userID, "error", err)
+		s.renderUsersPage(w, r, http.StatusOK, usersViewData{
+			baseViewData: baseViewData{ErrorMessage: "No se pudo enviar el correo. Comparte el enlace manualmente."},
+			ShareLink:    link,
+			ShareEmail:   email,
+		})
+		return
+	}
+
+	s.renderUsersPage(w, r, http.StatusOK, usersViewData{baseViewData: baseViewData{SuccessMessage: sentMessage}})
+}
+
+func (s *server) renderUsersPage(w http.ResponseWriter, r *http.Request, status int, data usersViewData) {
+	users, err := s.store().listUsers()
+	if err != nil {
+		serverError(w, r, "failed to load users", err)
+		return
+	}
+
+	data.Users = users
+	data.Roles = userRoles
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
+	s.renderTemplate(w, r, "admin_users.html", data)
+}
+
+func parseUserInviteForm(r *http.Request) (user, error) {
+	invited := user{
+		Email: strings.ToLower(strings.TrimSpace(r.FormValue("email"))),
+		Role:  strings.TrimSpace(r.FormValue("role")),
+	}
+
+	if invited.Email == "" || !strings.Contains(invited.Email, "@") {
+		return invited, fmt.Errorf("email inválido")
+	}
+	if !isValidRole(invited.Role) {
+		return invited, fmt.Errorf("role debe ser admin, sales, operator o viewer")
+	}
+
+	return invited, nil
+}
+
+func isValidRole(role string) bool {
+	for _, r := range userRoles {
+		if role == r {
+			return true
+		}
+	}
+	return false
+}
+
+// newTemporaryPassword returns a random password without look-alike
+// characters. It is never shown; it only keeps the account closed until the
+// user sets their own password through an emailed link.
+func newTemporaryPassword() (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+	buf := make([]byte, 14)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("read temporary password: %w", err)
+	}
+	for i, b := range buf {
+		buf[i] = alphabet[int(b)%len(alphabet)]
+	}
+
+	return string(buf), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRequireRole(t *testing.T) {
+	srv := &server{}
+	handler := srv.requireRole(roleAdmin, roleSales)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name string
+		user *sessionUser
+		want int
+	}{
+		{name: "admin", user: &sessionUser{Role: roleAdmin}, want: http.StatusNoContent},
+		{name: "sales", user: &sessionUser{Role: roleSales}, want: http.StatusNoContent},
+		{name: "operator", user: &sessionUser{Role: roleOperator}, want: http.StatusForbidden},
+		{name: "viewer", user: &sessionUser{Role: roleViewer}, want: http.StatusForbidden},
+		{name: "anonymous", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/quotes", nil)
+			if tt.user != nil {
+				req = req.WithContext(context.WithValue(req.Context(), sessionUserContextKey{}, *tt.user))
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUserInviteForm(t *testing.T) {
+	tests := []struct {
+		name    string
+		form    url.Values
+		wantErr string
+	}{
+		{name: "valid", form: url.Values{"email": {" Ana@X.com "}, "role": {"sales"}}},
+		{name: "missing email", form: url.Values{"role": {"sales"}}, wantErr: "email inválido"},
+		{name: "bad role", form: url.Values{"email": {"a@x.com"}, "role": {"owner"}}, wantErr: "role debe ser"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/admin/users", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			invited, err := parseUserInviteForm(req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if invited.Email != "ana@x.com" || invited.Role != roleSales {
+				t.Fatalf("unexpected user: %+v", invited)
+			}
+		})
+	}
+}
+
+func TestDisabledUserCannotUseSessionOrLogIn(t *testing.T) {
+	database := newMigratedTestDB(t)
+	auth, userID := newSessionTestAuth(t, database)
+
+	value, err := auth.createSession(userID, httptest.NewRequest(http.MethodPost, "/login", nil))
+	if err != nil {
+		t.Fatalf("createSession returned error: %v", err)
+	}
+	user, ok, err := auth.lookupSession(value)
+	if err != nil || !ok || user.Role != roleAdmin {
+		t.Fatalf("expected admin session, got %+v, %v, %v", user, ok, err)
+	}
+
+	if _, err := database.Exec(`UPDATE users SET active = FALSE WHERE id = ?`, userID); err != nil {
+		t.Fatalf("failed to disable user: %v", err)
+	}
+
+	if _, ok, err := auth.lookupSession(value); err != nil || ok {
+		t.Fatalf("expected disabled user's session to be rejected, got %v, %v", ok, err)
+	}
+	if _, ok, err := auth.validateCredentials("admin@x.com", "secret"); err != nil || ok {
+		t.Fatalf("expected disabled user to fail login, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,656 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Simplici0/o.works/internal/pricing"
+	"github.com/Simplici0/o.works/internal/webhook"
+)
+
+const (
+	webhookEventQuoteCreated         = "quote.created"
+	webhookEventQuoteAccepted        = "quote.accepted"
+	webhookEventQuoteRejected        = "quote.rejected"
+	webhookEventMaterialCreated      = "material.created"
+	webhookEventMaterialPriceChanged = "material.price_changed"
+
+	webhookStatusPending   = "pending"
+	webhookStatusDelivered = "delivered"
+	webhookStatusFailed    = "failed"
+
+	// webhookPollInterval is how often the worker looks for due deliveries
+	// when nothing wakes it earlier.
+	webhookPollInterval = 15 * time.Second
+	webhookBatchSize    = 20
+	webhookTimeout      = 10 * time.Second
+	// webhookMaxAttempts with the backoff below keeps retrying for about
+	// two hours before a delivery is marked as failed.
+	webhookMaxAttempts = 8
+	webhookBackoffBase = 30 * time.Second
+	webhookBackoffMax  = time.Hour
+	// webhookRetention is how long finished deliveries stay in the log.
+	webhookRetention   = 30 * 24 * time.Hour
+	webhookPruneEvery  = time.Hour
+	webhookDeliveryLog = 200
+)
+
+var webhookEvents = []string{
+	webhookEventQuoteCreated,
+	webhookEventQuoteAccepted,
+	webhookEventQuoteRejected,
+	webhookEventMaterialCreated,
+	webhookEventMaterialPriceChanged,
+}
+
+var webhookStatuses = []string{webhookStatusPending, webhookStatusDelivered, webhookStatusFailed}
+
+type webhookSubscription struct {
+	ID        int64
+	URL       string
+	Secret    string
+	Events    []string
+	Active    bool
+	CreatedAt string
+}
+
+// HasEvent is used by the admin template to check the event boxes.
+func (w webhookSubscription) HasEvent(event string) bool {
+	return slices.Contains(w.Events, event)
+}
+
+type webhookDelivery struct {
+	ID             int64
+	WebhookURL     string
+	Event          string
+	Payload        string
+	Status         string
+	Attempts       int
+	NextAttemptAt  string
+	LastStatusCode int
+	LastError      string
+	CreatedAt      string
+	DeliveredAt    string
+}
+
+type webhooksViewData struct {
+	baseViewData
+	Webhooks []webhookSubscription
+	Events   []string
+}
+
+type webhookDeliveriesViewData struct {
+	baseViewData
+	Deliveries []webhookDelivery
+	Statuses   []string
+	Status     string
+}
+
+// webhookEnvelope is the JSON body sent to subscribers.
+type webhookEnvelope struct {
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+type quoteWebhookData struct {
+	ID              int64          `json:"id"`
+	Title           string         `json:"title"`
+	CustomerName    string         `json:"customer_name"`
+	Currency        string         `json:"currency"`
+	ValidUntil      string         `json:"valid_until"`
+	Totals          pricing.Totals `json:"totals"`
+	Decision        string         `json:"decision,omitempty"`
+	DecisionComment string         `json:"decision_comment,omitempty"`
+}
+
+type materialWebhookData struct {
+	material
+	PreviousCostPerKg *float64 `json:"previous_cost_per_kg,omitempty"`
+}
+
+func (s *server) handleAdminWebhooksForm(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := s.listWebhooks()
+	if err != nil {
+		serverError(w, r, "failed to load webhooks", err)
+		return
+	}
+
+	s.renderTemplate(w, r, "admin_webhooks.html", webhooksViewData{
+		baseViewData: baseViewData{
+			ErrorMessage:   r.URL.Query().Get("error"),
+			SuccessMessage: r.URL.Query().Get("success"),
+		},
+		Webhooks: webhooks,
+		Events:   webhookEvents,
+	})
+}
+
+func (s *server) handleAdminWebhooksCreate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	hook, err := parseWebhookForm(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/webhooks?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+	if hook.Secret == "" {
+		if hook.Secret, err = newWebhookSecret(); err != nil {
+			serverError(w, r, "failed to create webhook", err)
+			return
+		}
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO webhooks (url, secret, events, active)
+		VALUES (?, ?, ?, ?)
+	`, hook.URL, hook.Secret, strings.Join(hook.Events, ","), hook.Active)
+	if err != nil {
+		serverError(w, r, "failed to create webhook", err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/webhooks?success=Webhook+creado+correctamente", http.StatusSeeOther)
+}
+
+// handleAdminWebhooksUpdate keeps the current secret when the field is left
+// empty.
+func (s *server) handleAdminWebhooksUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	hook, err := parseWebhookForm(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/webhooks?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE webhooks
+		SET
+			url = ?,
+			secret = COALESCE(NULLIF(?, ''), secret),
+			events = ?,
+			active = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, hook.URL, hook.Secret, strings.Join(hook.Events, ","), hook.Active, id)
+	if err != nil {
+		serverError(w, r, "failed to update webhook", err)
+		return
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		serverError(w, r, "failed to update webhook", err)
+		return
+	}
+	if affected == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/webhooks?success=Webhook+actualizado+correctamente", http.StatusSeeOther)
+}
+
+func (s *server) handleAdminWebhooksDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := s.deleteWebhook(id)
+	if err != nil {
+		serverError(w, r, "failed to delete webhook", err)
+		return
+	}
+	if !deleted {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/webhooks?success=Webhook+eliminado+correctamente", http.StatusSeeOther)
+}
+
+func (s *server) handleAdminWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains(webhookStatuses, status) {
+		http.Error(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+
+	deliveries, err := s.listWebhookDeliveries(status)
+	if err != nil {
+		serverError(w, r, "failed to load webhook deliveries", err)
+		return
+	}
+
+	s.renderTemplate(w, r, "admin_webhook_deliveries.html", webhookDeliveriesViewData{
+		baseViewData: baseViewData{
+			ErrorMessage:   r.URL.Query().Get("error"),
+			SuccessMessage: r.URL.Query().Get("success"),
+		},
+		Deliveries: deliveries,
+		Statuses:   webhookStatuses,
+		Status:     status,
+	})
+}
+
+// handleAdminWebhookDeliveryRetry queues a delivery for an immediate attempt.
+// Delivered entries are sent again too, which helps a receiver that lost data.
+func (s *server) handleAdminWebhookDeliveryRetry(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid delivery id", http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = ?, next_attempt_at = ?
+		WHERE id = ?
+	`, webhookStatusPending, sqliteTime(time.Now()), id)
+	if err != nil {
+		serverError(w, r, "failed to retry delivery", err)
+		return
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		serverError(w, r, "failed to retry delivery", err)
+		return
+	}
+	if affected == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	s.wakeWebhookWorker()
+
+	http.Redirect(w, r, "/admin/webhooks/deliveries?success=Entrega+reprogramada", http.StatusSeeOther)
+}
+
+func parseWebhookForm(r *http.Request) (webhookSubscription, error) {
+	hook := webhookSubscription{
+		URL:    strings.TrimSpace(r.FormValue("url")),
+		Secret: strings.TrimSpace(r.FormValue("secret")),
+		Active: r.FormValue("active") == "1",
+	}
+
+	parsed, err := url.Parse(hook.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return webhookSubscription{}, fmt.Errorf("url debe ser una URL http o https válida")
+	}
+
+	for _, event := range r.Form["events"] {
+		if !slices.Contains(webhookEvents, event) {
+			return webhookSubscription{}, fmt.Errorf("evento desconocido: %s", event)
+		}
+		if !slices.Contains(hook.Events, event) {
+			hook.Events = append(hook.Events, event)
+		}
+	}
+	if len(hook.Events) == 0 {
+		return webhookSubscription{}, fmt.Errorf("selecciona al menos un evento")
+	}
+
+	return hook, nil
+}
+
+func newWebhookSecret() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("read webhook secret: %w", err)
+	}
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// emitWebhookEvent queues event for every active subscription to it. Failures
+// are logged rather than returned: the change that triggered the event has
+// already been committed and must not fail because of a webhook.
+func (s *server) emitWebhookEvent(event string, data any) {
+	if err := s.queueWebhookEvent(event, data, time.Now()); err != nil {
+		slog.Error("queue webhook event", "event", event, "error", err)
+		return
+	}
+	s.wakeWebhookWorker()
+}
+
+func (s *server) queueWebhookEvent(event string, data any, now time.Time) error {
+	rows, err := s.db.Query(`SELECT id, events FROM webhooks WHERE active = TRUE`)
+	if err != nil {
+		return fmt.Errorf("query webhooks: %w", err)
+	}
+	var subscribers []int64
+	for rows.Next() {
+		var (
+			id     int64
+			events string
+		)
+		if err := rows.Scan(&id, &events); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan webhook: %w", err)
+		}
+		if slices.Contains(strings.Split(events, ","), event) {
+			subscribers = append(subscribers, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate webhooks: %w", err)
+	}
+	if len(subscribers) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(webhookEnvelope{Event: event, CreatedAt: now.UTC(), Data: data})
+	if err != nil {
+		return fmt.Errorf("marshal webhook payload: %w", err)
+	}
+
+	for _, id := range subscribers {
+		_, err := s.db.Exec(`
+			INSERT INTO webhook_deliveries (webhook_id, event, payload, status, next_attempt_at)
+			VALUES (?, ?, ?, ?, ?)
+		`, id, event, string(payload), webhookStatusPending, sqliteTime(now))
+		if err != nil {
+			return fmt.Errorf("insert webhook delivery: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// emitQuoteWebhookEvent loads the saved quote so every quote event carries
+// the same shape of data.
+func (s *server) emitQuoteWebhookEvent(event string, id int64) {
+	quote, err := s.store().getQuote(id)
+	if err != nil {
+		slog.Error("queue webhook event: load quote", "event", event, "quote_id", id, "error", err)
+		return
+	}
+
+	s.emitWebhookEvent(event, quoteWebhookData{
+		ID:              quote.ID,
+		Title:           quote.Title,
+		CustomerName:    quote.CustomerName,
+		Currency:        quote.Currency,
+		ValidUntil:      quote.ValidUntil,
+		Totals:          quote.Result.Totals,
+		Decision:        quote.Decision,
+		DecisionComment: quote.DecisionComment,
+	})
+}
+
+// wakeWebhookWorker makes the worker look for due deliveries now instead of
+// at its next poll. It never blocks.
+func (s *server) wakeWebhookWorker() {
+	select {
+	case s.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// runWebhookWorker delivers queued webhooks until ctx is cancelled.
+func (s *server) runWebhookWorker(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	var lastPrune time.Time
+	for {
+		now := time.Now()
+		if err := s.deliverDueWebhooks(ctx, now); err != nil {
+			slog.Error("deliver webhooks", "error", err)
+		}
+		if now.Sub(lastPrune) >= webhookPruneEvery {
+			if err := s.pruneWebhookDeliveries(now); err != nil {
+				slog.Error("prune webhook deliveries", "error", err)
+			}
+			lastPrune = now
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.webhookWake:
+		}
+	}
+}
+
+type dueWebhookDelivery struct {
+	ID       int64
+	Event    string
+	Payload  string
+	Attempts int
+	URL      string
+	Secret   string
+}
+
+// deliverDueWebhooks sends one batch of pending deliveries whose next attempt
+// is due. Deliveries of inactive subscriptions wait until they are enabled.
+func (s *server) deliverDueWebhooks(ctx context.Context, now time.Time) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT d.id, d.event, d.payload, d.attempts, w.url, w.secret
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = ? AND d.next_attempt_at <= ? AND w.active = TRUE
+		ORDER BY d.next_attempt_at, d.id
+		LIMIT ?
+	`, webhookStatusPending, sqliteTime(now), webhookBatchSize)
+	if err != nil {
+		return fmt.Errorf("query due webhook deliveries: %w", err)
+	}
+	var due []dueWebhookDelivery
+	for rows.Next() {
+		var d dueWebhookDelivery
+		if err := rows.Scan(&d.ID, &d.Event, &d.Payload, &d.Attempts, &d.URL, &d.Secret); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan webhook delivery: %w", err)
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate webhook deliveries: %w", err)
+	}
+
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return nil
+		}
+		status, sendErr := webhook.Send(ctx, s.webhookClient, webhook.Request{
+			URL:        d.URL,
+			Secret:     d.Secret,
+			Event:      d.Event,
+			DeliveryID: d.ID,
+			Body:       []byte(d.Payload),
+		}, time.Now())
+		if err := s.recordWebhookAttempt(d, status, sendErr, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *server) recordWebhookAttempt(d dueWebhookDelivery, statusCode int, sendErr error, now time.Time) error {
+	attempts := d.Attempts + 1
+	code := sql.NullInt64{Int64: int64(statusCode), Valid: statusCode != 0}
+
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = s.db.Exec(`
+			UPDATE webhook_deliveries
+			SET status = ?, attempts = ?, last_status_code = ?, last_error = NULL, delivered_at = ?
+			WHERE id = ?
+		`, webhookStatusDelivered, attempts, code, sqliteTime(now), d.ID)
+	case attempts >= webhookMaxAttempts:
+		_, err = s.db.Exec(`
+			UPDATE webhook_deliveries
+			SET status = ?, attempts = ?, last_status_code = ?, last_error = ?
+			WHERE id = ?
+		`, webhookStatusFailed, attempts, code, sendErr.Error(), d.ID)
+	default:
+		next := now.Add(webhook.Backoff(attempts, webhookBackoffBase, webhookBackoffMax))
+		_, err = s.db.Exec(`
+			UPDATE webhook_deliveries
+			SET attempts = ?, last_status_code = ?, last_error = ?, next_attempt_at = ?
+			WHERE id = ?
+		`, attempts, code, sendErr.Error(), sqliteTime(next), d.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("update webhook delivery: %w", err)
+	}
+	return nil
+}
+
+func (s *server) pruneWebhookDeliveries(now time.Time) error {
+	_, err := s.db.Exec(`
+		DELETE FROM webhook_deliveries
+		WHERE status IN (?, ?) AND created_at < ?
+	`, webhookStatusDelivered, webhookStatusFailed, sqliteTime(now.Add(-webhookRetention)))
+	if err != nil {
+		return fmt.Errorf("delete old webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+func (s *server) listWebhooks() ([]webhookSubscription, error) {
+	rows, err := s.db.Query(`
+		SELECT id, url, secret, events, active, created_at
+		FROM webhooks
+		ORDER BY id DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := make([]webhookSubscription, 0)
+	for rows.Next() {
+		var (
+			hook              webhookSubscription
+			events, createdAt string
+		)
+		if err := rows.Scan(&hook.ID, &hook.URL, &hook.Secret, &events, &hook.Active, &createdAt); err != nil {
+			return nil, fmt.Errorf("scan webhook: %w", err)
+		}
+		hook.Events = strings.Split(events, ",")
+		if hook.CreatedAt, err = formatSQLiteTime(createdAt); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, hook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate webhooks: %w", err)
+	}
+
+	return webhooks, nil
+}
+
+// deleteWebhook removes a subscription and its delivery log. Deliveries are
+// deleted explicitly because foreign keys are only enforced on the
+// connection that set the pragma.
+func (s *server) deleteWebhook(id int64) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("begin delete webhook: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		return false, fmt.Errorf("delete webhook deliveries: %w", err)
+	}
+	result, err := tx.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return false, fmt.Errorf("delete webhook: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("delete webhook: %w", err)
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit delete webhook: %w", err)
+	}
+	return true, nil
+}
+
+// listWebhookDeliveries returns the most recent deliveries, optionally only
+// those with the given status.
+func (s *server) listWebhookDeliveries(status string) ([]webhookDelivery, error) {
+	rows, err := s.db.Query(`
+		SELECT d.id, w.url, d.event, d.payload, d.status, d.attempts, d.next_attempt_at,
+			d.last_status_code, d.last_error, d.created_at, d.delivered_at
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE ? = '' OR d.status = ?
+		ORDER BY d.id DESC
+		LIMIT ?
+	`, status, status, webhookDeliveryLog)
+	if err != nil {
+		return nil, fmt.Errorf("query webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := make([]webhookDelivery, 0)
+	for rows.Next() {
+		var (
+			d                    webhookDelivery
+			nextAttemptAt        string
+			createdAt            string
+			statusCode           sql.NullInt64
+			lastError, delivered sql.NullString
+		)
+		if err := rows.Scan(&d.ID, &d.WebhookURL, &d.Event, &d.Payload, &d.Status, &d.Attempts, &nextAttemptAt, &statusCode, &lastError, &createdAt, &delivered); err != nil {
+			return nil, fmt.Errorf("scan webhook delivery: %w", err)
+		}
+
+		d.LastStatusCode = int(statusCode.Int64)
+		d.LastError = lastError.String
+		if d.NextAttemptAt, err = formatSQLiteTime(nextAttemptAt); err != nil {
+			return nil, err
+		}
+		if d.CreatedAt, err = formatSQLiteTime(createdAt); err != nil {
+			return nil, err
+		}
+		if delivered.Valid {
+			if d.DeliveredAt, err = formatSQLiteTime(delivered.String); err != nil {
+				return nil, err
+			}
+		}
+
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate webhook deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Simplici0/o.works/internal/webhook"
+)
+
+type receivedWebhook struct {
+	Event    string
+	Envelope struct {
+		Event string          `json:"event"`
+		Data  json.RawMessage `json:"data"`
+	}
+}
+
+// newWebhookReceiver starts a receiver that verifies signatures with secret and
+// answers with status.
+func newWebhookReceiver(t *testing.T, secret string, status int) (*httptest.Server, func() []receivedWebhook) {
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		received []receivedWebhook
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify(secret, r.Header, body, time.Now(), time.Minute); err != nil {
+			t.Errorf("verify webhook: %v", err)
+		}
+
+		var got receivedWebhook
+		got.Event = r.Header.Get(webhook.EventHeader)
+		if err := json.Unmarshal(body, &got.Envelope); err != nil {
+			t.Errorf("decode webhook body: %v", err)
+		}
+		mu.Lock()
+		received = append(received, got)
+		mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(receiver.Close)
+
+	return receiver, func() []receivedWebhook {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]receivedWebhook(nil), received...)
+	}
+}
+
+func insertTestWebhook(t *testing.T, srv *server, rawURL, secret, events string, active bool) {
+	t.Helper()
+
+	if _, err := srv.db.Exec(`INSERT INTO webhooks (url, secret, events, active) VALUES (?, ?, ?, ?)`, rawURL, secret, events, active); err != nil {
+		t.Fatalf("insert webhook: %v", err)
+	}
+}
+
+func TestMaterialWebhooksAreDelivered(t *testing.T) {
+	receiver, received := newWebhookReceiver(t, "secret", http.StatusOK)
+	srv := &server{db: newMigratedTestDB(t), webhookClient: receiver.Client()}
+	insertTestWebhook(t, srv, receiver.URL, "secret", "material.created,material.price_changed", true)
+	insertTestWebhook(t, srv, receiver.URL, "secret", "quote.created", true)
+	insertTestWebhook(t, srv, receiver.URL, "secret", "material.created", false)
+
+	id, err := srv.createMaterial(material{Name: "PLA", CostPerKg: 80000, Active: true})
+	if err != nil {
+		t.Fatalf("createMaterial returned error: %v", err)
+	}
+	if _, err := srv.updateMaterial(id, material{Name: "PLA negro", CostPerKg: 80000, Active: true}); err != nil {
+		t.Fatalf("updateMaterial returned error: %v", err)
+	}
+	if _, err := srv.updateMaterial(id, material{Name: "PLA negro", CostPerKg: 95000, Active: true}); err != nil {
+		t.Fatalf("updateMaterial returned error: %v", err)
+	}
+
+	if err := srv.deliverDueWebhooks(context.Background(), time.Now()); err != nil {
+		t.Fatalf("deliverDueWebhooks returned error: %v", err)
+	}
+
+	got := received()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", len(got))
+	}
+	if got[0].Event != webhookEventMaterialCreated || got[1].Event != webhookEventMaterialPriceChanged {
+		t.Fatalf("unexpected events: %+v", got)
+	}
+
+	var changed materialWebhookData
+	if err := json.Unmarshal(got[1].Envelope.Data, &changed); err != nil {
+		t.Fatalf("decode data: %v", err)
+	}
+	if changed.ID != id || changed.CostPerKg != 95000 || changed.PreviousCostPerKg == nil || *changed.PreviousCostPerKg != 80000 {
+		t.Fatalf("unexpected data: %s", got[1].Envelope.Data)
+	}
+
+	deliveries, err := srv.listWebhookDeliveries(webhookStatusDelivered)
+	if err != nil {
+		t.Fatalf("listWebhookDeliveries returned error: %v", err)
+	}
+	if len(deliveries) != 2 || deliveries[0].LastStatusCode != http.StatusOK || deliveries[0].DeliveredAt == "" {
+		t.Fatalf("unexpected deliveries: %+v", deliveries)
+	}
+}
+
+func TestWebhookRetriesUntilFailed(t *testing.T) {
+	receiver, received := newWebhookReceiver(t, "secret", http.StatusInternalServerError)
+	srv := &server{db: newMigratedTestDB(t), webhookClient: receiver.Client()}
+	insertTestWebhook(t, srv, receiver.URL, "secret", "quote.created", true)
+
+	if err := srv.queueWebhookEvent(webhookEventQuoteCreated, quoteWebhookData{ID: 1}, time.Now()); err != nil {
+		t.Fatalf("queueWebhookEvent returned error: %v", err)
+	}
+
+	start := time.Now()
+	if err := srv.deliverDueWebhooks(context.Background(), start); err != nil {
+		t.Fatalf("deliverDueWebhooks returned error: %v", err)
+	}
+
+	var (
+		status      string
+		attempts    int
+		nextAttempt string
+	)
+	if err := srv.db.QueryRow(`SELECT status, attempts, next_attempt_at FROM webhook_deliveries`).Scan(&status, &attempts, &nextAttempt); err != nil {
+		t.Fatalf("query delivery: %v", err)
+	}
+	next, err := parseSQLiteTime(nextAttempt)
+	if err != nil {
+		t.Fatalf("parse next_attempt_at: %v", err)
+	}
+	if status != webhookStatusPending || attempts != 1 || next.Before(start.Add(webhookBackoffBase-time.Second)) {
+		t.Fatalf("unexpected delivery after first failure: %s, %d, %s", status, attempts, nextAttempt)
+	}
+
+	// Not due yet: nothing is sent.
+	if err := srv.deliverDueWebhooks(context.Background(), start); err != nil {
+		t.Fatalf("deliverDueWebhooks returned error: %v", err)
+	}
+	if len(received()) != 1 {
+		t.Fatalf("expected the retry to wait for its backoff, got %d requests", len(received()))
+	}
+
+	later := start.Add(48 * time.Hour)
+	for i := 1; i < webhookMaxAttempts; i++ {
+		if err := srv.deliverDueWebhooks(context.Background(), later); err != nil {
+			t.Fatalf("deliverDueWebhooks returned error: %v", err)
+		}
+	}
+
+	deliveries, err := srv.listWebhookDeliveries("")
+	if err != nil {
+		t.Fatalf("listWebhookDeliveries returned error: %v", err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("expected 1 delivery, got %d", len(deliveries))
+	}
+	d := deliveries[0]
+	if d.Status != webhookStatusFailed || d.Attempts != webhookMaxAttempts || d.LastStatusCode != http.StatusInternalServerError || !strings.Contains(d.LastError, "500") {
+		t.Fatalf("unexpected delivery: %+v", d)
+	}
+	if len(received()) != webhookMaxAttempts {
+		t.Fatalf("expected %d requests, got %d", webhookMaxAttempts, len(received()))
+	}
+}
+
+func TestParseWebhookForm(t *testing.T) {
+	tests := []struct {
+		name    string
+		form    url.Values
+		wantErr string
+	}{
+		{name: "valid", form: url.Values{"url": {"https://erp.example.com/hook"}, "events": {"quote.created", "quote.created"}}},
+		{name: "missing scheme", form: url.Values{"url": {"erp.example.com/hook"}, "events": {"quote.created"}}, wantErr: "url"},
+		{name: "unsupported scheme", form: url.Values{"url": {"ftp://erp.example.com"}, "events": {"quote.created"}}, wantErr: "url"},
+		{name: "no events", form: url.Values{"url": {"https://erp.example.com/hook"}}, wantErr: "evento"},
+		{name: "unknown event", form: url.Values{"url": {"https://erp.example.com/hook"}, "events": {"quote.deleted"}}, wantErr: "desconocido"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/admin/webhooks", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if err := req.ParseForm(); err != nil {
+				t.Fatalf("parse form: %v", err)
+			}
+
+			hook, err := parseWebhookForm(req)
+			if tt.wantErr == "" {
+				if err != nil || len(hook.Events) != 1 {
+					t.Fatalf("parseWebhookForm = %+v, %v", hook, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+// Package backup takes online copies of the SQLite database and restores
+// them.
+//
+// Backups use VACUUM INTO, which writes a consistent, compacted copy while
+// the application keeps serving requests, including changes still in the WAL.
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/Simplici0/o.works/internal/migrations"
+)
+
+const (
+	filePrefix = "oworks-"
+	fileSuffix = ".db"
+	timeLayout = "20060102-150405"
+)
+
+var (
+	ErrInvalidName   = errors.New("backup: invalid file name")
+	ErrSchemaTooNew  = errors.New("backup: schema is newer than this build")
+	ErrNotADatabase  = errors.New("backup: file is not a valid o.works database")
+	validNamePattern = regexp.MustCompile(`^` + filePrefix + `\d{8}-\d{6}(-\d+)?` + regexp.QuoteMeta(fileSuffix) + `$`)
+)
+
+// File describes a backup in the backup directory.
+type File struct {
+	Name      string
+	Size      int64
+	CreatedAt time.Time
+}
+
+// Create writes a backup of db into dir and returns its path. The copy is
+// written under a temporary name and renamed once complete, so a crash never
+// leaves a truncated file that looks like a backup.
+func Create(ctx context.Context, db *sql.DB, dir string, now time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("create backup dir: %w", err)
+	}
+
+	name := filePrefix + now.UTC().Format(timeLayout) + fileSuffix
+	path := filepath.Join(dir, name)
+	for i := 1; fileExists(path); i++ {
+		name = fmt.Sprintf("%s%s-%d%s", filePrefix, now.UTC().Format(timeLayout), i, fileSuffix)
+		path = filepath.Join(dir, name)
+	}
+
+	tmp := filepath.Join(dir, "."+name+".tmp")
+	_ = os.Remove(tmp)
+	if _, err := db.ExecContext(ctx, `VACUUM INTO ?`, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("vacuum into backup: %w", err)
+	}
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("chmod backup: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("rename backup: %w", err)
+	}
+
+	return path, nil
+}
+
+// List returns the backups in dir, newest first. A missing dir has no
+// backups.
+func List(dir string) ([]File, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []File{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read backup dir: %w", err)
+	}
+
+	files := make([]File, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !ValidName(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("stat backup: %w", err)
+		}
+		files = append(files, File{Name: entry.Name(), Size: info.Size(), CreatedAt: info.ModTime()})
+	}
+
+	// Names embed the UTC timestamp, so they sort chronologically.
+	sort.Slice(files, func(i, j int) bool { return files[i].Name > files[j].Name })
+	return files, nil
+}
+
+// Prune deletes all but the keep newest backups in dir.
+func Prune(dir string, keep int) error {
+	files, err := List(dir)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(files); i++ {
+		if err := os.Remove(filepath.Join(dir, files[i].Name)); err != nil {
+			return fmt.Errorf("remove old backup: %w", err)
+		}
+	}
+	return nil
+}
+
+// ValidName reports whether name looks like a file written by Create. It is
+// what keeps download requests inside the backup directory.
+func ValidName(name string) bool {
+	return validNamePattern.MatchString(name)
+}
+
+// Path returns the location of the backup called name in dir.
+func Path(dir, name string) (string, error) {
+	if !ValidName(name) {
+		return "", ErrInvalidName
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// Validate opens path read-only, runs SQLite's integrity check and returns
+// the schema version recorded by the migrations. Versions above
+// latestVersion come from a newer build and are rejected.
+func Validate(path string, latestVersion int64) (int64, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, fmt.Errorf("open backup: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", "file:"+(&url.URL{Path: path}).EscapedPath()+"?mode=ro")
+	if err != nil {
+		return 0, fmt.Errorf("open backup: %w", err)
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrNotADatabase, err)
+	}
+	if result != "ok" {
+		return 0, fmt.Errorf("%w: integrity check: %s", ErrNotADatabase, result)
+	}
+
+	version, err := migrations.Version(db)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrNotADatabase, err)
+	}
+	if version > latestVersion {
+		return version, fmt.Errorf("%w: backup is at version %d, this build knows up to %d", ErrSchemaTooNew, version, latestVersion)
+	}
+
+	return version, nil
+}
+
+// Restore replaces the database at dbPath with the backup at src. The
+// application must be stopped: the WAL and shared-memory files of the old
+// database are removed so SQLite cannot replay them over the restored copy.
+func Restore(src, dbPath string) error {
+	dir := filepath.Dir(dbPath)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(dbPath)+".restore-*")
+	if err != nil {
+		return fmt.Errorf("create restore file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	in, err := os.Open(src)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("open backup: %w", err)
+	}
+	defer in.Close()
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return fmt.Errorf("copy backup: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync restore file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close restore file: %w", err)
+	}
+
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove %s file: %w", strings.TrimPrefix(suffix, "-"), err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), dbPath); err != nil {
+		return fmt.Errorf("replace database: %w", err)
+	}
+
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
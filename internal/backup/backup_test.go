@@ -0,0 +1,148 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Simplici0/o.works/internal/db"
+	"github.com/Simplici0/o.works/internal/migrations"
+)
+
+func newTestDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	if err := migrations.Up(database); err != nil {
+		t.Fatalf("migrate db: %v", err)
+	}
+	return database
+}
+
+func TestCreateListAndPrune(t *testing.T) {
+	dir := t.TempDir()
+	database := newTestDB(t, filepath.Join(dir, "app.db"))
+	backups := filepath.Join(dir, "backups")
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var paths []string
+	for i := range 3 {
+		path, err := Create(context.Background(), database, backups, now.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatalf("Create returned error: %v", err)
+		}
+		paths = append(paths, path)
+	}
+	// Two backups in the same second get distinct names.
+	if _, err := Create(context.Background(), database, backups, now); err != nil {
+		t.Fatalf("Create returned error: %v", err)
+	}
+
+	if filepath.Base(paths[0]) != "oworks-20260102-030405.db" {
+		t.Fatalf("unexpected backup name %s", paths[0])
+	}
+	if err := os.WriteFile(filepath.Join(backups, "notes.txt"), []byte("x"), 0o600); err != nil {
+		t.Fatalf("write unrelated file: %v", err)
+	}
+
+	files, err := List(backups)
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if len(files) != 4 || files[0].Name != "oworks-20260102-050405.db" || files[0].Size == 0 {
+		t.Fatalf("unexpected backups: %+v", files)
+	}
+
+	if err := Prune(backups, 2); err != nil {
+		t.Fatalf("Prune returned error: %v", err)
+	}
+	files, err = List(backups)
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if len(files) != 2 || files[1].Name != "oworks-20260102-040405.db" {
+		t.Fatalf("unexpected backups after prune: %+v", files)
+	}
+
+	if missing, err := List(filepath.Join(dir, "missing")); err != nil || len(missing) != 0 {
+		t.Fatalf("expected no backups in a missing dir, got %v, %v", missing, err)
+	}
+}
+
+func TestValidName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"oworks-20260102-030405.db":      true,
+		"oworks-20260102-030405-2.db":    true,
+		"../oworks-20260102-030405.db":   false,
+		"oworks-latest.db":               false,
+		".oworks-20260102-030405.db.tmp": false,
+	} {
+		if got := ValidName(name); got != want {
+			t.Fatalf("ValidName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestValidateAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	source := newTestDB(t, filepath.Join(dir, "source.db"))
+	if _, err := source.Exec(`INSERT INTO materials (name, cost_per_kg) VALUES ('PLA', 80000)`); err != nil {
+		t.Fatalf("seed material: %v", err)
+	}
+	path, err := Create(context.Background(), source, filepath.Join(dir, "backups"), time.Now())
+	if err != nil {
+		t.Fatalf("Create returned error: %v", err)
+	}
+
+	latest, err := migrations.Latest()
+	if err != nil {
+		t.Fatalf("Latest returned error: %v", err)
+	}
+	version, err := Validate(path, latest)
+	if err != nil || version != latest {
+		t.Fatalf("Validate = %d, %v; want %d", version, err, latest)
+	}
+	if _, err := Validate(path, latest-1); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("expected ErrSchemaTooNew, got %v", err)
+	}
+
+	garbage := filepath.Join(dir, "garbage.db")
+	if err := os.WriteFile(garbage, []byte("not a database"), 0o600); err != nil {
+		t.Fatalf("write garbage: %v", err)
+	}
+	if _, err := Validate(garbage, latest); !errors.Is(err, ErrNotADatabase) {
+		t.Fatalf("expected ErrNotADatabase, got %v", err)
+	}
+
+	target := filepath.Join(dir, "target.db")
+	newTestDB(t, target).Close()
+	if err := os.WriteFile(target+"-wal", []byte("stale"), 0o600); err != nil {
+		t.Fatalf("write stale wal: %v", err)
+	}
+
+	if err := Restore(path, target); err != nil {
+		t.Fatalf("Restore returned error: %v", err)
+	}
+	if _, err := os.Stat(target + "-wal"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the stale wal to be removed, got %v", err)
+	}
+
+	restored, err := db.Open(target)
+	if err != nil {
+		t.Fatalf("open restored db: %v", err)
+	}
+	defer restored.Close()
+	var name string
+	if err := restored.QueryRow(`SELECT name FROM materials`).Scan(&name); err != nil || name != "PLA" {
+		t.Fatalf("expected restored material, got %q, %v", name, err)
+	}
+}
@@ -2,13 +2,31 @@ package config
 
 import (
 	"log"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	defaultAppEnv = "dev"
 	defaultDBPath = "./dev.db"
 	defaultPort   = "8080"
+
+	// DBDriverSQLite and DBDriverPostgres are the accepted DB_DRIVER values.
+	DBDriverSQLite   = "sqlite"
+	DBDriverPostgres = "postgres"
+
+	defaultSMTPPort = 587
+	defaultSMTPFrom = "o.works <no-reply@localhost>"
+
+	defaultSessionIdleTimeout = 2 * time.Hour
+	defaultSessionMaxAge      = 7 * 24 * time.Hour
+
+	defaultBackupDir      = "./backups"
+	defaultBackupInterval = 24 * time.Hour
+	defaultBackupKeep     = 7
 )
 
 // Config holds application configuration sourced from environment variables.
@@ -19,6 +37,51 @@ type Config struct {
 	AppEnv        string
 	DBPath        string
 	Port          string
+	// DBDriver selects the database: SQLite at DBPath, or PostgreSQL at
+	// DatabaseURL.
+	DBDriver    string
+	DatabaseURL string
+	// MigrateOnStartup applies pending migrations when the server starts.
+	// Development always migrates.
+	MigrateOnStartup bool
+
+	// SessionIdleTimeout ends a session after this long without requests.
+	SessionIdleTimeout time.Duration
+	// SessionMaxAge ends a session this long after login, regardless of activity.
+	SessionMaxAge time.Duration
+	// RequireAdminTOTP forces users with the admin role to enroll in TOTP
+	// two-factor authentication before using the app.
+	RequireAdminTOTP bool
+
+	// AppBaseURL is the public origin used in emailed and public quote links, such as
+	// https://cotizador.example.com. It is required outside development; without
+	// it password links are never emailed and on-screen links use the request host.
+	AppBaseURL string
+
+	// TrustedProxies lists the reverse proxies whose X-Forwarded-For and
+	// X-Real-IP headers name the client. Without it the connection's peer
+	// address is the client, so behind a proxy every request shares one IP
+	// for login throttling and session records.
+	TrustedProxies []netip.Prefix
+
+	// SMTPHost enables email delivery. Without it development only logs that a
+	// message was sent and production shows admins the link to share instead.
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	// MetricsToken enables /metrics for scrapers that send it as a bearer
+	// token. Without it the endpoint is not served.
+	MetricsToken string
+
+	// BackupDir holds database backups taken by the scheduler and the admin UI.
+	BackupDir string
+	// BackupInterval is the time between scheduled backups; zero disables them.
+	BackupInterval time.Duration
+	// BackupKeep is how many backups are kept; older ones are deleted.
+	BackupKeep int
 }
 
 // IsDev reports whether the app is running in development mode.
@@ -26,6 +89,11 @@ func (c Config) IsDev() bool {
 	return c.AppEnv == defaultAppEnv
 }
 
+// IsPostgres reports whether the app stores its data in PostgreSQL.
+func (c Config) IsPostgres() bool {
+	return c.DBDriver == DBDriverPostgres
+}
+
 // Load reads environment variables and returns a populated Config.
 func Load() Config {
 	// Best-effort: load local dev environment variables.
@@ -39,17 +107,60 @@ func Load() Config {
 		AppEnv:        os.Getenv("APP_ENV"),
 		DBPath:        os.Getenv("DB_PATH"),
 		Port:          os.Getenv("PORT"),
+		DBDriver:      strings.ToLower(strings.TrimSpace(os.Getenv("DB_DRIVER"))),
+		DatabaseURL:   os.Getenv("DATABASE_URL"),
+
+		MigrateOnStartup: boolFromEnv("MIGRATE_ON_STARTUP"),
+
+		SessionIdleTimeout: durationFromEnv("SESSION_IDLE_TIMEOUT", defaultSessionIdleTimeout),
+		SessionMaxAge:      durationFromEnv("SESSION_MAX_AGE", defaultSessionMaxAge),
+		RequireAdminTOTP:   boolFromEnv("REQUIRE_ADMIN_2FA"),
+
+		AppBaseURL:     strings.TrimRight(os.Getenv("APP_BASE_URL"), "/"),
+		TrustedProxies: prefixesFromEnv("TRUSTED_PROXIES"),
+
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     intFromEnv("SMTP_PORT", defaultSMTPPort),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:     os.Getenv("SMTP_FROM"),
+
+		MetricsToken: os.Getenv("METRICS_TOKEN"),
+
+		BackupDir:      os.Getenv("BACKUP_DIR"),
+		BackupInterval: backupIntervalFromEnv("BACKUP_INTERVAL", defaultBackupInterval),
+		BackupKeep:     intFromEnv("BACKUP_KEEP", defaultBackupKeep),
 	}
 
 	if cfg.AppEnv == "" {
 		cfg.AppEnv = defaultAppEnv
 	}
+	if cfg.IsDev() {
+		cfg.MigrateOnStartup = true
+	}
 	if cfg.DBPath == "" {
 		cfg.DBPath = defaultDBPath
 	}
+	switch cfg.DBDriver {
+	case "":
+		cfg.DBDriver = DBDriverSQLite
+	case DBDriverSQLite, DBDriverPostgres:
+	default:
+		log.Printf("warning: invalid DB_DRIVER %q, using %s", cfg.DBDriver, DBDriverSQLite)
+		cfg.DBDriver = DBDriverSQLite
+	}
+	if cfg.IsPostgres() && cfg.DatabaseURL == "" {
+		log.Print("warning: DB_DRIVER is postgres but DATABASE_URL is not set")
+	}
 	if cfg.Port == "" {
 		cfg.Port = defaultPort
 	}
+	if cfg.SMTPFrom == "" {
+		cfg.SMTPFrom = defaultSMTPFrom
+	}
+	if cfg.BackupDir == "" {
+		cfg.BackupDir = defaultBackupDir
+	}
 
 	if cfg.AdminEmail == "" {
 		log.Print("warning: ADMIN_EMAIL is not set")
@@ -63,3 +174,83 @@ func Load() Config {
 
 	return cfg
 }
+
+// durationFromEnv parses a Go duration such as "30m" or "168h". Missing or
+// invalid values fall back to def.
+func durationFromEnv(key string, def time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		log.Printf("warning: invalid %s %q, using %s", key, raw, def)
+		return def
+	}
+
+	return d
+}
+
+// backupIntervalFromEnv is durationFromEnv that also accepts "0" or "off" to
+// disable scheduled backups.
+func backupIntervalFromEnv(key string, def time.Duration) time.Duration {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(key))) {
+	case "0", "off":
+		return 0
+	default:
+		return durationFromEnv(key, def)
+	}
+}
+
+// intFromEnv parses a positive integer. Missing or invalid values fall back
+// to def.
+func intFromEnv(key string, def int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		log.Printf("warning: invalid %s %q, using %d", key, raw, def)
+		return def
+	}
+
+	return n
+}
+
+// prefixesFromEnv parses a comma-separated list of IP addresses and CIDR
+// ranges. Invalid entries are skipped with a warning.
+func prefixesFromEnv(key string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, raw := range strings.Split(os.Getenv(key), ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		if prefix, err := netip.ParsePrefix(raw); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(raw)
+		if err != nil {
+			log.Printf("warning: invalid %s entry %q, ignoring it", key, raw)
+			continue
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return prefixes
+}
+
+// boolFromEnv treats "1", "true", "yes" and "on" (any case) as true.
+func boolFromEnv(key string) bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(key))) {
+	case "1", "true", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
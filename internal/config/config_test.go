@@ -0,0 +1,75 @@
+package config
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDurationFromEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{name: "missing", value: "", want: time.Hour},
+		{name: "valid", value: "30m", want: 30 * time.Minute},
+		{name: "invalid", value: "soon", want: time.Hour},
+		{name: "negative", value: "-5m", want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_DURATION", tt.value)
+			if got := durationFromEnv("TEST_DURATION", time.Hour); got != tt.want {
+				t.Fatalf("durationFromEnv = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBoolFromEnv(t *testing.T) {
+	for value, want := range map[string]bool{"": false, "0": false, "no": false, "1": true, "TRUE": true, "on": true} {
+		t.Setenv("TEST_BOOL", value)
+		if got := boolFromEnv("TEST_BOOL"); got != want {
+			t.Fatalf("boolFromEnv(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestIntFromEnv(t *testing.T) {
+	for value, want := range map[string]int{"": 587, "25": 25, "abc": 587, "0": 587} {
+		t.Setenv("TEST_INT", value)
+		if got := intFromEnv("TEST_INT", 587); got != want {
+			t.Fatalf("intFromEnv(%q) = %d, want %d", value, got, want)
+		}
+	}
+}
+
+func TestBackupIntervalFromEnv(t *testing.T) {
+	for value, want := range map[string]time.Duration{"": 24 * time.Hour, "6h": 6 * time.Hour, "off": 0, "0": 0, "nunca": 24 * time.Hour} {
+		t.Setenv("TEST_BACKUP_INTERVAL", value)
+		if got := backupIntervalFromEnv("TEST_BACKUP_INTERVAL", 24*time.Hour); got != want {
+			t.Fatalf("backupIntervalFromEnv(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
+
+func TestPrefixesFromEnv(t *testing.T) {
+	t.Setenv("TEST_PREFIXES", " 10.0.0.0/8, 192.168.1.7 ,proxy, ::1,172.16.5.9/12")
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.7/32"),
+		netip.MustParsePrefix("::1/128"),
+		netip.MustParsePrefix("172.16.0.0/12"),
+	}
+	if got := prefixesFromEnv("TEST_PREFIXES"); !slices.Equal(got, want) {
+		t.Fatalf("prefixesFromEnv = %v, want %v", got, want)
+	}
+
+	t.Setenv("TEST_PREFIXES", "")
+	if got := prefixesFromEnv("TEST_PREFIXES"); got != nil {
+		t.Fatalf("prefixesFromEnv with no value = %v, want nil", got)
+	}
+}
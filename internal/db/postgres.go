@@ -0,0 +1,168 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// OpenPostgres opens a PostgreSQL database from a lib/pq connection string
+// and validates connectivity.
+//
+// The application's SQL is written with ? placeholders; the connection
+// rewrites them to $1, $2, ... so the same statements run on SQLite and
+// PostgreSQL. Every session uses UTC, which makes CURRENT_TIMESTAMP match the
+// values SQLite stores.
+func OpenPostgres(dsn string) (*sql.DB, error) {
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("parse postgres dsn: %w", err)
+	}
+
+	db := sql.OpenDB(rebindConnector{connector: connector})
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ping postgres database: %w", err)
+	}
+
+	return db, nil
+}
+
+// IsPostgres reports whether db was opened with OpenPostgres.
+func IsPostgres(db *sql.DB) bool {
+	_, ok := db.Driver().(*pq.Driver)
+	return ok
+}
+
+// Rebind rewrites ? placeholders to PostgreSQL's numbered form. Question
+// marks inside quoted strings and identifiers are left alone.
+func Rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+type rebindConnector struct {
+	connector driver.Connector
+}
+
+func (c rebindConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := rebindConn{conn: conn}
+	if _, err := rc.ExecContext(ctx, `SET TIME ZONE 'UTC'`, nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("set postgres time zone: %w", err)
+	}
+	return rc, nil
+}
+
+func (c rebindConnector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
+
+// rebindConn passes everything through to the lib/pq connection, rebinding
+// the query text on the way.
+type rebindConn struct {
+	conn driver.Conn
+}
+
+func (c rebindConn) Prepare(query string) (driver.Stmt, error) {
+	return c.conn.Prepare(Rebind(query))
+}
+
+func (c rebindConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, Rebind(query))
+	}
+	return c.conn.Prepare(Rebind(query))
+}
+
+func (c rebindConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, Rebind(query), args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c rebindConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, Rebind(query), args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c rebindConn) Close() error {
+	return c.conn.Close()
+}
+
+// Begin is required by driver.Conn; database/sql calls BeginTx.
+func (c rebindConn) Begin() (driver.Tx, error) {
+	return c.conn.Begin()
+}
+
+func (c rebindConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.conn.Begin()
+}
+
+func (c rebindConn) Ping(ctx context.Context) error {
+	if p, ok := c.conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c rebindConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c rebindConn) IsValid() bool {
+	if v, ok := c.conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c rebindConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
@@ -0,0 +1,18 @@
+package db
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	tests := map[string]string{
+		`SELECT 1`:                                     `SELECT 1`,
+		`SELECT id FROM users WHERE email = ?`:         `SELECT id FROM users WHERE email = $1`,
+		`UPDATE t SET a = ?, b = ? WHERE id IN (?, ?)`: `UPDATE t SET a = $1, b = $2 WHERE id IN ($3, $4)`,
+		`SELECT '?', "odd?name" FROM t WHERE x = ?`:    `SELECT '?', "odd?name" FROM t WHERE x = $1`,
+		`SELECT 'it''s ?' WHERE a = ? AND b = ?`:       `SELECT 'it''s ?' WHERE a = $1 AND b = $2`,
+	}
+	for query, want := range tests {
+		if got := Rebind(query); got != want {
+			t.Fatalf("Rebind(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
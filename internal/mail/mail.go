@@ -0,0 +1,107 @@
+// Package mail sends plain-text transactional email.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a plain-text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers messages.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPSender delivers messages through an SMTP relay. Authentication is only
+// attempted when Username is set; net/smtp refuses to send credentials over an
+// unencrypted connection to anything but localhost.
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Send implements Sender.
+func (s SMTPSender) Send(ctx context.Context, msg Message) error {
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	// From may include a display name; the envelope needs the bare address.
+	from, err := netmail.ParseAddress(s.From)
+	if err != nil {
+		return fmt.Errorf("parse mail from address: %w", err)
+	}
+
+	data, err := buildMessage(s.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, from.Address, []string{msg.To}, data)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("send mail to %s: %w", msg.To, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("send mail to %s: %w", msg.To, ctx.Err())
+	}
+}
+
+// LogSender logs that a message would have been sent instead of sending it. It
+// is only meant for development without an SMTP host. The body is left out
+// because it carries live reset and invite tokens.
+type LogSender struct{}
+
+// Send implements Sender.
+func (LogSender) Send(ctx context.Context, msg Message) error {
+	slog.InfoContext(ctx, "mail not sent: no SMTP host configured", "to", msg.To, "subject", msg.Subject)
+	return nil
+}
+
+func buildMessage(from string, msg Message, now time.Time) ([]byte, error) {
+	for _, v := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("mail header contains a line break")
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,137 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer accepts a single message and returns what it received.
+type fakeSMTPServer struct {
+	addr     string
+	received chan receivedMail
+}
+
+type receivedMail struct {
+	from string
+	to   []string
+	data string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+
+	srv := &fakeSMTPServer{addr: ln.Addr().String(), received: make(chan receivedMail, 1)}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		srv.serve(conn)
+	}()
+
+	return srv
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+
+	var mail receivedMail
+	reply("220 localhost ESMTP fake")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimRight(line, "\r\n")
+		upper := strings.ToUpper(cmd)
+
+		switch {
+		case strings.HasPrefix(upper, "EHLO"), strings.HasPrefix(upper, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(upper, "MAIL FROM:"):
+			mail.from = strings.Trim(cmd[len("MAIL FROM:"):], "<> ")
+			reply("250 OK")
+		case strings.HasPrefix(upper, "RCPT TO:"):
+			mail.to = append(mail.to, strings.Trim(cmd[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case upper == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			mail.data = data.String()
+			reply("250 OK")
+		case upper == "QUIT":
+			reply("221 Bye")
+			s.received <- mail
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTPSenderSend(t *testing.T) {
+	fake := newFakeSMTPServer(t)
+	host, portText, _ := net.SplitHostPort(fake.addr)
+	port, _ := strconv.Atoi(portText)
+
+	sender := SMTPSender{Host: host, Port: port, From: "o.works <no-reply@oworks.test>"}
+	err := sender.Send(context.Background(), Message{
+		To:      "ana@x.com",
+		Subject: "Restablece tu contraseña",
+		Body:    "Hola\nUsa este enlace.",
+	})
+	if err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+
+	select {
+	case got := <-fake.received:
+		if got.from != "no-reply@oworks.test" {
+			t.Fatalf("unexpected envelope sender: %q", got.from)
+		}
+		if len(got.to) != 1 || got.to[0] != "ana@x.com" {
+			t.Fatalf("unexpected recipients: %v", got.to)
+		}
+		if !strings.Contains(got.data, "To: ana@x.com\r\n") || !strings.Contains(got.data, "Subject: =?utf-8?q?Restablece_tu_contrase=C3=B1a?=") {
+			t.Fatalf("unexpected headers:\n%s", got.data)
+		}
+		if !strings.Contains(got.data, "\r\n\r\nHola\r\nUsa este enlace.") {
+			t.Fatalf("unexpected body:\n%s", got.data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fake server received nothing")
+	}
+}
+
+func TestBuildMessageRejectsHeaderInjection(t *testing.T) {
+	if _, err := buildMessage("a@x.com", Message{To: "b@x.com\r\nBcc: c@x.com"}, time.Now()); err == nil {
+		t.Fatal("expected header injection to be rejected")
+	}
+}
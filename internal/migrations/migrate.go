@@ -1,23 +1,125 @@
 package migrations
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"io/fs"
+	"path"
+	"time"
 
 	"github.com/pressly/goose/v3"
-)
 
-const sqliteDialect = "sqlite3"
+	"github.com/Simplici0/o.works/internal/db"
+	sqlfiles "github.com/Simplici0/o.works/migrations"
+)
 
-// Up runs all pending SQL migrations found in migrationsDir.
-func Up(db *sql.DB, migrationsDir string) error {
-	if err := goose.SetDialect(sqliteDialect); err != nil {
-		return fmt.Errorf("set goose dialect: %w", err)
+// Up runs all pending SQL migrations embedded in the binary.
+func Up(db *sql.DB) error {
+	provider, err := newProvider(db)
+	if err != nil {
+		return err
 	}
 
-	if err := goose.Up(db, migrationsDir); err != nil {
+	if _, err := provider.Up(context.Background()); err != nil {
 		return fmt.Errorf("run goose up migrations: %w", err)
 	}
 
 	return nil
 }
+
+// Down rolls back the most recently applied migration and returns its
+// version.
+func Down(db *sql.DB) (int64, error) {
+	provider, err := newProvider(db)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := provider.Down(context.Background())
+	if err != nil {
+		return 0, fmt.Errorf("run goose down migration: %w", err)
+	}
+
+	return result.Source.Version, nil
+}
+
+// Status describes one embedded migration and whether db has applied it.
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// List returns the status of every embedded migration, oldest first.
+func List(db *sql.DB) ([]Status, error) {
+	provider, err := newProvider(db)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := provider.Status(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("read migration status: %w", err)
+	}
+
+	statuses := make([]Status, 0, len(found))
+	for _, m := range found {
+		statuses = append(statuses, Status{
+			Version:   m.Source.Version,
+			Name:      path.Base(m.Source.Path),
+			Applied:   m.State == goose.StateApplied,
+			AppliedAt: m.AppliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+// Latest returns the highest embedded migration version.
+func Latest() (int64, error) {
+	names, err := fs.Glob(sqlfiles.FS, "*.sql")
+	if err != nil {
+		return 0, fmt.Errorf("list migrations: %w", err)
+	}
+
+	var latest int64
+	for _, name := range names {
+		version, err := goose.NumericComponent(name)
+		if err != nil {
+			return 0, fmt.Errorf("parse migration %s: %w", name, err)
+		}
+		latest = max(latest, version)
+	}
+	return latest, nil
+}
+
+// Version returns the schema version recorded in db. Unlike goose's own
+// helpers it never creates the version table, so it is safe on read-only
+// databases.
+func Version(db *sql.DB) (int64, error) {
+	var version sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(version_id) FROM goose_db_version WHERE is_applied`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return version.Int64, nil
+}
+
+// newProvider picks the migration set matching the database db was opened
+// with.
+func newProvider(database *sql.DB) (*goose.Provider, error) {
+	dialect, files := goose.DialectSQLite3, fs.FS(sqlfiles.FS)
+	if db.IsPostgres(database) {
+		sub, err := fs.Sub(sqlfiles.PostgresFS, "postgres")
+		if err != nil {
+			return nil, fmt.Errorf("load postgres migrations: %w", err)
+		}
+		dialect, files = goose.DialectPostgres, sub
+	}
+
+	provider, err := goose.NewProvider(dialect, database, files)
+	if err != nil {
+		return nil, fmt.Errorf("create goose provider: %w", err)
+	}
+	return provider, nil
+}
@@ -0,0 +1,110 @@
+package migrations
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"github.com/pressly/goose/v3"
+
+	"github.com/Simplici0/o.works/internal/db"
+	sqlfiles "github.com/Simplici0/o.works/migrations"
+)
+
+func TestUpAppliesEmbeddedMigrations(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+
+	names, err := fs.Glob(sqlfiles.FS, "*.sql")
+	if err != nil || len(names) == 0 {
+		t.Fatalf("expected embedded migrations, got %v, %v", names, err)
+	}
+	latest, err := Latest()
+	if err != nil {
+		t.Fatalf("Latest returned error: %v", err)
+	}
+	if latest != int64(len(names)) {
+		t.Fatalf("Latest = %d, want %d", latest, len(names))
+	}
+
+	for range 2 {
+		if err := Up(database); err != nil {
+			t.Fatalf("Up returned error: %v", err)
+		}
+	}
+	if version, err := Version(database); err != nil || version != latest {
+		t.Fatalf("Version = %d, %v; want %d", version, err, latest)
+	}
+
+	rolledBack, err := Down(database)
+	if err != nil || rolledBack != latest {
+		t.Fatalf("Down = %d, %v; want %d", rolledBack, err, latest)
+	}
+	statuses, err := List(database)
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if len(statuses) != len(names) || !statuses[0].Applied || statuses[len(statuses)-1].Applied || statuses[0].Name != names[0] {
+		t.Fatalf("unexpected statuses: %+v", statuses)
+	}
+}
+
+func TestTaxRulesSeedIVAFromRateConfig(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+
+	provider, err := newProvider(database)
+	if err != nil {
+		t.Fatalf("newProvider returned error: %v", err)
+	}
+	if _, err := provider.UpTo(context.Background(), 1); err != nil {
+		t.Fatalf("UpTo(1) returned error: %v", err)
+	}
+	if _, err := database.Exec(`
+		INSERT INTO rate_config (id, machine_hourly_rate, labor_per_minute, overhead_fixed, overhead_percent, failure_rate_percent, tax_percent, currency)
+		VALUES (1, 0, 0, 0, 0, 0, 16, 'COP')
+	`); err != nil {
+		t.Fatalf("insert rate_config: %v", err)
+	}
+	if err := Up(database); err != nil {
+		t.Fatalf("Up returned error: %v", err)
+	}
+
+	var percent float64
+	if err := database.QueryRow(`SELECT percent FROM tax_rules WHERE name = 'IVA'`).Scan(&percent); err != nil {
+		t.Fatalf("select IVA: %v", err)
+	}
+	if percent != 16 {
+		t.Fatalf("IVA percent = %v, want 16", percent)
+	}
+}
+
+func TestPostgresMigrationsFollowSQLiteVersions(t *testing.T) {
+	names, err := fs.Glob(sqlfiles.PostgresFS, "postgres/*.sql")
+	if err != nil || len(names) == 0 {
+		t.Fatalf("expected embedded postgres migrations, got %v, %v", names, err)
+	}
+	latest, err := Latest()
+	if err != nil {
+		t.Fatalf("Latest returned error: %v", err)
+	}
+
+	var pgLatest int64
+	for _, name := range names {
+		version, err := goose.NumericComponent(name)
+		if err != nil {
+			t.Fatalf("parse %s: %v", name, err)
+		}
+		pgLatest = max(pgLatest, version)
+	}
+	if pgLatest != latest {
+		t.Fatalf("postgres migrations end at version %d, sqlite at %d", pgLatest, latest)
+	}
+}
@@ -1,85 +1,345 @@
 package pricing
 
+import "errors"
+
+// ErrFinalPriceTooLow is returned by CalculateTaxInclusive when the final price
+// does not cover packaging and shipping, leaving nothing to derive taxes from.
+var ErrFinalPriceTooLow = errors.New("final price does not cover packaging and shipping")
+
+// ErrTargetBelowCost is returned by SolveMargin when the target total is lower
+// than the total obtained with a zero margin.
+var ErrTargetBelowCost = errors.New("target total is below cost")
+
+// ErrNoCosts is returned by SolveMargin when there are no costs to apply a margin
+// percent to.
+var ErrNoCosts = errors.New("cannot solve a margin percent without costs")
+
+// ErrInvalidExchangeRate is returned by Convert when the rate is not a positive
+// number.
+var ErrInvalidExchangeRate = errors.New("exchange rate must be positive")
+
 // ItemInput represents item-level inputs used to estimate manufacturing costs.
 type ItemInput struct {
-	Grams        float64
-	PrintMinutes float64
-	LaborMinutes float64
-	Quantity     float64
-	CostPerKg    float64
+	Grams        float64 `json:"grams"`
+	PrintMinutes float64 `json:"print_minutes"`
+	LaborMinutes float64 `json:"labor_minutes"`
+	Quantity     float64 `json:"quantity"`
+	CostPerKg    float64 `json:"cost_per_kg"`
 }
 
 // GlobalInput represents global pricing parameters shared across calculations.
+//
+// When TaxEnabled is set and TaxRules is empty, TaxPercent is applied as a single
+// tax line over the pre-tax amount. TaxEnabled only governs additive taxes:
+// withholdings in TaxRules are the customer's obligation and always apply.
 type GlobalInput struct {
-	MachineHourlyRate  float64
-	LaborPerMinute     float64
-	OverheadFixed      float64
-	OverheadPercent    float64
-	FailureRatePercent float64
-	WastePercent       float64
-	MarginPercent      float64
-	TaxEnabled         bool
-	TaxPercent         float64
-	PackagingCost      float64
-	ShippingCost       float64
+	MachineHourlyRate  float64   `json:"machine_hourly_rate"`
+	LaborPerMinute     float64   `json:"labor_per_minute"`
+	OverheadFixed      float64   `json:"overhead_fixed"`
+	OverheadPercent    float64   `json:"overhead_percent"`
+	FailureRatePercent float64   `json:"failure_rate_percent"`
+	WastePercent       float64   `json:"waste_percent"`
+	MarginPercent      float64   `json:"margin_percent"`
+	TaxEnabled         bool      `json:"tax_enabled"`
+	TaxPercent         float64   `json:"tax_percent"`
+	TaxRules           []TaxRule `json:"tax_rules"`
+	PackagingCost      float64   `json:"packaging_cost"`
+	ShippingCost       float64   `json:"shipping_cost"`
+}
+
+// TaxBase identifies the amount a tax rule is applied to.
+type TaxBase string
+
+const (
+	// TaxBaseNet applies the rule to the pre-tax amount (subtotal, overhead, failure insurance and margin).
+	TaxBaseNet TaxBase = "net"
+	// TaxBaseTax applies the rule to the sum of the additive taxes computed over the net amount,
+	// e.g. ReteIVA over IVA.
+	TaxBaseTax TaxBase = "tax"
+)
+
+// TaxRule describes a named tax or withholding. Withholdings do not change the
+// quoted total; they are subtracted from the amount the customer pays.
+type TaxRule struct {
+	Name        string  `json:"name"`
+	Percent     float64 `json:"percent"`
+	Base        TaxBase `json:"base"`
+	Withholding bool    `json:"withholding"`
+}
+
+// TaxLine is the computed amount of a single tax rule.
+type TaxLine struct {
+	Name        string  `json:"name"`
+	Percent     float64 `json:"percent"`
+	BaseAmount  float64 `json:"base_amount"`
+	Amount      float64 `json:"amount"`
+	Withholding bool    `json:"withholding"`
 }
 
 // Breakdown contains all intermediate and line-item values of the pricing calculation.
 type Breakdown struct {
-	MaterialCost      float64
-	MachineCost       float64
-	LaborCost         float64
-	Subtotal          float64
-	Overhead          float64
-	FailureInsurance  float64
-	PackagingCost     float64
-	ShippingCost      float64
-	Margin            float64
-	Tax               float64
+	MaterialCost     float64   `json:"material_cost"`
+	MachineCost      float64   `json:"machine_cost"`
+	LaborCost        float64   `json:"labor_cost"`
+	Subtotal         float64   `json:"subtotal"`
+	Overhead         float64   `json:"overhead"`
+	FailureInsurance float64   `json:"failure_insurance"`
+	PackagingCost    float64   `json:"packaging_cost"`
+	ShippingCost     float64   `json:"shipping_cost"`
+	Margin           float64   `json:"margin"`
+	MarginPercent    float64   `json:"margin_percent"`
+	Tax              float64   `json:"tax"`
+	Withholdings     float64   `json:"withholdings"`
+	TaxLines         []TaxLine `json:"tax_lines"`
 }
 
 // Totals contains roll-up values from the pricing calculation.
 type Totals struct {
-	Total float64
+	PreTax  float64 `json:"pre_tax"`
+	Total   float64 `json:"total"`
+	Payable float64 `json:"payable"`
 }
 
 // Result groups the full pricing output, including detailed breakdown and totals.
 type Result struct {
-	Breakdown Breakdown
-	Totals    Totals
+	Breakdown Breakdown `json:"breakdown"`
+	Totals    Totals    `json:"totals"`
 }
 
-// Calculate computes pricing values from item-specific and global inputs.
+// Calculate computes pricing values from item-specific and global inputs. Taxes
+// are added on top of the price (tax-exclusive pricing).
 func Calculate(item ItemInput, global GlobalInput) Result {
+	c := calculateCosts(item, global)
+	margin := (global.MarginPercent / 100.0) * c.base()
+	return c.result(global, margin)
+}
+
+// CalculateTaxInclusive derives the pre-tax components from a tax-inclusive final
+// price, i.e. the Total the customer sees. global.MarginPercent is ignored: the
+// margin absorbs whatever is left after costs and taxes, and is negative when the
+// final price doesn't cover costs.
+func CalculateTaxInclusive(item ItemInput, global GlobalInput, finalPrice float64) (Result, error) {
+	c := calculateCosts(item, global)
+
+	margin, ok := c.marginFor(global, finalPrice)
+	if !ok {
+		return Result{}, ErrFinalPriceTooLow
+	}
+
+	return c.result(global, margin), nil
+}
+
+// SolveMargin returns the margin percent for which Calculate yields targetTotal
+// (taxes included when enabled, withholdings not subtracted).
+func SolveMargin(item ItemInput, global GlobalInput, targetTotal float64) (float64, error) {
+	c := calculateCosts(item, global)
+	if c.base() <= 0 {
+		return 0, ErrNoCosts
+	}
+
+	margin, ok := c.marginFor(global, targetTotal)
+	if !ok || margin < 0 {
+		return 0, ErrTargetBelowCost
+	}
+
+	return margin / c.base() * 100.0, nil
+}
+
+type costs struct {
+	materialCost     float64
+	machineCost      float64
+	laborCost        float64
+	subtotal         float64
+	overhead         float64
+	failureInsurance float64
+}
+
+func calculateCosts(item ItemInput, global GlobalInput) costs {
 	materialCost := (item.Grams / 1000.0) * item.CostPerKg * (1.0 + global.WastePercent/100.0)
 	machineCost := (item.PrintMinutes / 60.0) * global.MachineHourlyRate
 	laborCost := item.LaborMinutes * global.LaborPerMinute
 
 	subtotal := (materialCost + machineCost + laborCost) * item.Quantity
-	overhead := global.OverheadFixed + subtotal*(global.OverheadPercent/100.0)
-	failureInsurance := subtotal * (global.FailureRatePercent / 100.0)
-	margin := (global.MarginPercent / 100.0) * (subtotal + overhead + failureInsurance)
 
-	tax := 0.0
-	if global.TaxEnabled {
-		tax = (global.TaxPercent / 100.0) * (subtotal + overhead + failureInsurance + margin)
+	return costs{
+		materialCost:     materialCost,
+		machineCost:      machineCost,
+		laborCost:        laborCost,
+		subtotal:         subtotal,
+		overhead:         global.OverheadFixed + subtotal*(global.OverheadPercent/100.0),
+		failureInsurance: subtotal * (global.FailureRatePercent / 100.0),
+	}
+}
+
+// base is the amount the margin percent is applied to.
+func (c costs) base() float64 {
+	return c.subtotal + c.overhead + c.failureInsurance
+}
+
+// marginFor returns the margin for which the total, taxes included, equals total.
+// It reports false when total doesn't cover packaging and shipping.
+func (c costs) marginFor(global GlobalInput, total float64) (float64, bool) {
+	taxable := total - global.PackagingCost - global.ShippingCost
+	if taxable <= 0 {
+		return 0, false
+	}
+
+	net := taxable / (1.0 + additiveTaxRate(global))
+	return net - c.base(), true
+}
+
+func (c costs) result(global GlobalInput, margin float64) Result {
+	net := c.base() + margin
+	taxLines, tax, withholdings := applyTaxes(net, global)
+
+	marginPercent := 0.0
+	if c.base() > 0 {
+		marginPercent = margin / c.base() * 100.0
 	}
 
-	total := subtotal + overhead + failureInsurance + global.PackagingCost + global.ShippingCost + margin + tax
+	preTax := net + global.PackagingCost + global.ShippingCost
+	total := preTax + tax
 
 	return Result{
 		Breakdown: Breakdown{
-			MaterialCost:     materialCost,
-			MachineCost:      machineCost,
-			LaborCost:        laborCost,
-			Subtotal:         subtotal,
-			Overhead:         overhead,
-			FailureInsurance: failureInsurance,
+			MaterialCost:     c.materialCost,
+			MachineCost:      c.machineCost,
+			LaborCost:        c.laborCost,
+			Subtotal:         c.subtotal,
+			Overhead:         c.overhead,
+			FailureInsurance: c.failureInsurance,
 			PackagingCost:    global.PackagingCost,
 			ShippingCost:     global.ShippingCost,
 			Margin:           margin,
+			MarginPercent:    marginPercent,
 			Tax:              tax,
+			Withholdings:     withholdings,
+			TaxLines:         taxLines,
 		},
-		Totals: Totals{Total: total},
+		Totals: Totals{PreTax: preTax, Total: total, Payable: total - withholdings},
+	}
+}
+
+// Convert expresses every money amount of result in another currency. rate is
+// the number of units of the original currency per unit of the target currency.
+// Percentages are left untouched.
+func Convert(result Result, rate float64) (Result, error) {
+	if !(rate > 0) {
+		return Result{}, ErrInvalidExchangeRate
+	}
+	if rate == 1 {
+		return result, nil
+	}
+
+	b := result.Breakdown
+	converted := Result{
+		Breakdown: Breakdown{
+			MaterialCost:     b.MaterialCost / rate,
+			MachineCost:      b.MachineCost / rate,
+			LaborCost:        b.LaborCost / rate,
+			Subtotal:         b.Subtotal / rate,
+			Overhead:         b.Overhead / rate,
+			FailureInsurance: b.FailureInsurance / rate,
+			PackagingCost:    b.PackagingCost / rate,
+			ShippingCost:     b.ShippingCost / rate,
+			Margin:           b.Margin / rate,
+			MarginPercent:    b.MarginPercent,
+			Tax:              b.Tax / rate,
+			Withholdings:     b.Withholdings / rate,
+		},
+		Totals: Totals{
+			PreTax:  result.Totals.PreTax / rate,
+			Total:   result.Totals.Total / rate,
+			Payable: result.Totals.Payable / rate,
+		},
+	}
+
+	if b.TaxLines != nil {
+		converted.Breakdown.TaxLines = make([]TaxLine, len(b.TaxLines))
+		for i, line := range b.TaxLines {
+			line.BaseAmount /= rate
+			line.Amount /= rate
+			converted.Breakdown.TaxLines[i] = line
+		}
+	}
+
+	return converted, nil
+}
+
+// EffectiveTaxRules returns the rules Calculate applies for the given global input.
+// With TaxEnabled unset only the withholdings remain.
+func EffectiveTaxRules(global GlobalInput) []TaxRule {
+	if global.TaxEnabled {
+		if len(global.TaxRules) == 0 {
+			return []TaxRule{{Name: "Impuesto", Percent: global.TaxPercent, Base: TaxBaseNet}}
+		}
+		return global.TaxRules
+	}
+
+	var withholdings []TaxRule
+	for _, rule := range global.TaxRules {
+		if rule.Withholding {
+			withholdings = append(withholdings, rule)
+		}
 	}
+	return withholdings
+}
+
+// additiveTaxRate returns the tax added per unit of net amount, so that
+// tax = net * additiveTaxRate(global).
+func additiveTaxRate(global GlobalInput) float64 {
+	netRate := 0.0
+	taxRate := 0.0
+	for _, rule := range EffectiveTaxRules(global) {
+		if rule.Withholding {
+			continue
+		}
+		if rule.Base == TaxBaseTax {
+			taxRate += rule.Percent / 100.0
+		} else {
+			netRate += rule.Percent / 100.0
+		}
+	}
+	return netRate * (1.0 + taxRate)
+}
+
+// applyTaxes returns one line per effective rule, in rule order, together with the
+// sum of additive taxes and the sum of withholdings.
+func applyTaxes(net float64, global GlobalInput) ([]TaxLine, float64, float64) {
+	rules := EffectiveTaxRules(global)
+	if len(rules) == 0 {
+		return nil, 0, 0
+	}
+
+	netTax := 0.0
+	for _, rule := range rules {
+		if !rule.Withholding && rule.Base != TaxBaseTax {
+			netTax += net * (rule.Percent / 100.0)
+		}
+	}
+
+	lines := make([]TaxLine, 0, len(rules))
+	tax := 0.0
+	withholdings := 0.0
+	for _, rule := range rules {
+		base := net
+		if rule.Base == TaxBaseTax {
+			base = netTax
+		}
+		amount := base * (rule.Percent / 100.0)
+		if rule.Withholding {
+			withholdings += amount
+		} else {
+			tax += amount
+		}
+		lines = append(lines, TaxLine{
+			Name:        rule.Name,
+			Percent:     rule.Percent,
+			BaseAmount:  base,
+			Amount:      amount,
+			Withholding: rule.Withholding,
+		})
+	}
+
+	return lines, tax, withholdings
 }
@@ -1,6 +1,7 @@
 package pricing
 
 import (
+	"errors"
 	"math"
 	"testing"
 )
@@ -86,3 +87,186 @@ func TestCalculate_OverheadFixedAndPercent(t *testing.T) {
 	nearlyEqual(t, "overhead", result.Breakdown.Overhead, 32)
 	nearlyEqual(t, "total", result.Totals.Total, 142)
 }
+
+func TestCalculate_TaxRulesWithWithholdings(t *testing.T) {
+	item := ItemInput{Grams: 1000, Quantity: 1, CostPerKg: 100}
+	global := GlobalInput{
+		TaxEnabled:   true,
+		TaxPercent:   99,
+		ShippingCost: 10,
+		TaxRules: []TaxRule{
+			{Name: "IVA", Percent: 19, Base: TaxBaseNet},
+			{Name: "Retención en la fuente", Percent: 2.5, Base: TaxBaseNet, Withholding: true},
+			{Name: "ReteIVA", Percent: 15, Base: TaxBaseTax, Withholding: true},
+		},
+	}
+
+	result := Calculate(item, global)
+
+	if len(result.Breakdown.TaxLines) != 3 {
+		t.Fatalf("expected 3 tax lines, got %+v", result.Breakdown.TaxLines)
+	}
+	nearlyEqual(t, "iva", result.Breakdown.TaxLines[0].Amount, 19)
+	nearlyEqual(t, "retefuente", result.Breakdown.TaxLines[1].Amount, 2.5)
+	nearlyEqual(t, "reteiva base", result.Breakdown.TaxLines[2].BaseAmount, 19)
+	nearlyEqual(t, "reteiva", result.Breakdown.TaxLines[2].Amount, 2.85)
+	nearlyEqual(t, "tax", result.Breakdown.Tax, 19)
+	nearlyEqual(t, "withholdings", result.Breakdown.Withholdings, 5.35)
+	nearlyEqual(t, "total", result.Totals.Total, 129)
+	nearlyEqual(t, "payable", result.Totals.Payable, 123.65)
+}
+
+func TestCalculate_TaxDisabledKeepsWithholdings(t *testing.T) {
+	item := ItemInput{Grams: 1000, Quantity: 1, CostPerKg: 100}
+	global := GlobalInput{
+		TaxPercent: 99,
+		TaxRules: []TaxRule{
+			{Name: "IVA", Percent: 19, Base: TaxBaseNet},
+			{Name: "Retención en la fuente", Percent: 2.5, Base: TaxBaseNet, Withholding: true},
+			{Name: "ReteIVA", Percent: 15, Base: TaxBaseTax, Withholding: true},
+		},
+	}
+
+	result := Calculate(item, global)
+
+	if len(result.Breakdown.TaxLines) != 2 {
+		t.Fatalf("expected 2 withholding lines, got %+v", result.Breakdown.TaxLines)
+	}
+	nearlyEqual(t, "retefuente", result.Breakdown.TaxLines[0].Amount, 2.5)
+	nearlyEqual(t, "reteiva", result.Breakdown.TaxLines[1].Amount, 0)
+	nearlyEqual(t, "tax", result.Breakdown.Tax, 0)
+	nearlyEqual(t, "withholdings", result.Breakdown.Withholdings, 2.5)
+	nearlyEqual(t, "total", result.Totals.Total, 100)
+	nearlyEqual(t, "payable", result.Totals.Payable, 97.5)
+
+	global.TaxRules = global.TaxRules[:1]
+	result = Calculate(item, global)
+	if len(result.Breakdown.TaxLines) != 0 {
+		t.Fatalf("expected no tax lines, got %+v", result.Breakdown.TaxLines)
+	}
+	nearlyEqual(t, "payable without withholdings", result.Totals.Payable, 100)
+}
+
+func TestCalculateTaxInclusive_DerivesPreTaxComponents(t *testing.T) {
+	item := ItemInput{Grams: 1000, Quantity: 1, CostPerKg: 100}
+	global := GlobalInput{
+		MarginPercent: 99,
+		TaxEnabled:    true,
+		TaxRules:      []TaxRule{{Name: "IVA", Percent: 19, Base: TaxBaseNet}},
+		ShippingCost:  10,
+	}
+
+	result, err := CalculateTaxInclusive(item, global, 129)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	nearlyEqual(t, "margin", result.Breakdown.Margin, 0)
+	nearlyEqual(t, "tax", result.Breakdown.Tax, 19)
+	nearlyEqual(t, "preTax", result.Totals.PreTax, 110)
+	nearlyEqual(t, "total", result.Totals.Total, 129)
+
+	// Same inputs priced the tax-exclusive way must round-trip.
+	global.MarginPercent = 30
+	exclusive := Calculate(item, global)
+	inclusive, err := CalculateTaxInclusive(item, global, exclusive.Totals.Total)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	nearlyEqual(t, "round-trip marginPercent", inclusive.Breakdown.MarginPercent, 30)
+	nearlyEqual(t, "round-trip tax", inclusive.Breakdown.Tax, exclusive.Breakdown.Tax)
+}
+
+func TestCalculateTaxInclusive_NegativeMarginAndTooLow(t *testing.T) {
+	item := ItemInput{Grams: 1000, Quantity: 1, CostPerKg: 100}
+	global := GlobalInput{TaxEnabled: true, TaxPercent: 19, PackagingCost: 5}
+
+	result, err := CalculateTaxInclusive(item, global, 64.5)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	nearlyEqual(t, "margin", result.Breakdown.Margin, -50)
+	nearlyEqual(t, "marginPercent", result.Breakdown.MarginPercent, -50)
+
+	if _, err := CalculateTaxInclusive(item, global, 5); err != ErrFinalPriceTooLow {
+		t.Fatalf("expected ErrFinalPriceTooLow, got %v", err)
+	}
+}
+
+func TestSolveMargin_ReachesTargetTotal(t *testing.T) {
+	item := ItemInput{Grams: 500, PrintMinutes: 60, LaborMinutes: 15, Quantity: 2, CostPerKg: 20}
+	global := GlobalInput{
+		MachineHourlyRate: 30,
+		LaborPerMinute:    1,
+		OverheadFixed:     10,
+		OverheadPercent:   20,
+		TaxEnabled:        true,
+		TaxRules: []TaxRule{
+			{Name: "IVA", Percent: 19, Base: TaxBaseNet},
+			{Name: "ReteIVA", Percent: 15, Base: TaxBaseTax, Withholding: true},
+		},
+		ShippingCost: 12,
+	}
+
+	marginPercent, err := SolveMargin(item, global, 250)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	global.MarginPercent = marginPercent
+	nearlyEqual(t, "total", Calculate(item, global).Totals.Total, 250)
+}
+
+func TestSolveMargin_TargetBelowCost(t *testing.T) {
+	item := ItemInput{Grams: 1000, Quantity: 1, CostPerKg: 100}
+	global := GlobalInput{TaxEnabled: true, TaxPercent: 19}
+
+	if _, err := SolveMargin(item, global, 118); err != ErrTargetBelowCost {
+		t.Fatalf("expected ErrTargetBelowCost, got %v", err)
+	}
+
+	marginPercent, err := SolveMargin(item, global, 119)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	nearlyEqual(t, "marginPercent", marginPercent, 0)
+
+	if _, err := SolveMargin(ItemInput{}, global, 119); err != ErrNoCosts {
+		t.Fatalf("expected ErrNoCosts, got %v", err)
+	}
+}
+
+func TestConvert_DividesEveryAmount(t *testing.T) {
+	item := ItemInput{Grams: 1000, Quantity: 1, CostPerKg: 4000}
+	global := GlobalInput{
+		MarginPercent: 25,
+		TaxEnabled:    true,
+		TaxRules: []TaxRule{
+			{Name: "IVA", Percent: 19, Base: TaxBaseNet},
+			{Name: "Retención", Percent: 2.5, Base: TaxBaseNet, Withholding: true},
+		},
+		ShippingCost: 8000,
+	}
+
+	base := Calculate(item, global)
+	converted, err := Convert(base, 4000)
+	if err != nil {
+		t.Fatalf("Convert returned error: %v", err)
+	}
+
+	nearlyEqual(t, "materialCost", converted.Breakdown.MaterialCost, 1)
+	nearlyEqual(t, "shippingCost", converted.Breakdown.ShippingCost, 2)
+	nearlyEqual(t, "margin", converted.Breakdown.Margin, 0.25)
+	nearlyEqual(t, "marginPercent", converted.Breakdown.MarginPercent, 25)
+	nearlyEqual(t, "iva", converted.Breakdown.TaxLines[0].Amount, 0.2375)
+	nearlyEqual(t, "iva percent", converted.Breakdown.TaxLines[0].Percent, 19)
+	nearlyEqual(t, "total", converted.Totals.Total, base.Totals.Total/4000)
+	nearlyEqual(t, "payable", converted.Totals.Payable, base.Totals.Payable/4000)
+	nearlyEqual(t, "original untouched", base.Breakdown.TaxLines[0].Amount, 950)
+
+	for _, rate := range []float64{0, -4000, math.NaN()} {
+		if _, err := Convert(base, rate); !errors.Is(err, ErrInvalidExchangeRate) {
+			t.Fatalf("Convert(%v) error = %v, want ErrInvalidExchangeRate", rate, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Package quotepdf renders stored quotes as branded PDF documents.
+package quotepdf
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// Item is a row of the items table.
+type Item struct {
+	Description string
+	Quantity    float64
+}
+
+// Line is a row of the amounts table. Emphasized lines are printed in bold.
+type Line struct {
+	Label    string
+	Amount   float64
+	Emphasis bool
+}
+
+// Document holds everything printed on a quote PDF. Amounts are expressed in
+// Currency.
+type Document struct {
+	Brand      string
+	Heading    string
+	Reference  string
+	Customer   string
+	Date       string
+	ValidUntil string
+	Currency   string
+	Items      []Item
+	Lines      []Line
+	Notes      string
+	Terms      string
+}
+
+const (
+	pageMargin = 15.0
+	logoSize   = 16.0
+)
+
+// Render writes doc as a PDF to w. logoSVG is drawn in the header when not empty.
+func Render(w io.Writer, doc Document, logoSVG []byte) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pageMargin, pageMargin, pageMargin)
+	pdf.SetAutoPageBreak(true, pageMargin+5)
+	pdf.SetTitle(doc.Heading, true)
+	pdf.AliasNbPages("")
+
+	// Core fonts are cp1252; translate so accents and ñ survive.
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-pageMargin)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.CellFormat(0, 5, tr(fmt.Sprintf("%s · página %d/{nb}", doc.Brand, pdf.PageNo())), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+	width, _ := pdf.GetPageSize()
+	contentWidth := width - 2*pageMargin
+
+	if len(logoSVG) > 0 {
+		if err := drawSVG(pdf, logoSVG, pageMargin, pageMargin, logoSize); err != nil {
+			return fmt.Errorf("draw logo: %w", err)
+		}
+	}
+	pdf.SetTextColor(20, 20, 20)
+	pdf.SetXY(pageMargin+logoSize+4, pageMargin+3)
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(80, 10, tr(doc.Brand), "", 0, "L", false, 0, "")
+
+	pdf.SetXY(pageMargin, pageMargin)
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(contentWidth, 7, tr(doc.Heading), "", 2, "R", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.CellFormat(contentWidth, 5, tr("Fecha: "+doc.Date), "", 2, "R", false, 0, "")
+	if doc.ValidUntil != "" {
+		pdf.CellFormat(contentWidth, 5, tr("Válida hasta: "+doc.ValidUntil), "", 2, "R", false, 0, "")
+	}
+	pdf.SetY(pageMargin + logoSize + 8)
+
+	if doc.Customer != "" {
+		labelValue(pdf, tr, "Cliente", doc.Customer)
+	}
+	if doc.Reference != "" {
+		labelValue(pdf, tr, "Referencia", doc.Reference)
+	}
+	pdf.Ln(4)
+
+	pdf.SetFillColor(13, 19, 26)
+	pdf.SetTextColor(255, 255, 255)
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.CellFormat(contentWidth-30, 7, tr("Descripción"), "", 0, "L", true, 0, "")
+	pdf.CellFormat(30, 7, tr("Cantidad"), "", 1, "R", true, 0, "")
+	pdf.SetTextColor(20, 20, 20)
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.SetDrawColor(200, 200, 200)
+	pdf.SetLineWidth(0.2)
+	for _, item := range doc.Items {
+		pdf.CellFormat(contentWidth-30, 7, tr(item.Description), "B", 0, "L", false, 0, "")
+		pdf.CellFormat(30, 7, fmt.Sprintf("%.0f", item.Quantity), "B", 1, "R", false, 0, "")
+	}
+	pdf.Ln(4)
+
+	amountsX := pageMargin + contentWidth/2
+	for _, line := range doc.Lines {
+		style := ""
+		if line.Emphasis {
+			style = "B"
+		}
+		pdf.SetX(amountsX)
+		pdf.SetFont("Helvetica", style, 10)
+		pdf.CellFormat(contentWidth/2-45, 6, tr(line.Label), "B", 0, "L", false, 0, "")
+		pdf.CellFormat(45, 6, tr(fmt.Sprintf("%s %s", formatAmount(line.Amount), doc.Currency)), "B", 1, "R", false, 0, "")
+	}
+
+	if doc.Notes != "" {
+		section(pdf, tr, "Notas", doc.Notes)
+	}
+	if doc.Terms != "" {
+		section(pdf, tr, "Términos y condiciones", doc.Terms)
+	}
+
+	return pdf.Output(w)
+}
+
+func labelValue(pdf *fpdf.Fpdf, tr func(string) string, label, value string) {
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.CellFormat(28, 6, tr(label+":"), "", 0, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 6, tr(value), "", 1, "L", false, 0, "")
+}
+
+func section(pdf *fpdf.Fpdf, tr func(string) string, title, body string) {
+	pdf.Ln(6)
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.CellFormat(0, 6, tr(title), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.MultiCell(0, 5, tr(body), "", "L", false)
+}
+
+// formatAmount prints amounts with thousands separators the way they are written
+// in Colombia: 1.234.567,89.
+func formatAmount(amount float64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	raw := fmt.Sprintf("%.2f", amount)
+	integer, decimals := raw[:len(raw)-3], raw[len(raw)-2:]
+
+	grouped := make([]byte, 0, len(integer)+len(integer)/3)
+	for i := range len(integer) {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			grouped = append(grouped, '.')
+		}
+		grouped = append(grouped, integer[i])
+	}
+
+	return sign + string(grouped) + "," + decimals
+}
@@ -0,0 +1,56 @@
+package quotepdf
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestFormatAmount(t *testing.T) {
+	cases := map[float64]string{
+		0:          "0,00",
+		999.5:      "999,50",
+		1000:       "1.000,00",
+		120000:     "120.000,00",
+		1234567.89: "1.234.567,89",
+		-4500.1:    "-4.500,10",
+	}
+	for amount, want := range cases {
+		if got := formatAmount(amount); got != want {
+			t.Fatalf("formatAmount(%v) = %q, want %q", amount, got, want)
+		}
+	}
+}
+
+func TestRender_WritesPDFWithLogo(t *testing.T) {
+	logo, err := os.ReadFile("../../web/static/logo.svg")
+	if err != nil {
+		t.Fatalf("read logo: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = Render(&buf, Document{
+		Brand:      "o.works",
+		Heading:    "Cotización #7",
+		Customer:   "Muñecos S.A.S.",
+		Date:       "2026-10-18",
+		ValidUntil: "2026-11-02",
+		Currency:   "COP",
+		Items:      []Item{{Description: "Impresión 3D en PLA", Quantity: 2}},
+		Lines:      []Line{{Label: "Total", Amount: 120000, Emphasis: true}},
+		Terms:      "50% de anticipo.",
+	}, logo)
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")) {
+		t.Fatalf("output is not a PDF: %q", buf.Bytes()[:16])
+	}
+}
+
+func TestRender_RejectsInvalidLogo(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Render(&buf, Document{Heading: "Cotización"}, []byte("<html></html>")); err == nil {
+		t.Fatalf("expected logo error")
+	}
+}
@@ -0,0 +1,154 @@
+package quotepdf
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// svgNode is a generic SVG element. Only the attributes of the shapes drawn by
+// drawSVG are interpreted.
+type svgNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Children []svgNode  `xml:",any"`
+}
+
+func (n svgNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (n svgNode) number(name string) float64 {
+	value, _ := strconv.ParseFloat(strings.TrimSuffix(n.attr(name), "px"), 64)
+	return value
+}
+
+// drawSVG draws a basic SVG (rect, circle, line and nested groups) into a square
+// of the given size at x, y. It's enough for the brand logo; other elements are
+// ignored.
+func drawSVG(pdf *fpdf.Fpdf, data []byte, x, y, size float64) error {
+	var root svgNode
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("parse svg: %w", err)
+	}
+	if root.XMLName.Local != "svg" {
+		return fmt.Errorf("parse svg: root element is %q", root.XMLName.Local)
+	}
+
+	viewWidth := root.number("width")
+	if fields := strings.Fields(strings.ReplaceAll(root.attr("viewBox"), ",", " ")); len(fields) == 4 {
+		viewWidth, _ = strconv.ParseFloat(fields[2], 64)
+	}
+	if viewWidth <= 0 {
+		return fmt.Errorf("parse svg: missing viewBox or width")
+	}
+
+	c := svgCanvas{pdf: pdf, x: x, y: y, scale: size / viewWidth}
+	for _, child := range root.Children {
+		c.draw(child)
+	}
+	return nil
+}
+
+type svgCanvas struct {
+	pdf   *fpdf.Fpdf
+	x, y  float64
+	scale float64
+}
+
+func (c svgCanvas) draw(n svgNode) {
+	switch n.XMLName.Local {
+	case "g":
+		for _, child := range n.Children {
+			c.draw(child)
+		}
+	case "rect":
+		style := c.paint(n)
+		if style == "" {
+			return
+		}
+		x, y := c.point(n.number("x"), n.number("y"))
+		w, h := n.number("width")*c.scale, n.number("height")*c.scale
+		if rx := n.number("rx") * c.scale; rx > 0 {
+			c.pdf.RoundedRect(x, y, w, h, rx, "1234", style)
+		} else {
+			c.pdf.Rect(x, y, w, h, style)
+		}
+	case "circle":
+		style := c.paint(n)
+		if style == "" {
+			return
+		}
+		x, y := c.point(n.number("cx"), n.number("cy"))
+		c.pdf.Circle(x, y, n.number("r")*c.scale, style)
+	case "line":
+		if c.paint(n) == "" {
+			return
+		}
+		capStyle := n.attr("stroke-linecap")
+		if capStyle == "" {
+			capStyle = "butt"
+		}
+		c.pdf.SetLineCapStyle(capStyle)
+		x1, y1 := c.point(n.number("x1"), n.number("y1"))
+		x2, y2 := c.point(n.number("x2"), n.number("y2"))
+		c.pdf.Line(x1, y1, x2, y2)
+		c.pdf.SetLineCapStyle("butt")
+	}
+}
+
+func (c svgCanvas) point(x, y float64) (float64, float64) {
+	return c.x + x*c.scale, c.y + y*c.scale
+}
+
+// paint sets fill and stroke from the node attributes and returns the fpdf
+// style string, or "" when there is nothing to paint.
+func (c svgCanvas) paint(n svgNode) string {
+	style := ""
+
+	fill := n.attr("fill")
+	if fill == "" && n.XMLName.Local != "line" {
+		fill = "#000000"
+	}
+	if r, g, b, ok := parseColor(fill); ok {
+		c.pdf.SetFillColor(r, g, b)
+		style += "F"
+	}
+
+	if r, g, b, ok := parseColor(n.attr("stroke")); ok {
+		c.pdf.SetDrawColor(r, g, b)
+		width := n.number("stroke-width")
+		if width == 0 {
+			width = 1
+		}
+		c.pdf.SetLineWidth(width * c.scale)
+		style += "D"
+	}
+
+	return style
+}
+
+// parseColor understands #rgb and #rrggbb colors.
+func parseColor(value string) (int, int, int, bool) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "#")
+	if len(value) == 3 {
+		value = string([]byte{value[0], value[0], value[1], value[1], value[2], value[2]})
+	}
+	if len(value) != 6 {
+		return 0, 0, 0, false
+	}
+
+	rgb, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	return int(rgb >> 16 & 0xff), int(rgb >> 8 & 0xff), int(rgb & 0xff), true
+}
@@ -0,0 +1,69 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed from RFC 6238 appendix B, "12345678901234567890".
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeMatchesRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8-digit codes; the last six digits are the 6-digit code.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code returned error: %v", err)
+		}
+		if got != tt.want {
+			t.Fatalf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyAllowsSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	previous, err := Code(rfcSecret, Counter(now)-1)
+	if err != nil {
+		t.Fatalf("Code returned error: %v", err)
+	}
+
+	counter, ok := Verify(rfcSecret, previous, now, 1)
+	if !ok || counter != Counter(now)-1 {
+		t.Fatalf("expected previous step to verify, got %d, %v", counter, ok)
+	}
+	if _, ok := Verify(rfcSecret, previous, now, 0); ok {
+		t.Fatal("expected previous step to fail without skew")
+	}
+	if _, ok := Verify(rfcSecret, "12345", now, 1); ok {
+		t.Fatal("expected short code to fail")
+	}
+}
+
+func TestGenerateSecretAndKeyURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret returned error: %v", err)
+	}
+	if len(secret) != 32 {
+		t.Fatalf("expected 32 base32 characters, got %q", secret)
+	}
+
+	uri := KeyURI("o.works", "admin@x.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/o.works:admin@x.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("unexpected key uri: %s", uri)
+	}
+}
@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendSignsRequest(t *testing.T) {
+	now := time.Unix(1767225600, 0)
+	var received http.Header
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	status, err := Send(context.Background(), receiver.Client(), Request{
+		URL:        receiver.URL,
+		Secret:     "s3cr3t",
+		Event:      "quote.created",
+		DeliveryID: 7,
+		Body:       []byte(`{"event":"quote.created"}`),
+	}, now)
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("Send = %d, %v", status, err)
+	}
+
+	if received.Get(EventHeader) != "quote.created" || received.Get(DeliveryHeader) != "7" {
+		t.Fatalf("unexpected headers: %v", received)
+	}
+	if err := Verify("s3cr3t", received, body, now.Add(time.Minute), 5*time.Minute); err != nil {
+		t.Fatalf("Verify returned error: %v", err)
+	}
+	if err := Verify("other", received, body, now, 5*time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got %v", err)
+	}
+	if err := Verify("s3cr3t", received, []byte(`{}`), now, 5*time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected tampered body to be rejected, got %v", err)
+	}
+	if err := Verify("s3cr3t", received, body, now.Add(time.Hour), 5*time.Minute); !errors.Is(err, ErrStaleTimestamp) {
+		t.Fatalf("expected ErrStaleTimestamp, got %v", err)
+	}
+}
+
+func TestSendReportsFailures(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusBadGateway)
+	}))
+	defer receiver.Close()
+
+	status, err := Send(context.Background(), receiver.Client(), Request{URL: receiver.URL, Body: []byte(`{}`)}, time.Now())
+	if status != http.StatusBadGateway || err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("Send = %d, %v", status, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: 30 * time.Second},
+		{attempt: 2, want: time.Minute},
+		{attempt: 4, want: 4 * time.Minute},
+		{attempt: 10, want: time.Hour},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.attempt, 30*time.Second, time.Hour); got != tt.want {
+			t.Fatalf("Backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS tax_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    percent NUMERIC NOT NULL,
+    base TEXT NOT NULL DEFAULT 'net',
+    customer_type TEXT NOT NULL DEFAULT 'all',
+    notes TEXT,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT chk_tax_rules_kind CHECK (kind IN ('tax', 'withholding')),
+    CONSTRAINT chk_tax_rules_base CHECK (base IN ('net', 'tax')),
+    CONSTRAINT chk_tax_rules_customer_type CHECK (customer_type IN ('all', 'natural', 'juridica'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_tax_rules_active ON tax_rules(active);
+
+-- IVA starts from the rate configured in rate_config.tax_percent, which from
+-- here on only applies when no tax rule is active.
+INSERT INTO tax_rules (name, kind, percent, base, customer_type, notes, active) VALUES
+    ('IVA', 'tax', COALESCE(NULLIF((SELECT tax_percent FROM rate_config WHERE id = 1), 0), 19), 'net', 'all', 'Tarifa general', TRUE),
+    ('Retención en la fuente', 'withholding', 2.5, 'net', 'juridica', 'Compras generales; ajustar según el cliente', FALSE),
+    ('ReteIVA', 'withholding', 15, 'tax', 'juridica', '15% del IVA', FALSE),
+    ('ReteICA', 'withholding', 0.966, 'net', 'juridica', 'Tarifa por mil según municipio; ajustar antes de activar', FALSE);
+
+ALTER TABLE quotes ADD COLUMN customer_type TEXT NOT NULL DEFAULT 'natural';
+ALTER TABLE quotes ADD COLUMN tax_rules_json TEXT NOT NULL DEFAULT '[]';
+ALTER TABLE quotes ADD COLUMN shipping_rate_id INTEGER REFERENCES shipping_rates(id);
+ALTER TABLE quotes ADD COLUMN packaging_rate_id INTEGER REFERENCES packaging_rates(id);
+
+-- +goose Down
+ALTER TABLE quotes DROP COLUMN packaging_rate_id;
+ALTER TABLE quotes DROP COLUMN shipping_rate_id;
+ALTER TABLE quotes DROP COLUMN tax_rules_json;
+ALTER TABLE quotes DROP COLUMN customer_type;
+
+DROP TABLE IF EXISTS tax_rules;
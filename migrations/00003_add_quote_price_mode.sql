@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE quotes ADD COLUMN price_mode TEXT NOT NULL DEFAULT 'exclusive';
+ALTER TABLE quotes ADD COLUMN final_price NUMERIC;
+
+-- +goose Down
+ALTER TABLE quotes DROP COLUMN final_price;
+ALTER TABLE quotes DROP COLUMN price_mode;
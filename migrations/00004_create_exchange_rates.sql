@@ -0,0 +1,21 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    currency TEXT NOT NULL UNIQUE,
+    rate NUMERIC NOT NULL,
+    source TEXT NOT NULL DEFAULT 'manual',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_exchange_rates_active ON exchange_rates(active);
+
+ALTER TABLE quotes ADD COLUMN currency TEXT NOT NULL DEFAULT 'COP';
+ALTER TABLE quotes ADD COLUMN exchange_rate NUMERIC NOT NULL DEFAULT 1;
+
+-- +goose Down
+ALTER TABLE quotes DROP COLUMN exchange_rate;
+ALTER TABLE quotes DROP COLUMN currency;
+
+DROP TABLE IF EXISTS exchange_rates;
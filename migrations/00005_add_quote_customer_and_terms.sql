@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE quotes ADD COLUMN customer_name TEXT;
+ALTER TABLE quotes ADD COLUMN valid_until TEXT;
+ALTER TABLE quotes ADD COLUMN terms TEXT;
+
+ALTER TABLE rate_config ADD COLUMN quote_validity_days INTEGER NOT NULL DEFAULT 15;
+ALTER TABLE rate_config ADD COLUMN quote_terms TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE rate_config DROP COLUMN quote_terms;
+ALTER TABLE rate_config DROP COLUMN quote_validity_days;
+
+ALTER TABLE quotes DROP COLUMN terms;
+ALTER TABLE quotes DROP COLUMN valid_until;
+ALTER TABLE quotes DROP COLUMN customer_name;
@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE quotes ADD COLUMN public_nonce TEXT;
+ALTER TABLE quotes ADD COLUMN decision TEXT CHECK (decision IN ('accepted', 'rejected'));
+ALTER TABLE quotes ADD COLUMN decided_at DATETIME;
+ALTER TABLE quotes ADD COLUMN decision_ip TEXT;
+ALTER TABLE quotes ADD COLUMN decision_comment TEXT;
+
+-- +goose Down
+ALTER TABLE quotes DROP COLUMN decision_comment;
+ALTER TABLE quotes DROP COLUMN decision_ip;
+ALTER TABLE quotes DROP COLUMN decided_at;
+ALTER TABLE quotes DROP COLUMN decision;
+ALTER TABLE quotes DROP COLUMN public_nonce;
@@ -0,0 +1,24 @@
+-- +goose Up
+-- Existing SHA-256 and plaintext values are upgraded on the next successful
+-- login; any new write must already be an argon2id PHC string.
+-- +goose StatementBegin
+CREATE TRIGGER users_password_hash_insert
+BEFORE INSERT ON users
+WHEN NEW.password_hash NOT LIKE '$argon2id$%'
+BEGIN
+    SELECT RAISE(ABORT, 'password_hash must be an argon2id hash');
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER users_password_hash_update
+BEFORE UPDATE OF password_hash ON users
+WHEN NEW.password_hash NOT LIKE '$argon2id$%'
+BEGIN
+    SELECT RAISE(ABORT, 'password_hash must be an argon2id hash');
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER IF EXISTS users_password_hash_update;
+DROP TRIGGER IF EXISTS users_password_hash_insert;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Users created before roles existed were all administrators.
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'admin' CHECK (role IN ('admin', 'sales', 'operator', 'viewer'));
+ALTER TABLE users ADD COLUMN active BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN active;
+ALTER TABLE users DROP COLUMN role;
@@ -0,0 +1,16 @@
+// Package migrations embeds the goose SQL migrations so the server binary
+// does not depend on its working directory.
+package migrations
+
+import "embed"
+
+// FS holds the NNNNN_name.sql SQLite migration files at its root.
+//
+//go:embed *.sql
+var FS embed.FS
+
+// PostgresFS holds the PostgreSQL migrations under postgres/. Their version
+// numbers follow the SQLite ones.
+//
+//go:embed postgres/*.sql
+var PostgresFS embed.FS
@@ -0,0 +1,251 @@
+-- PostgreSQL schema as of SQLite migration 00013. Later changes get the same
+-- version number in both directories, so a schema version means the same
+-- thing on either database.
+--
+-- Timestamps are TIMESTAMP(0) in UTC (the connection sets the time zone), the
+-- same second-precision values SQLite stores as text.
+
+-- +goose Up
+CREATE TABLE users (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL CHECK (password_hash LIKE '$argon2id$%'),
+    created_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    role TEXT NOT NULL DEFAULT 'admin' CHECK (role IN ('admin', 'sales', 'operator', 'viewer')),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    totp_secret TEXT,
+    totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    totp_last_counter BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE materials (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    name TEXT NOT NULL,
+    cost_per_kg NUMERIC NOT NULL,
+    notes TEXT,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_materials_name ON materials(name);
+CREATE INDEX idx_materials_active ON materials(active);
+
+CREATE TABLE rate_config (
+    id INTEGER PRIMARY KEY,
+    machine_hourly_rate NUMERIC NOT NULL,
+    labor_per_minute NUMERIC NOT NULL,
+    overhead_fixed NUMERIC NOT NULL,
+    overhead_percent NUMERIC NOT NULL,
+    failure_rate_percent NUMERIC NOT NULL,
+    tax_percent NUMERIC NOT NULL,
+    currency TEXT NOT NULL,
+    updated_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    quote_validity_days INTEGER NOT NULL DEFAULT 15,
+    quote_terms TEXT NOT NULL DEFAULT '',
+    CONSTRAINT chk_rate_config_singleton CHECK (id = 1)
+);
+
+CREATE TABLE shipping_rates (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    scope TEXT NOT NULL,
+    country TEXT NOT NULL,
+    city TEXT,
+    flat_cost NUMERIC NOT NULL,
+    notes TEXT,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_shipping_rates_scope_country_city ON shipping_rates(scope, country, city);
+CREATE INDEX idx_shipping_rates_active ON shipping_rates(active);
+
+CREATE TABLE packaging_rates (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    name TEXT NOT NULL,
+    flat_cost NUMERIC NOT NULL,
+    notes TEXT,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_packaging_rates_name ON packaging_rates(name);
+CREATE INDEX idx_packaging_rates_active ON packaging_rates(active);
+
+CREATE TABLE quotes (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    created_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    title TEXT,
+    notes TEXT,
+    waste_percent NUMERIC NOT NULL,
+    margin_percent NUMERIC NOT NULL,
+    tax_enabled BOOLEAN NOT NULL,
+    tax_percent_snapshot NUMERIC NOT NULL,
+    totals_json TEXT NOT NULL,
+    breakdown_json TEXT NOT NULL,
+    customer_type TEXT NOT NULL DEFAULT 'natural',
+    tax_rules_json TEXT NOT NULL DEFAULT '[]',
+    shipping_rate_id BIGINT REFERENCES shipping_rates(id),
+    packaging_rate_id BIGINT REFERENCES packaging_rates(id),
+    price_mode TEXT NOT NULL DEFAULT 'exclusive',
+    final_price NUMERIC,
+    currency TEXT NOT NULL DEFAULT 'COP',
+    exchange_rate NUMERIC NOT NULL DEFAULT 1,
+    customer_name TEXT,
+    valid_until TEXT,
+    terms TEXT,
+    public_nonce TEXT,
+    decision TEXT CHECK (decision IN ('accepted', 'rejected')),
+    decided_at TIMESTAMP(0),
+    decision_ip TEXT,
+    decision_comment TEXT
+);
+
+CREATE INDEX idx_quotes_created_at ON quotes(created_at);
+
+CREATE TABLE quote_items (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    quote_id BIGINT NOT NULL REFERENCES quotes(id) ON DELETE CASCADE,
+    material_id BIGINT NOT NULL REFERENCES materials(id),
+    grams NUMERIC NOT NULL,
+    print_minutes NUMERIC NOT NULL,
+    labor_minutes NUMERIC NOT NULL,
+    quantity NUMERIC NOT NULL
+);
+
+CREATE INDEX idx_quote_items_quote_id ON quote_items(quote_id);
+CREATE INDEX idx_quote_items_material_id ON quote_items(material_id);
+
+CREATE TABLE tax_rules (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    percent NUMERIC NOT NULL,
+    base TEXT NOT NULL DEFAULT 'net',
+    customer_type TEXT NOT NULL DEFAULT 'all',
+    notes TEXT,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT chk_tax_rules_kind CHECK (kind IN ('tax', 'withholding')),
+    CONSTRAINT chk_tax_rules_base CHECK (base IN ('net', 'tax')),
+    CONSTRAINT chk_tax_rules_customer_type CHECK (customer_type IN ('all', 'natural', 'juridica'))
+);
+
+CREATE INDEX idx_tax_rules_active ON tax_rules(active);
+
+-- IVA starts from the rate configured in rate_config.tax_percent, which from
+-- here on only applies when no tax rule is active.
+INSERT INTO tax_rules (name, kind, percent, base, customer_type, notes, active) VALUES
+    ('IVA', 'tax', COALESCE(NULLIF((SELECT tax_percent FROM rate_config WHERE id = 1), 0), 19), 'net', 'all', 'Tarifa general', TRUE),
+    ('Retención en la fuente', 'withholding', 2.5, 'net', 'juridica', 'Compras generales; ajustar según el cliente', FALSE),
+    ('ReteIVA', 'withholding', 15, 'tax', 'juridica', '15% del IVA', FALSE),
+    ('ReteICA', 'withholding', 0.966, 'net', 'juridica', 'Tarifa por mil según municipio; ajustar antes de activar', FALSE);
+
+CREATE TABLE exchange_rates (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    currency TEXT NOT NULL UNIQUE,
+    rate NUMERIC NOT NULL,
+    source TEXT NOT NULL DEFAULT 'manual',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_exchange_rates_active ON exchange_rates(active);
+
+CREATE TABLE sessions (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    ip TEXT,
+    user_agent TEXT,
+    created_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP(0) NOT NULL
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+
+CREATE TABLE user_recovery_codes (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP(0),
+    created_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_user_recovery_codes_user_id ON user_recovery_codes(user_id);
+
+CREATE TABLE password_tokens (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    purpose TEXT NOT NULL CHECK (purpose IN ('reset', 'invite')),
+    expires_at TIMESTAMP(0) NOT NULL,
+    used_at TIMESTAMP(0),
+    created_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_password_tokens_user_id ON password_tokens(user_id);
+
+CREATE TABLE api_tokens (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    name TEXT NOT NULL,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    token_prefix TEXT NOT NULL,
+    scope TEXT NOT NULL CHECK (scope IN ('read', 'read_write')),
+    created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP(0),
+    last_used_at TIMESTAMP(0)
+);
+
+CREATE INDEX idx_api_tokens_user_id ON api_tokens(user_id);
+
+CREATE TABLE webhooks (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE webhook_deliveries (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    webhook_id BIGINT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_status_code INTEGER,
+    last_error TEXT,
+    created_at TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP(0)
+);
+
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS api_tokens;
+DROP TABLE IF EXISTS password_tokens;
+DROP TABLE IF EXISTS user_recovery_codes;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS exchange_rates;
+DROP TABLE IF EXISTS tax_rules;
+DROP TABLE IF EXISTS quote_items;
+DROP TABLE IF EXISTS quotes;
+DROP TABLE IF EXISTS packaging_rates;
+DROP TABLE IF EXISTS shipping_rates;
+DROP TABLE IF EXISTS rate_config;
+DROP TABLE IF EXISTS materials;
+DROP TABLE IF EXISTS users;
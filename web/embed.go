@@ -0,0 +1,10 @@
+// Package web embeds the HTML templates and static assets served by the
+// application.
+package web
+
+import "embed"
+
+// FS holds the templates and static directories.
+//
+//go:embed templates static
+var FS embed.FS